@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"net/http"
+	"oneclick-metrics-go/metrics"
+	"strings"
+	"time"
+)
+
+// PRList /api/v1/prs 的返回结构
+type PRList struct {
+	UpdatedAt time.Time          `json:"updated_at"`
+	Count     int                `json:"count"`
+	PRs       []metrics.PRDetail `json:"prs"`
+}
+
+// ResultCount 单个报告类型的结果统计
+type ResultCount struct {
+	Success      int `json:"success"`
+	Failure      int `json:"failure"`
+	NotAvailable int `json:"notAvailable"`
+}
+
+// ProjectSummary /api/v1/projects/{key}/summary 的返回结构
+type ProjectSummary struct {
+	Instance        string                 `json:"instance,omitempty"`
+	Project         string                 `json:"project"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+	PRsByState      map[string]int         `json:"prs_by_state"`
+	MissingByState  map[string]int         `json:"missing_by_state"`
+	MissingByReport map[string]int         `json:"missing_by_report"`
+	ResultsByReport map[string]ResultCount `json:"results_by_report"`
+	BlockingPRs     []metrics.PRDetail     `json:"blocking_prs"` // 缺少必需报告的 open 状态 pr
+}
+
+// 最近一轮采集的快照，测试中替换为固定的数据
+var latestSnapshot = metrics.LatestSnapshot
+
+// Register 在 mux 上注册 JSON API
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/prs", handlePRs)
+	mux.HandleFunc("GET /api/v1/projects/{key}/summary", handleProjectSummary)
+}
+
+// 过滤条件：instance、project、repo、state 精确匹配；missing=true/false 表示是否缺少必需报告，
+// 其他取值表示缺少指定的报告，例如 missing=sast
+func handlePRs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	instance, project, repo, state, missing := q.Get("instance"), q.Get("project"), q.Get("repo"), q.Get("state"), q.Get("missing")
+
+	snapshot := latestSnapshot()
+	result := PRList{UpdatedAt: snapshot.UpdatedAt, PRs: []metrics.PRDetail{}}
+	for _, pr := range snapshot.PRs {
+		if instance != "" && pr.Instance != instance {
+			continue
+		}
+		if project != "" && pr.Project != project {
+			continue
+		}
+		if repo != "" && pr.Repo != repo {
+			continue
+		}
+		if state != "" && pr.State != state {
+			continue
+		}
+		if missing != "" && !matchMissing(pr, missing) {
+			continue
+		}
+		result.PRs = append(result.PRs, pr)
+	}
+	result.Count = len(result.PRs)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func matchMissing(pr metrics.PRDetail, missing string) bool {
+	switch strings.ToLower(missing) {
+	case "true", "1", "yes":
+		return len(pr.MissingReports) > 0
+	case "false", "0", "no":
+		return len(pr.MissingReports) == 0
+	}
+	for _, key := range pr.MissingReports {
+		if key == missing {
+			return true
+		}
+	}
+	return false
+}
+
+// 多个实例中存在同名项目时，通过 instance 参数指定实例，否则合并统计
+func handleProjectSummary(w http.ResponseWriter, r *http.Request) {
+	key, instance := r.PathValue("key"), r.URL.Query().Get("instance")
+	snapshot := latestSnapshot()
+
+	summary := ProjectSummary{
+		Instance:        instance,
+		Project:         key,
+		UpdatedAt:       snapshot.UpdatedAt,
+		PRsByState:      map[string]int{},
+		MissingByState:  map[string]int{},
+		MissingByReport: map[string]int{},
+		ResultsByReport: map[string]ResultCount{},
+		BlockingPRs:     []metrics.PRDetail{},
+	}
+	found := false
+	for _, pr := range snapshot.PRs {
+		if pr.Project != key || (instance != "" && pr.Instance != instance) {
+			continue
+		}
+		found = true
+		summary.PRsByState[pr.State]++
+		if len(pr.MissingReports) > 0 {
+			summary.MissingByState[pr.State]++
+			if pr.State == "open" {
+				summary.BlockingPRs = append(summary.BlockingPRs, pr)
+			}
+		}
+		if pr.State != "open" {
+			continue
+		}
+		// 与 oneclick_result_report 一致，缺少的必需报告计入 notAvailable
+		for _, report := range pr.MissingReports {
+			summary.MissingByReport[report]++
+			c := summary.ResultsByReport[report]
+			c.NotAvailable++
+			summary.ResultsByReport[report] = c
+		}
+		for report, res := range pr.Results {
+			c := summary.ResultsByReport[report]
+			switch res {
+			case metrics.ResultSuccess:
+				c.Success++
+			case metrics.ResultFailure:
+				c.Failure++
+			default:
+				c.NotAvailable++
+			}
+			summary.ResultsByReport[report] = c
+		}
+	}
+
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "project not found in the last collection cycle: " + key})
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.S().Errorf("写入 API 响应失败: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oneclick-metrics-go/metrics"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testUpdatedAt = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func testSnapshot() metrics.Snapshot {
+	return metrics.Snapshot{
+		UpdatedAt: testUpdatedAt,
+		PRs: []metrics.PRDetail{
+			{Instance: "prod", Project: "ABC", Repo: "api", PrNo: "1", State: "open",
+				RequiredReports: []string{"ci", "sast"}, PresentReports: []string{"ci"}, MissingReports: []string{"sast"},
+				Results: map[string]string{"ci": metrics.ResultSuccess}},
+			{Instance: "prod", Project: "ABC", Repo: "api", PrNo: "2", State: "open",
+				RequiredReports: []string{"ci", "sast"}, PresentReports: []string{"ci", "sast"},
+				Results: map[string]string{"ci": metrics.ResultFailure, "sast": metrics.ResultNotAvailable}},
+			{Instance: "prod", Project: "ABC", Repo: "web", PrNo: "3", State: "merged",
+				RequiredReports: []string{"ci"}, MissingReports: []string{"ci"}},
+			{Instance: "prod", Project: "XYZ", Repo: "api", PrNo: "4", State: "open",
+				RequiredReports: []string{"ci"}, PresentReports: []string{"ci"}, Results: map[string]string{"ci": metrics.ResultSuccess}},
+			{Instance: "acq", Project: "ABC", Repo: "api", PrNo: "1", State: "open",
+				RequiredReports: []string{"ci"}, MissingReports: []string{"ci"}},
+		},
+	}
+}
+
+func serve(t *testing.T, target string, v interface{}) int {
+	t.Helper()
+	latestSnapshot = testSnapshot
+	t.Cleanup(func() { latestSnapshot = metrics.LatestSnapshot })
+
+	mux := http.NewServeMux()
+	Register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("解析响应失败: %v\n%s", err, rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestPRsFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string // instance/project/pr_no
+	}{
+		{"全部", "", []string{"prod/ABC/1", "prod/ABC/2", "prod/ABC/3", "prod/XYZ/4", "acq/ABC/1"}},
+		{"instance", "?instance=acq", []string{"acq/ABC/1"}},
+		{"project", "?project=XYZ", []string{"prod/XYZ/4"}},
+		{"repo 与 state", "?repo=api&state=open", []string{"prod/ABC/1", "prod/ABC/2", "prod/XYZ/4", "acq/ABC/1"}},
+		{"缺少必需报告", "?missing=true", []string{"prod/ABC/1", "prod/ABC/3", "acq/ABC/1"}},
+		{"没有缺少报告", "?missing=no", []string{"prod/ABC/2", "prod/XYZ/4"}},
+		{"缺少指定的报告", "?missing=sast", []string{"prod/ABC/1"}},
+		{"组合条件", "?instance=prod&project=ABC&missing=ci", []string{"prod/ABC/3"}},
+		{"没有匹配", "?project=NONE", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got PRList
+			if code := serve(t, "/api/v1/prs"+tt.query, &got); code != http.StatusOK {
+				t.Fatalf("状态码 = %d", code)
+			}
+			prs := []string{}
+			for _, pr := range got.PRs {
+				prs = append(prs, pr.Instance+"/"+pr.Project+"/"+pr.PrNo)
+			}
+			if !reflect.DeepEqual(prs, tt.want) {
+				t.Errorf("prs = %v, want %v", prs, tt.want)
+			}
+			if got.Count != len(tt.want) || !got.UpdatedAt.Equal(testUpdatedAt) {
+				t.Errorf("count = %d, updated_at = %v", got.Count, got.UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestProjectSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		want     ProjectSummary
+		blocking []string // 阻塞的 pr，instance/pr_no
+	}{
+		{"合并所有实例", "/api/v1/projects/ABC/summary", ProjectSummary{
+			Project:         "ABC",
+			PRsByState:      map[string]int{"open": 3, "merged": 1},
+			MissingByState:  map[string]int{"open": 2, "merged": 1},
+			MissingByReport: map[string]int{"sast": 1, "ci": 1},
+			ResultsByReport: map[string]ResultCount{
+				"ci":   {Success: 1, Failure: 1, NotAvailable: 1},
+				"sast": {NotAvailable: 2},
+			},
+		}, []string{"prod/1", "acq/1"}},
+		{"指定实例", "/api/v1/projects/ABC/summary?instance=acq", ProjectSummary{
+			Instance:        "acq",
+			Project:         "ABC",
+			PRsByState:      map[string]int{"open": 1},
+			MissingByState:  map[string]int{"open": 1},
+			MissingByReport: map[string]int{"ci": 1},
+			ResultsByReport: map[string]ResultCount{"ci": {NotAvailable: 1}},
+		}, []string{"acq/1"}},
+		{"没有阻塞的 pr", "/api/v1/projects/XYZ/summary", ProjectSummary{
+			Project:         "XYZ",
+			PRsByState:      map[string]int{"open": 1},
+			MissingByState:  map[string]int{},
+			MissingByReport: map[string]int{},
+			ResultsByReport: map[string]ResultCount{"ci": {Success: 1}},
+		}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ProjectSummary
+			if code := serve(t, tt.target, &got); code != http.StatusOK {
+				t.Fatalf("状态码 = %d", code)
+			}
+			blocking := []string{}
+			for _, pr := range got.BlockingPRs {
+				blocking = append(blocking, pr.Instance+"/"+pr.PrNo)
+			}
+			if !reflect.DeepEqual(blocking, tt.blocking) {
+				t.Errorf("blocking_prs = %v, want %v", blocking, tt.blocking)
+			}
+			got.BlockingPRs, got.UpdatedAt = nil, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summary = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectSummaryNotFound(t *testing.T) {
+	for _, target := range []string{"/api/v1/projects/NONE/summary", "/api/v1/projects/XYZ/summary?instance=acq"} {
+		var got map[string]string
+		if code := serve(t, target, &got); code != http.StatusNotFound {
+			t.Errorf("%s: 状态码 = %d", target, code)
+		}
+		if got["error"] == "" {
+			t.Errorf("%s: 没有错误信息", target)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/secret"
+	"reflect"
+)
+
+// RegisterConfig 注册 /-/config 调试接口，返回脱敏后的当前配置
+func RegisterConfig(mux *http.ServeMux) {
+	mux.HandleFunc("GET /-/config", func(w http.ResponseWriter, r *http.Request) {
+		cfg := secret.RedactConfig(*global.ServerConfig.Load())
+		writeJSON(w, http.StatusOK, configMap(reflect.ValueOf(cfg)))
+	})
+}
+
+// 按 mapstructure 标签输出配置，与配置文件中的名称保持一致
+func configMap(v reflect.Value) map[string]interface{} {
+	out := map[string]interface{}{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		switch field := v.Field(i); {
+		case field.Kind() == reflect.Struct:
+			out[tag] = configMap(field)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+			items := make([]map[string]interface{}, field.Len())
+			for j := range items {
+				items[j] = configMap(field.Index(j))
+			}
+			out[tag] = items
+		default:
+			out[tag] = field.Interface()
+		}
+	}
+	return out
+}
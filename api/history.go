@@ -0,0 +1,63 @@
+package api
+
+import (
+	"go.uber.org/zap"
+	"net/http"
+	"oneclick-metrics-go/history"
+	"time"
+)
+
+// 未指定 from 时默认查询的天数
+const defaultHistoryDays = 90
+
+// HistoryResponse /api/v1/history 的返回结构
+type HistoryResponse struct {
+	Instance string                `json:"instance,omitempty"`
+	Project  string                `json:"project,omitempty"`
+	From     string                `json:"from"`
+	To       string                `json:"to"`
+	Days     []history.DailyRecord `json:"days"`
+}
+
+// RegisterHistory 注册历史趋势查询，store 为 nil 表示没有启用历史存储
+func RegisterHistory(mux *http.ServeMux, store *history.Store) {
+	mux.HandleFunc("GET /api/v1/history", func(w http.ResponseWriter, r *http.Request) {
+		handleHistory(w, r, store)
+	})
+}
+
+// from、to 为 YYYY-MM-DD 格式的日期（包含两端），默认查询截至今天的最近 90 天
+func handleHistory(w http.ResponseWriter, r *http.Request, store *history.Store) {
+	if store == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "history store is not enabled, set history.path in the config"})
+		return
+	}
+
+	q := r.URL.Query()
+	to := q.Get("to")
+	if to == "" {
+		to = store.Today()
+	}
+	toDate, err := time.Parse(history.DateLayout, to)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid to, expected YYYY-MM-DD: " + to})
+		return
+	}
+	from := q.Get("from")
+	if from == "" {
+		from = toDate.AddDate(0, 0, -defaultHistoryDays).Format(history.DateLayout)
+	}
+	if _, err := time.Parse(history.DateLayout, from); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid from, expected YYYY-MM-DD: " + from})
+		return
+	}
+
+	instance, project := q.Get("instance"), q.Get("project")
+	days, err := store.Query(instance, project, from, to)
+	if err != nil {
+		zap.S().Errorf("查询历史数据失败: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, HistoryResponse{Instance: instance, Project: project, From: from, To: to, Days: days})
+}
@@ -0,0 +1,289 @@
+// Package bench 在目标数据库上重复执行采集任务，统计每个任务的耗时分位数、返回的行数、
+// 生成的序列数和内存分配，并可以比较同一查询的两个版本，用于判断哪些查询需要重新设计。
+package bench
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	dbase "oneclick-metrics-go/db"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/metrics"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 查询的两个版本：当前注册的查询，以及 Options.Candidates 中的候选查询
+const (
+	VersionCurrent   = "current"
+	VersionCandidate = "candidate"
+)
+
+// Options 基准测试参数
+type Options struct {
+	Collectors []string          // 执行的采集任务，为空时执行全部任务
+	Iterations int               // 每个任务每个版本计入统计的执行次数
+	Warmup     int               // 计入统计前的预热次数，第一次执行会查询并缓存项目列表
+	Candidates map[string]string // 查询名称到候选查询文本，同一任务的两个版本交替执行
+}
+
+// Report 一次基准测试的结果，可以保存为 JSON 作为之后比较的基线
+type Report struct {
+	Instance   string    `json:"instance"`
+	StartedAt  time.Time `json:"started_at"`
+	Iterations int       `json:"iterations"`
+	Results    []Result  `json:"results"`
+}
+
+// Result 一个采集任务一个版本的统计
+type Result struct {
+	Collector   string `json:"collector"`
+	Query       string `json:"query"`
+	Version     string `json:"version"`
+	Runs        int    `json:"runs"`
+	Errors      int    `json:"errors"`       // 返回错误的次数
+	QueryErrors int    `json:"query_errors"` // 失败的查询数，包括任务记录日志后继续执行的查询
+	LastError   string `json:"last_error,omitempty"`
+
+	Latency      Stats `json:"latency_seconds"`       // 任务总耗时，包括查询和生成指标
+	QueryLatency Stats `json:"query_latency_seconds"` // 其中执行查询并读出结果的耗时
+
+	Queries    int    `json:"queries"`     // 每次执行的查询数
+	Rows       int    `json:"rows"`        // 每次执行返回的行数
+	Series     int    `json:"series"`      // 执行后导出的序列数
+	AllocBytes uint64 `json:"alloc_bytes"` // 每次执行平均分配的内存
+}
+
+// Stats 耗时分布，单位为秒
+type Stats struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// 一个版本的执行环境：独立的连接、指标和实例，不同版本的项目缓存和序列互不影响
+type runner struct {
+	task    metrics.MetricTask
+	version string
+	conn    *sql.Conn
+	inst    *metrics.Instance
+	reg     *prometheus.Registry
+
+	latency, queryLatency []float64
+	result                Result
+	allocs                uint64
+}
+
+// Run 在 db 上依次对每个采集任务执行基准测试
+func Run(ctx context.Context, instance string, db *sql.DB, opts Options) (Report, error) {
+	report := Report{Instance: instance, StartedAt: time.Now(), Iterations: opts.Iterations}
+	tasks, err := selectTasks(opts.Collectors)
+	if err != nil {
+		return report, err
+	}
+	for key := range opts.Candidates {
+		if !hasQuery(tasks, key) {
+			return report, fmt.Errorf("候选查询 %s 不属于要执行的采集任务", key)
+		}
+	}
+
+	for _, task := range tasks {
+		results, err := runTask(ctx, instance, db, task, opts)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", task.Name, err)
+		}
+		report.Results = append(report.Results, results...)
+	}
+	return report, nil
+}
+
+func runTask(ctx context.Context, instance string, db *sql.DB, task metrics.MetricTask, opts Options) ([]Result, error) {
+	versions := []string{VersionCurrent}
+	if _, ok := opts.Candidates[task.QueryKey]; ok {
+		versions = append(versions, VersionCandidate)
+	}
+
+	var runners []*runner
+	defer func() {
+		for _, r := range runners {
+			r.conn.Close()
+		}
+	}()
+	for _, version := range versions {
+		r, err := newRunner(ctx, instance, db, task, version, opts.Candidates[task.QueryKey])
+		if err != nil {
+			return nil, err
+		}
+		runners = append(runners, r)
+	}
+
+	for i := 0; i < opts.Warmup; i++ {
+		for _, r := range runners {
+			r.run(ctx, false)
+		}
+	}
+	// 两个版本交替执行，数据库缓存和负载的变化对两者的影响相同
+	for i := 0; i < opts.Iterations; i++ {
+		for _, r := range runners {
+			r.run(ctx, true)
+		}
+	}
+
+	results := make([]Result, len(runners))
+	for i, r := range runners {
+		results[i] = r.finish()
+	}
+	return results, nil
+}
+
+func newRunner(ctx context.Context, instance string, db *sql.DB, task metrics.MetricTask, version, candidate string) (*runner, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库连接失败: %w", err)
+	}
+	// 与 metrics.NewInstance 使用同一份配置
+	cfg := global.ServerConfig.Load()
+	switch {
+	case task.QueryKey == "":
+		// UnifiedTask 的查询不需要 prepare
+	case version == VersionCandidate:
+		_, err = conn.ExecContext(ctx, dbase.PrepareStatementWith(cfg, task.QueryKey, candidate))
+	default:
+		err = dbase.RegisterPreparedSQLs(ctx, cfg, task.QueryKey, conn, true)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("prepare %s (%s) 失败: %w", task.QueryKey, version, err)
+	}
+
+	m := metrics.SetupMetrics()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m.Collectors()...)
+	return &runner{
+		task:    task,
+		version: version,
+		conn:    conn,
+		inst:    metrics.NewInstance(instance, nil, m),
+		reg:     reg,
+		result:  Result{Collector: task.Name, Query: task.QueryKey, Version: version},
+	}, nil
+}
+
+// 执行一次任务，record 为 false 时只预热不计入统计
+func (r *runner) run(ctx context.Context, record bool) {
+	q := newCountingQuerier(r.conn)
+	defer q.Close()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	err := r.task.ExportFn(ctx, r.inst, q)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	if !record {
+		return
+	}
+	r.result.Runs++
+	r.result.QueryErrors += q.failed
+	if q.lastErr != nil {
+		r.result.LastError = q.lastErr.Error()
+	}
+	if err != nil {
+		r.result.Errors++
+		r.result.LastError = err.Error()
+		return
+	}
+	r.latency = append(r.latency, elapsed.Seconds())
+	r.queryLatency = append(r.queryLatency, q.elapsed.Seconds())
+	r.allocs += after.TotalAlloc - before.TotalAlloc
+	r.result.Queries = q.queries
+	r.result.Rows = q.rows
+	r.result.Series = countSeries(r.reg)
+}
+
+func (r *runner) finish() Result {
+	res := r.result
+	res.Latency = summarize(r.latency)
+	res.QueryLatency = summarize(r.queryLatency)
+	if n := len(r.latency); n > 0 {
+		res.AllocBytes = r.allocs / uint64(n)
+	}
+	return res
+}
+
+// 任务导出的序列数。bench 直接调用 ExportFn，不会写入 oneclick_collector_* 等任务状态指标，registry 中只有任务自己的序列
+func countSeries(reg *prometheus.Registry) int {
+	families, err := reg.Gather()
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, mf := range families {
+		n += len(mf.GetMetric())
+	}
+	return n
+}
+
+// 可以测试的任务：所有采集任务，以及 engine 为 unified 时代替它们的 Unified
+func selectTasks(names []string) ([]metrics.MetricTask, error) {
+	all := append(metrics.Tasks(), metrics.UnifiedTask)
+	if len(names) == 0 {
+		return all, nil
+	}
+	byName := make(map[string]metrics.MetricTask)
+	var known []string
+	for _, task := range all {
+		byName[task.Name] = task
+		known = append(known, task.Name)
+	}
+	var tasks []metrics.MetricTask
+	for _, name := range names {
+		task, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("未知的采集任务 %s，可选值: %s", name, strings.Join(known, ", "))
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func hasQuery(tasks []metrics.MetricTask, key string) bool {
+	for _, task := range tasks {
+		if task.QueryKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+// 最近秩法计算分位数
+func summarize(samples []float64) Stats {
+	if len(samples) == 0 {
+		return Stats{}
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	quantile := func(q float64) float64 {
+		i := int(math.Ceil(q*float64(len(sorted)))) - 1
+		return sorted[max(i, 0)]
+	}
+	return Stats{
+		Min:  sorted[0],
+		Mean: sum / float64(len(sorted)),
+		P50:  quantile(0.5),
+		P90:  quantile(0.9),
+		P99:  quantile(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
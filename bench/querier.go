@@ -0,0 +1,90 @@
+package bench
+
+import (
+	"context"
+	"database/sql"
+	dbase "oneclick-metrics-go/db"
+	"oneclick-metrics-go/db/fake"
+	"sync"
+	"time"
+)
+
+// countingQuerier 满足 db.Querier：在 q 上执行查询并读出全部结果，记录查询数、行数和耗时，
+// 再把同样的结果返回给采集任务。查询耗时包括数据库执行和传输结果的时间，不包括任务处理结果的时间
+type countingQuerier struct {
+	q  dbase.Querier
+	db *sql.DB
+
+	mu      sync.Mutex
+	pending map[string][][]any // 已读出、等待返回给采集任务的结果
+	queries int
+	failed  int // 失败的查询数，有的任务只记录查询错误而不返回错误
+	lastErr error
+	rows    int
+	elapsed time.Duration
+}
+
+func newCountingQuerier(q dbase.Querier) *countingQuerier {
+	c := &countingQuerier{q: q, pending: make(map[string][][]any)}
+	c.db = fake.Open(c.lookup)
+	return c
+}
+
+func (c *countingQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	values, err := readAll(ctx, c.q, query, args...)
+	elapsed := time.Since(start)
+
+	c.mu.Lock()
+	c.queries++
+	c.elapsed += elapsed
+	if err != nil {
+		c.failed++
+		c.lastErr = err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.rows += len(values)
+	c.pending[query] = values
+	c.mu.Unlock()
+
+	return c.db.QueryContext(ctx, query)
+}
+
+func (c *countingQuerier) lookup(query string) ([][]any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := c.pending[query]
+	delete(c.pending, query)
+	return values, nil
+}
+
+func (c *countingQuerier) Close() error {
+	return c.db.Close()
+}
+
+func readAll(ctx context.Context, q dbase.Querier, query string, args ...any) ([][]any, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var values [][]any
+	for rows.Next() {
+		row := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		values = append(values, row)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,142 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Print 以表格输出每个任务每个版本的统计，存在候选版本时输出两个版本的差异
+func Print(w io.Writer, report Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "COLLECTOR\tVERSION\tRUNS\tERRORS\tP50\tP90\tP99\tMAX\tQUERY P50\tQUERIES\tROWS\tSERIES\tALLOC/RUN\n")
+	for _, r := range report.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			r.Collector, r.Version, r.Runs, r.Errors,
+			seconds(r.Latency.P50), seconds(r.Latency.P90), seconds(r.Latency.P99), seconds(r.Latency.Max),
+			seconds(r.QueryLatency.P50), r.Queries, r.Rows, r.Series, bytes(r.AllocBytes))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, r := range report.Results {
+		if r.LastError != "" {
+			fmt.Fprintf(w, "\n%s (%s) 失败 %d 次，失败的查询 %d 个，最后一次错误: %s\n", r.Collector, r.Version, r.Errors, r.QueryErrors, r.LastError)
+		}
+	}
+
+	var pairs [][2]Result
+	for _, cand := range report.Results {
+		if cand.Version != VersionCandidate {
+			continue
+		}
+		if cur, ok := find(report.Results, cand.Collector, VersionCurrent); ok {
+			pairs = append(pairs, [2]Result{cur, cand})
+		}
+	}
+	if len(pairs) > 0 {
+		fmt.Fprintln(w, "\ncandidate 与 current 比较:")
+		return printDiff(w, pairs)
+	}
+	return nil
+}
+
+// Compare 输出 report 与之前保存的 baseline 中相同任务、相同版本的差异
+func Compare(w io.Writer, baseline, report Report) error {
+	var pairs [][2]Result
+	for _, r := range report.Results {
+		if old, ok := find(baseline.Results, r.Collector, r.Version); ok {
+			pairs = append(pairs, [2]Result{old, r})
+		}
+	}
+	if len(pairs) == 0 {
+		fmt.Fprintln(w, "\n基线中没有相同的采集任务")
+		return nil
+	}
+	fmt.Fprintf(w, "\n与基线（%s，%s）比较:\n", baseline.Instance, baseline.StartedAt.Format(time.RFC3339))
+	return printDiff(w, pairs)
+}
+
+func printDiff(w io.Writer, pairs [][2]Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "COLLECTOR\tVERSION\tP50\tP99\tQUERY P50\tROWS\tSERIES\tALLOC/RUN\n")
+	for _, p := range pairs {
+		old, cur := p[0], p[1]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", cur.Collector, cur.Version,
+			change(old.Latency.P50, cur.Latency.P50), change(old.Latency.P99, cur.Latency.P99),
+			change(old.QueryLatency.P50, cur.QueryLatency.P50),
+			change(float64(old.Rows), float64(cur.Rows)), change(float64(old.Series), float64(cur.Series)),
+			change(float64(old.AllocBytes), float64(cur.AllocBytes)))
+	}
+	return tw.Flush()
+}
+
+func find(results []Result, collector, version string) (Result, bool) {
+	for _, r := range results {
+		if r.Collector == collector && r.Version == version {
+			return r, true
+		}
+	}
+	return Result{}, false
+}
+
+// Save 把报告写入 JSON 文件
+func Save(path string, report Report) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// Load 读取 Save 写入的报告
+func Load(path string) (Report, error) {
+	var report Report
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+	if err := json.Unmarshal(b, &report); err != nil {
+		return report, fmt.Errorf("%s: %w", path, err)
+	}
+	return report, nil
+}
+
+func seconds(s float64) string {
+	d := time.Duration(s * float64(time.Second))
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+func bytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// 相对变化，例如 -35.2%
+func change(old, cur float64) string {
+	switch {
+	case old == cur:
+		return "0%"
+	case old == 0:
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", (cur-old)/old*100)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"oneclick-metrics-go/bench"
+	"oneclick-metrics-go/db"
+	"oneclick-metrics-go/initialize"
+	"os"
+	"sort"
+	"strings"
+)
+
+// bench 子命令：在目标数据库上重复执行采集任务，输出耗时分位数、行数、序列数和内存分配
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	instance := fs.String("instance", "", "测试的实例，默认为第一个实例")
+	collectors := fs.String("collectors", "", "逗号分隔的采集任务，默认为全部任务")
+	iterations := fs.Int("n", 10, "每个任务计入统计的执行次数")
+	warmup := fs.Int("warmup", 1, "计入统计前的预热次数")
+	out := fs.String("out", "", "把结果写入 JSON 文件，可以作为之后的基线")
+	baseline := fs.String("baseline", "", "与之前 -out 保存的结果比较")
+	candidates := candidateFlag{}
+	fs.Var(candidates, "candidate", "查询名称=SQL 文件，与当前的查询交替执行并比较，可以指定多次")
+	initialize.ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *iterations < 1 {
+		return fmt.Errorf("-n 不能小于 1")
+	}
+	if *warmup < 0 {
+		return fmt.Errorf("-warmup 不能小于 0")
+	}
+	var base bench.Report
+	if *baseline != "" {
+		var err error
+		if base, err = bench.Load(*baseline); err != nil {
+			return err
+		}
+	}
+
+	initialize.InitDatabaseConfig()
+	initialize.InitLogger()
+
+	target, err := findTarget(*instance)
+	if err != nil {
+		return err
+	}
+	dB, err := db.Open(target.Name, target.Database)
+	if dB != nil {
+		defer dB.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := bench.Options{Iterations: *iterations, Warmup: *warmup, Candidates: candidates}
+	for _, name := range strings.Split(*collectors, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Collectors = append(opts.Collectors, name)
+		}
+	}
+	report, err := bench.Run(context.Background(), target.Name, dB, opts)
+	if err != nil {
+		return fmt.Errorf("[%s] %w", target.Name, err)
+	}
+
+	if err := bench.Print(os.Stdout, report); err != nil {
+		return err
+	}
+	if *baseline != "" {
+		if err := bench.Compare(os.Stdout, base, report); err != nil {
+			return err
+		}
+	}
+	if *out != "" {
+		return bench.Save(*out, report)
+	}
+	return nil
+}
+
+// -candidate 参数：查询名称到候选查询文本
+type candidateFlag map[string]string
+
+func (c candidateFlag) String() string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (c candidateFlag) Set(s string) error {
+	name, path, ok := strings.Cut(s, "=")
+	if !ok || name == "" || path == "" {
+		return fmt.Errorf("格式应为 查询名称=SQL 文件")
+	}
+	if _, ok := c[name]; ok {
+		return fmt.Errorf("查询 %s 重复指定", name)
+	}
+	known := false
+	for _, key := range db.QueryKeys() {
+		known = known || key == name
+	}
+	if !known {
+		return fmt.Errorf("未知的查询 %s，可选值: %s", name, strings.Join(db.QueryKeys(), ", "))
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c[name] = string(b)
+	return nil
+}
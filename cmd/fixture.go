@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"go.uber.org/zap"
+	"oneclick-metrics-go/db/fixture"
+	"oneclick-metrics-go/metrics"
+)
+
+// 录制与回放参数，serve 和 once 共用
+type fixtureFlags struct {
+	record *string
+	replay *string
+}
+
+func addFixtureFlags(fs *flag.FlagSet) *fixtureFlags {
+	return &fixtureFlags{
+		record: fs.String("record", "", "把每个采集任务的查询结果录制到该目录"),
+		replay: fs.String("replay", "", "从该目录回放录制的查询结果，不连接数据库"),
+	}
+}
+
+func (f *fixtureFlags) check() error {
+	if *f.record != "" && *f.replay != "" {
+		return errors.New("-record 和 -replay 不能同时使用")
+	}
+	return nil
+}
+
+func (f *fixtureFlags) replaying() bool {
+	return *f.replay != ""
+}
+
+// 创建回放实例，录制目录中需要有该实例的文件
+func (f *fixtureFlags) replayInstance(name string, m *metrics.Metrics) (*metrics.Instance, error) {
+	r, err := fixture.OpenReplay(*f.replay, name)
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Infof("[%s] 从 %s 回放查询结果，不连接数据库", name, *f.replay)
+	return metrics.NewReplayInstance(name, r, m), nil
+}
+
+// 返回录制器，没有设置 -record 时返回 nil
+func (f *fixtureFlags) recorder() (*fixture.Recorder, error) {
+	if *f.record == "" {
+		return nil, nil
+	}
+	r, err := fixture.NewRecorder(*f.record)
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Infof("查询结果将录制到 %s", *f.record)
+	return r, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"oneclick-metrics-go/generate"
+	"oneclick-metrics-go/metrics"
+	"os"
+)
+
+// grafana 子命令：根据指标定义生成 Grafana dashboard JSON
+func runGrafana(args []string) error {
+	fs := flag.NewFlagSet("grafana", flag.ExitOnError)
+	out := fs.String("out", "-", "输出文件路径，- 表示标准输出")
+	title := fs.String("title", "OneClick Metrics", "dashboard 标题")
+	uid := fs.String("uid", "oneclick-metrics", "dashboard uid")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var collectors []string
+	for _, task := range metrics.Tasks() {
+		collectors = append(collectors, task.Name)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("创建输出文件失败: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	opts := generate.GrafanaOptions{Title: *title, UID: *uid}
+	return generate.WriteGrafanaDashboard(w, opts, metrics.Definitions(), collectors)
+}
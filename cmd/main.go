@@ -1,47 +1,58 @@
 package main
 
 import (
-	"context"
 	"fmt"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"go.uber.org/zap"
-	"net/http"
-	"oneclick-metrics-go/db"
-	"oneclick-metrics-go/global"
-	"oneclick-metrics-go/initialize"
-	"oneclick-metrics-go/metrics"
-	"sync"
+	"os"
+	"sort"
+	"strings"
 )
 
-func main() {
-	// 初始化配置
-	initialize.InitConfig()
-
-	// 初始化日志设置
-	initialize.InitLogger()
+// 子命令及其说明
+var subcommands = map[string]struct {
+	run   func([]string) error
+	usage string
+}{
+	"bench":     {runBench, "在数据库上重复执行采集任务，统计耗时、行数、序列数和内存"},
+	"serve":     {runServe, "启动 /metrics 服务并按 interval 持续采集（默认）"},
+	"once":      {runOnce, "执行每个采集任务一次，并将指标输出到标准输出"},
+	"validate":  {runValidate, "校验配置并在数据库上 prepare 所有查询"},
+	"print-sql": {runPrintSQL, "输出 ParseSqlDict 中的实际 SQL 及其参数"},
+	"grafana":   {runGrafana, "根据指标定义生成 Grafana dashboard JSON"},
+	"rules":     {runRules, "根据指标定义生成 Prometheus 记录规则和告警规则"},
+	"seed":      {runSeed, "在本地数据库中创建 Bitbucket 表结构并生成模拟数据"},
+}
 
-	// 启动 Prometheus 指标服务
-	http.Handle("/metrics", promhttp.Handler())
-	go func() {
-		zap.S().Infof("Starting metrics server on %s:%s", global.ServerConfig.Host, global.ServerConfig.Port)
-		zap.S().Fatal(http.ListenAndServe(fmt.Sprintf("%s:%s", global.ServerConfig.Host, global.ServerConfig.Port), nil))
-	}()
+func usage() {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	// 注册指标
-	m := metrics.SetupMetrics()
-	metrics.RegisterMetrics(m)
+	var b strings.Builder
+	b.WriteString("用法: oneclick-metrics <子命令> [参数]\n\n子命令:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-10s %s\n", name, subcommands[name].usage)
+	}
+	fmt.Fprint(os.Stderr, b.String())
+}
 
-	if err := db.InitDb(); err != nil {
-		zap.S().Errorf("数据库初始化失败:", err)
-		return
+func main() {
+	// 不带子命令时保持原有的服务模式
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
-	zap.S().Info("数据库连接成功！")
 
-	var dB = db.DB
-	ctx := context.Background()
-	for {
-		wg := sync.WaitGroup{}
-		metrics.CollectMetrics(ctx, m, dB, &wg)
+	cmd, ok := subcommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "未知子命令: %s\n\n", name)
+		usage()
+		os.Exit(2)
 	}
 
+	if err := cmd.run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+	"oneclick-metrics-go/db"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/initialize"
+	"oneclick-metrics-go/metrics"
+	"oneclick-metrics-go/push"
+	"os"
+)
+
+// once 子命令：执行每个采集任务一次并输出指标，适用于 cron 等场景
+func runOnce(args []string) error {
+	fs := flag.NewFlagSet("once", flag.ExitOnError)
+	fixtures := addFixtureFlags(fs)
+	initialize.ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := fixtures.check(); err != nil {
+		return err
+	}
+
+	// 回放时不连接数据库，不校验数据库配置
+	if fixtures.replaying() {
+		initialize.InitConfig()
+	} else {
+		initialize.InitDatabaseConfig()
+	}
+	initialize.InitLogger()
+
+	cfg := global.ServerConfig.Load()
+
+	// 使用独立的 registry，只输出 exporter 自己的指标
+	reg := prometheus.NewRegistry()
+	m := metrics.SetupMetrics()
+	reg.MustRegister(m.Collectors()...)
+
+	// 连接失败的实例的采集任务会失败并记录在 collectErr 中，不影响其他实例
+	var instances []*metrics.Instance
+	recorder, err := fixtures.recorder()
+	if err != nil {
+		return err
+	}
+	for _, target := range cfg.Targets() {
+		if fixtures.replaying() {
+			inst, err := fixtures.replayInstance(target.Name, m)
+			if err != nil {
+				return err
+			}
+			defer inst.Close()
+			instances = append(instances, inst)
+			continue
+		}
+		inst := metrics.OpenInstance(target.Name, func() (*sql.DB, error) {
+			return db.Open(target.Name, target.Database)
+		}, m)
+		defer inst.Close()
+		if recorder != nil {
+			inst.Record(recorder)
+		}
+		instances = append(instances, inst)
+	}
+
+	ctx := context.Background()
+	collectErr := metrics.CollectOnce(ctx, instances)
+
+	// 配置了推送目标时同时推送，适合无法被抓取的环境中由 cron 调用
+	sinks, err := push.FromConfig(cfg.Push)
+	if err != nil {
+		return err
+	}
+	pushErr := push.PushAll(ctx, sinks, reg, push.Timeout(cfg.Push))
+
+	families, err := reg.Gather()
+	if err != nil {
+		return fmt.Errorf("收集指标失败: %w", err)
+	}
+	enc := expfmt.NewEncoder(os.Stdout, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return fmt.Errorf("输出指标失败: %w", err)
+		}
+	}
+
+	if collectErr != nil {
+		zap.S().Errorf("部分采集任务失败: %v", collectErr)
+		return collectErr
+	}
+	return pushErr
+}
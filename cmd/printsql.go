@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"oneclick-metrics-go/db"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/initialize"
+	"strings"
+)
+
+// print-sql 子命令：输出每个查询实际执行的 PREPARE 语句，便于在 psql 中调试
+func runPrintSQL(args []string) error {
+	fs := flag.NewFlagSet("print-sql", flag.ExitOnError)
+	name := fs.String("name", "", "只输出指定名称的查询，默认输出全部")
+	initialize.ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// 查询中的报告类型和项目过滤条件来自配置
+	initialize.InitConfig()
+	cfg := global.ServerConfig.Load()
+
+	keys := db.QueryKeys()
+	if *name != "" {
+		if proto, _ := db.ParseSqlDict(*name); proto == "" {
+			return fmt.Errorf("未知查询: %s，可选: %s", *name, strings.Join(keys, ", "))
+		}
+		keys = []string{*name}
+	}
+
+	for _, key := range keys {
+		proto, _ := db.ParseSqlDict(key)
+		fmt.Printf("-- %s\n-- 参数: %s\n%s;\n\n", key, queryParams(proto), strings.TrimSpace(db.PrepareStatement(cfg, key)))
+	}
+	return nil
+}
+
+// 从 prepare 名称中取出参数类型列表
+func queryParams(proto string) string {
+	idx := strings.Index(proto, "(")
+	if idx == -1 {
+		return "无"
+	}
+	var params []string
+	for i, typ := range strings.Split(strings.Trim(proto[idx:], "()"), ",") {
+		params = append(params, fmt.Sprintf("$%d %s", i+1, strings.TrimSpace(typ)))
+	}
+	return strings.Join(params, ", ")
+}
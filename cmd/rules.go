@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"oneclick-metrics-go/generate"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/initialize"
+	"oneclick-metrics-go/metrics"
+	"os"
+)
+
+// rules 子命令：根据指标定义和配置中的阈值生成 Prometheus 规则文件
+func runRules(args []string) error {
+	fs := flag.NewFlagSet("rules", flag.ExitOnError)
+	out := fs.String("out", "-", "输出文件路径，- 表示标准输出")
+	initialize.ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	initialize.InitConfig()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("创建输出文件失败: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return generate.WritePrometheusRules(w, global.ServerConfig.Load().Rules, metrics.Definitions())
+}
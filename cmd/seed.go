@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/db"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/initialize"
+	"oneclick-metrics-go/synth"
+	"time"
+)
+
+// seed 子命令：在本地数据库中创建 Bitbucket 表结构并生成模拟数据
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	opts := synth.DefaultOptions()
+	instance := fs.String("instance", "", "写入的实例，默认为第一个实例")
+	drop := fs.Bool("drop", false, "删除之前生成的表后重新生成")
+	fs.IntVar(&opts.Projects, "projects", opts.Projects, "项目数量")
+	fs.IntVar(&opts.ReposPerProject, "repos", opts.ReposPerProject, "每个项目的仓库数量")
+	fs.IntVar(&opts.PRsPerRepo, "prs", opts.PRsPerRepo, "每个仓库的 pr 数量")
+	fs.Float64Var(&opts.OpenRatio, "open-ratio", opts.OpenRatio, "open 状态的 pr 比例")
+	fs.Float64Var(&opts.MergedRatio, "merged-ratio", opts.MergedRatio, "merged 状态的 pr 比例，其余为 declined")
+	fs.Float64Var(&opts.ClosingRatio, "closing-ratio", opts.ClosingRatio, "关闭时间在生成后 -closing-minutes 分钟内的 pr 比例")
+	fs.IntVar(&opts.ClosingMinutes, "closing-minutes", opts.ClosingMinutes, "生成后多少分钟内每分钟都有刚关闭的 pr")
+	fs.Float64Var(&opts.RequiredRatio, "required-ratio", opts.RequiredRatio, "每个仓库要求每种报告的概率")
+	fs.Float64Var(&opts.ReportRatio, "report-ratio", opts.ReportRatio, "每个 pr 提供每种报告的概率")
+	fs.Float64Var(&opts.SuccessRatio, "success-ratio", opts.SuccessRatio, "报告结果为成功的概率")
+	fs.Float64Var(&opts.NotAvailableRatio, "na-ratio", opts.NotAvailableRatio, "报告没有结果的概率")
+	fs.StringVar(&opts.CoverageKey, "coverage-key", opts.CoverageKey, "带有 Code Coverage 数据的报告类型")
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "随机数种子，相同的种子和参数生成相同的数据")
+	initialize.ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkSeedOptions(opts); err != nil {
+		return err
+	}
+
+	initialize.InitDatabaseConfig()
+	initialize.InitLogger()
+
+	target, err := findTarget(*instance)
+	if err != nil {
+		return err
+	}
+	cfg := global.ServerConfig.Load()
+	opts.ReportKeys = cfg.ReportKeys
+	if opts.Location, err = time.LoadLocation(cfg.Timezone); err != nil {
+		return err
+	}
+
+	dB, err := db.Open(target.Name, target.Database)
+	if dB != nil {
+		defer dB.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if err := synth.CreateSchema(ctx, dB, *drop); err != nil {
+		return fmt.Errorf("[%s] 创建表结构失败: %w", target.Name, err)
+	}
+	start := time.Now()
+	stats, err := synth.Generate(ctx, dB, opts)
+	if err != nil {
+		return fmt.Errorf("[%s] 生成数据失败: %w", target.Name, err)
+	}
+	fmt.Printf("[%s] 用时 %s\n", target.Name, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("  project:                  %d\n", stats.Projects)
+	fmt.Printf("  repository:               %d\n", stats.Repositories)
+	fmt.Printf("  sta_pull_request:         %d\n", stats.PullRequests)
+	fmt.Printf("  AO_2AD648_INSIGHT_REPORT: %d\n", stats.Reports)
+	fmt.Printf("  AO_2AD648_MERGE_CHECK:    %d\n", stats.MergeChecks)
+	return nil
+}
+
+func findTarget(name string) (config.InstanceConfig, error) {
+	targets := global.ServerConfig.Load().Targets()
+	if name == "" {
+		return targets[0], nil
+	}
+	for _, t := range targets {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return config.InstanceConfig{}, fmt.Errorf("没有名为 %s 的实例", name)
+}
+
+func checkSeedOptions(opts synth.Options) error {
+	var errs []error
+	for name, v := range map[string]int{"projects": opts.Projects, "repos": opts.ReposPerProject, "prs": opts.PRsPerRepo} {
+		if v < 0 {
+			errs = append(errs, fmt.Errorf("-%s 不能小于 0", name))
+		}
+	}
+	if opts.ClosingMinutes < 0 {
+		errs = append(errs, errors.New("-closing-minutes 不能小于 0"))
+	}
+	ratios := map[string]float64{
+		"open-ratio": opts.OpenRatio, "merged-ratio": opts.MergedRatio, "closing-ratio": opts.ClosingRatio,
+		"required-ratio": opts.RequiredRatio, "report-ratio": opts.ReportRatio, "success-ratio": opts.SuccessRatio,
+		"na-ratio": opts.NotAvailableRatio,
+	}
+	for name, v := range ratios {
+		if v < 0 || v > 1 {
+			errs = append(errs, fmt.Errorf("-%s 应在 0 到 1 之间，当前为 %v", name, v))
+		}
+	}
+	if opts.OpenRatio+opts.MergedRatio > 1 {
+		errs = append(errs, errors.New("-open-ratio 与 -merged-ratio 之和不能大于 1"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"net/http"
+	"oneclick-metrics-go/api"
+	"oneclick-metrics-go/db"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/history"
+	"oneclick-metrics-go/initialize"
+	"oneclick-metrics-go/metrics"
+	"oneclick-metrics-go/push"
+	"oneclick-metrics-go/reload"
+	"oneclick-metrics-go/server"
+	"oneclick-metrics-go/web"
+	"sync"
+	"time"
+)
+
+// serve 子命令：启动 Prometheus 指标服务并持续采集
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fixtures := addFixtureFlags(fs)
+	initialize.ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := fixtures.check(); err != nil {
+		return err
+	}
+
+	// 初始化配置，回放时不连接数据库，不校验数据库配置
+	if fixtures.replaying() {
+		initialize.InitConfig()
+	} else {
+		initialize.InitDatabaseConfig()
+	}
+
+	// 初始化日志设置
+	initialize.InitLogger()
+
+	// 以下只使用启动时的配置，热加载不会修改这些配置项
+	cfg := global.ServerConfig.Load()
+
+	// 启动 Prometheus 指标服务，只通过推送导出指标时可以关闭 /metrics
+	if !cfg.DisableMetrics {
+		http.Handle("/metrics", promhttp.Handler())
+	}
+	api.Register(http.DefaultServeMux)
+	web.Register(http.DefaultServeMux)
+	api.RegisterConfig(http.DefaultServeMux)
+	// 运行时查看和修改日志级别：GET/PUT /-/log-level，修改需要开启 web.enable_lifecycle
+	http.Handle("GET /-/log-level", initialize.LogLevelHandler())
+	if cfg.Web.EnableLifecycle {
+		http.Handle("PUT /-/log-level", initialize.LogLevelHandler())
+	} else {
+		http.HandleFunc("PUT /-/log-level", server.LifecycleDisabled)
+	}
+
+	// 配置热加载：配置文件变化、SIGHUP 以及 POST /-/reload。
+	// /-/reload 和 PUT /-/log-level 在没有认证时任何人都可以调用，需要通过 web.enable_lifecycle 显式开启
+	ctx := context.Background()
+	reloader := reload.New()
+	if cfg.Web.EnableLifecycle {
+		reloader.Register(http.DefaultServeMux)
+	} else {
+		http.HandleFunc("/-/reload", server.LifecycleDisabled)
+	}
+	go reloader.HandleSignals(ctx)
+	if cfg.WatchConfig {
+		if err := reloader.Watch(ctx); err != nil {
+			return err
+		}
+	}
+
+	// 本地历史数据存储，用于没有长期 Prometheus 存储时查询趋势
+	var store *history.Store
+	if cfg.History.Path != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return fmt.Errorf("时区 %q 不合法: %w", cfg.Timezone, err)
+		}
+		if store, err = history.Open(cfg.History, loc); err != nil {
+			return err
+		}
+		defer store.Close()
+		zap.S().Infof("历史数据存储已启用: %s", cfg.History.Path)
+	}
+	api.RegisterHistory(http.DefaultServeMux, store)
+	go func() {
+		zap.S().Infof("Starting metrics server on %s:%s", cfg.Host, cfg.Port)
+		addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
+		zap.S().Fatal(server.ListenAndServe(cfg.Web, addr, http.DefaultServeMux))
+	}()
+
+	// 注册指标
+	m := metrics.SetupMetrics()
+	metrics.RegisterMetrics(m)
+
+	// 每个实例使用独立的连接池，启动时连接失败或无法获取密码的实例在之后的每轮采集中重试，不影响其他实例
+	var instances []*metrics.Instance
+	recorder, err := fixtures.recorder()
+	if err != nil {
+		return err
+	}
+	for _, target := range cfg.Targets() {
+		if fixtures.replaying() {
+			inst, err := fixtures.replayInstance(target.Name, m)
+			if err != nil {
+				return err
+			}
+			defer inst.Close()
+			instances = append(instances, inst)
+			continue
+		}
+		inst := metrics.OpenInstance(target.Name, func() (*sql.DB, error) {
+			return db.Open(target.Name, target.Database)
+		}, m)
+		defer inst.Close()
+		if recorder != nil {
+			inst.Record(recorder)
+		}
+		instances = append(instances, inst)
+	}
+
+	// 每轮采集结束后推送到配置的目标
+	sinks, err := push.FromConfig(cfg.Push)
+	if err != nil {
+		return err
+	}
+	var afterCycle []func(ctx context.Context, inst *metrics.Instance)
+	if len(sinks) > 0 {
+		// 每个实例完成一轮采集后都推送全部指标，多个实例同时完成时依次推送
+		var pushLock sync.Mutex
+		timeout := push.Timeout(cfg.Push)
+		afterCycle = append(afterCycle, func(ctx context.Context, _ *metrics.Instance) {
+			pushLock.Lock()
+			defer pushLock.Unlock()
+			_ = push.PushAll(ctx, sinks, prometheus.DefaultGatherer, timeout)
+		})
+	}
+
+	if store != nil {
+		afterCycle = append(afterCycle, func(ctx context.Context, inst *metrics.Instance) {
+			if err := store.Record(inst.Snapshot()); err != nil {
+				zap.S().Errorf("[%s] 写入历史数据失败: %v", inst.Name, err)
+			}
+		})
+	}
+
+	metrics.CollectMetrics(ctx, instances, reloader.Requests(), afterCycle...)
+	return nil
+}
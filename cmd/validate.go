@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/db"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/initialize"
+	"oneclick-metrics-go/metrics"
+	"oneclick-metrics-go/server"
+	"os"
+)
+
+// validate 子命令：校验配置，并在数据库上 prepare 每个采集任务使用的查询
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	skipDB := fs.Bool("skip-db", false, "只校验配置，不连接数据库，也不检查数据库的密码来源和证书")
+	initialize.ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// CI 中可能没有数据库的密码和证书，-skip-db 时不检查
+	if *skipDB {
+		initialize.InitConfig()
+	} else {
+		initialize.InitDatabaseConfig()
+	}
+	initialize.InitLogger()
+
+	// InitConfig 在配置不合法时已经输出全部问题并退出
+	fmt.Println("config: ok")
+	cfg := global.ServerConfig.Load()
+	if err := server.Validate(cfg.Web); err != nil {
+		return err
+	}
+	if cfg.Web.ConfigFile != "" || cfg.Web.BearerTokenFile != "" {
+		fmt.Println("web config: ok")
+	}
+	if *skipDB {
+		return nil
+	}
+
+	ctx := context.Background()
+	var errs []error
+	for _, target := range cfg.Targets() {
+		if err := validateInstance(ctx, cfg, target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 连接实例的数据库并 prepare 每个采集任务的查询，输出每个任务的结果
+func validateInstance(ctx context.Context, cfg *config.ServerConfig, target config.InstanceConfig) error {
+	dB, err := db.Open(target.Name, target.Database)
+	if dB != nil {
+		defer dB.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "[%s] FAILED: %v\n", target.Name, err)
+		return err
+	}
+
+	conn, err := dB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("[%s] 获取数据库连接失败: %w", target.Name, err)
+	}
+	defer conn.Close()
+
+	var errs []error
+	if cfg.Engine == config.EngineUnified {
+		// unified 方式的查询不需要 prepare，只检查语句能否被数据库解析
+		for _, query := range []string{db.TickPRsQuery(cfg), db.TickChecksQuery(cfg)} {
+			name := db.QueryName(query)
+			stmt, err := conn.PrepareContext(ctx, query)
+			if err != nil {
+				fmt.Fprintf(os.Stdout, "[%s] %s (%s): FAILED: %v\n", target.Name, metrics.UnifiedTask.Name, name, err)
+				errs = append(errs, fmt.Errorf("[%s/%s] %w", target.Name, name, err))
+				continue
+			}
+			stmt.Close()
+			fmt.Fprintf(os.Stdout, "[%s] %s (%s): ok\n", target.Name, metrics.UnifiedTask.Name, name)
+		}
+	}
+	for _, task := range metrics.Tasks() {
+		if err := db.RegisterPreparedSQLs(ctx, cfg, task.QueryKey, conn, true); err != nil {
+			fmt.Fprintf(os.Stdout, "[%s] %s (%s): FAILED: %v\n", target.Name, task.Name, task.QueryKey, err)
+			errs = append(errs, fmt.Errorf("[%s/%s] %w", target.Name, task.QueryKey, err))
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "[%s] %s (%s): ok\n", target.Name, task.Name, task.QueryKey)
+	}
+	return errors.Join(errs...)
+}
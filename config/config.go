@@ -1,23 +1,168 @@
 package config
 
 type DataBase struct {
+	URL      string `mapstructure:"url"` // 完整的连接字符串，设置后忽略 host、port 等单独配置
 	Driver   string `mapstructure:"driver"`
-	Host     string `mapstructure:"host"`
-	Port     string `mapstructure:"port"`
+	Host     string `mapstructure:"host"` // 多个 host（例如主库和备库）用逗号分隔，按 target_session_attrs 依次选择
+	Port     string `mapstructure:"port"` // 多个 host 时可以用逗号分隔为每个 host 指定端口
 	User     string `mapstructure:"user"`
 	Password string `mapstructure:"password"`
 	Dbname   string `mapstructure:"dbname"`
+
+	// 以下为密码的其他来源，优先级：password_command > password_file > password_env > password > ~/.pgpass
+	PasswordFile    string `mapstructure:"password_file"`    // 从文件读取密码，例如挂载的 k8s secret
+	PasswordEnv     string `mapstructure:"password_env"`     // 从指定的环境变量读取密码
+	PasswordCommand string `mapstructure:"password_command"` // 通过 sh -c 执行命令，标准输出作为密码
+	PgpassFile      string `mapstructure:"pgpass_file"`      // 默认为 PGPASSFILE 或 ~/.pgpass
+	Service         string `mapstructure:"service"`          // pg_service.conf 中的服务名，默认为 PGSERVICE
+
+	// 以下为连接参数，配置了 url 时只补充 url 中没有的参数
+	SSLMode         string `mapstructure:"sslmode"`          // disable、require、verify-ca 或 verify-full，默认 verify-full
+	SSLRootCert     string `mapstructure:"sslrootcert"`      // 校验服务端证书的 CA，为空时使用系统 CA
+	SSLCert         string `mapstructure:"sslcert"`          // 客户端证书
+	SSLKey          string `mapstructure:"sslkey"`           // 客户端证书私钥，权限应为 0600
+	ApplicationName string `mapstructure:"application_name"` // 显示在 pg_stat_activity 中，默认 oneclick-metrics
+	ConnectTimeout  int    `mapstructure:"connect_timeout"`  // 建立连接的超时时间（秒），0 表示不限制，默认 10
+	SearchPath      string `mapstructure:"search_path"`      // 连接的 search_path，例如 public
+
+	// 以下为主备相关配置
+	TargetSessionAttrs string `mapstructure:"target_session_attrs"` // any、read-write、read-only、primary、standby 或 prefer-standby，默认 any
+	MaxReplicaLag      int    `mapstructure:"max_replica_lag"`      // 连接到备库且复制延迟超过该秒数时跳过本轮采集，0 表示不跳过
 }
 
 type LogConfig struct {
-	Level string `mapstructure:"level"`
+	Level  string `mapstructure:"level"`  // 可以通过 PUT /-/log-level 在运行时修改
+	Format string `mapstructure:"format"` // console 或 json，默认 console
+	File   string `mapstructure:"file"`   // 日志文件路径，为空时输出到标准错误
+
+	// 以下为日志文件的滚动配置，只在设置了 file 时生效
+	MaxSize    int  `mapstructure:"max_size"`    // 单个文件的大小上限（MB），默认 100
+	MaxBackups int  `mapstructure:"max_backups"` // 保留的旧文件数量，0 表示全部保留
+	MaxAge     int  `mapstructure:"max_age"`     // 旧文件的保留天数，0 表示不按时间删除
+	Compress   bool `mapstructure:"compress"`    // 用 gzip 压缩旧文件
+
+	Sampling LogSamplingConfig `mapstructure:"sampling"`
+}
+
+// 日志采样：每秒内相同级别和内容的日志，前 initial 条全部输出，之后每 thereafter 条输出一条。initial 为 0 时不采样
+type LogSamplingConfig struct {
+	Initial    int `mapstructure:"initial"`
+	Thereafter int `mapstructure:"thereafter"`
+}
+
+// 告警与记录规则生成参数
+type RulesConfig struct {
+	Job                    string  `mapstructure:"job"`                      // Prometheus 抓取本 exporter 的 job 名称
+	MissingReportThreshold int     `mapstructure:"missing_report_threshold"` // 单个项目 open 状态缺失报告的 pr 数量上限
+	ComplianceThreshold    float64 `mapstructure:"compliance_threshold"`     // 单个项目 open 状态合规率下限，0 表示不生成该告警
+	StaleAfter             int     `mapstructure:"stale_after"`              // 采集任务多少秒没有成功后视为数据过期
+	For                    string  `mapstructure:"for"`                      // 告警持续时间
+}
+
+// Pushgateway 推送配置，url 为空时不启用
+type PushgatewayConfig struct {
+	URL      string            `mapstructure:"url"`
+	Job      string            `mapstructure:"job"`
+	Grouping map[string]string `mapstructure:"grouping"`
+	Username string            `mapstructure:"username"`
+	Password string            `mapstructure:"password"`
+}
+
+// Graphite plaintext 推送配置，address 为空时不启用
+type GraphiteConfig struct {
+	Address string `mapstructure:"address"` // host:port
+	Prefix  string `mapstructure:"prefix"`
+}
+
+// InfluxDB line protocol 推送配置，url 为空时不启用
+type InfluxConfig struct {
+	URL      string `mapstructure:"url"` // 完整的写入地址，例如 http://influx:8086/api/v2/write?org=o&bucket=b
+	Token    string `mapstructure:"token"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// Prometheus remote_write 推送配置，url 为空时不启用
+type RemoteWriteConfig struct {
+	URL               string            `mapstructure:"url"`
+	Headers           map[string]string `mapstructure:"headers"` // 额外的请求头，例如 X-Scope-OrgID
+	BearerToken       string            `mapstructure:"bearer_token"`
+	Username          string            `mapstructure:"username"`
+	Password          string            `mapstructure:"password"`
+	ExternalLabels    map[string]string `mapstructure:"external_labels"`     // 附加到每个序列上的标签
+	QueueDir          string            `mapstructure:"queue_dir"`           // 发送失败的请求持久化目录，为空时只在内存中排队
+	MaxQueuedRequests int               `mapstructure:"max_queued_requests"` // 最多排队的请求数，超出后丢弃最旧的请求
+	MaxRetries        int               `mapstructure:"max_retries"`         // 单个请求在一次推送中的最大重试次数
+	MinBackoffMs      int               `mapstructure:"min_backoff_ms"`
+	MaxBackoffMs      int               `mapstructure:"max_backoff_ms"`
+}
+
+// OpenTelemetry OTLP 推送配置，endpoint 为空时不启用
+type OTLPConfig struct {
+	Endpoint           string            `mapstructure:"endpoint"` // host:port
+	Protocol           string            `mapstructure:"protocol"` // grpc 或 http，默认 grpc
+	URLPath            string            `mapstructure:"url_path"` // http 协议下的路径，默认 /v1/metrics
+	Insecure           bool              `mapstructure:"insecure"`
+	Headers            map[string]string `mapstructure:"headers"`
+	ResourceAttributes map[string]string `mapstructure:"resource_attributes"` // 用于标识 Bitbucket 实例的资源属性
+	Attributes         map[string]string `mapstructure:"attributes"`          // 指标标签到 OTel 属性名的映射，映射为空字符串时丢弃该标签
+}
+
+// 每轮采集结束后的推送配置
+type PushConfig struct {
+	Timeout     int               `mapstructure:"timeout"` // 单次推送超时时间（秒）
+	Pushgateway PushgatewayConfig `mapstructure:"pushgateway"`
+	Graphite    GraphiteConfig    `mapstructure:"graphite"`
+	Influx      InfluxConfig      `mapstructure:"influx"`
+	RemoteWrite RemoteWriteConfig `mapstructure:"remote_write"`
+	OTLP        OTLPConfig        `mapstructure:"otlp"`
+}
+
+// HistoryConfig 本地历史数据存储，Path 为空时不启用
+type HistoryConfig struct {
+	Path          string `mapstructure:"path"`           // bbolt 数据文件路径
+	RetentionDays int    `mapstructure:"retention_days"` // 每日快照保留天数，默认 400
+}
+
+// ProjectsConfig 项目过滤条件，个人项目（~ 开头）总是被排除
+type ProjectsConfig struct {
+	Include []string `mapstructure:"include"` // 只统计这些项目，为空表示全部
+	Exclude []string `mapstructure:"exclude"` // 不统计这些项目
+}
+
+// WebConfig HTTP 服务的 TLS 与认证，都为空时以明文 HTTP 提供服务且不认证
+type WebConfig struct {
+	ConfigFile      string `mapstructure:"config_file"`       // exporter-toolkit 格式的 web 配置文件（tls_server_config、basic_auth_users 等）
+	BearerTokenFile string `mapstructure:"bearer_token_file"` // 允许访问的 bearer token，每行一个，修改后立即生效
+	EnableLifecycle bool   `mapstructure:"enable_lifecycle"`  // 开启 /-/reload 等修改运行状态的接口，默认关闭
+}
+
+// InstanceConfig 一个 Bitbucket 实例，Name 作为所有指标的 bitbucket_instance 标签
+type InstanceConfig struct {
+	Name     string   `mapstructure:"name"`
+	Database DataBase `mapstructure:"database"` // 没有设置的字段（url 除外）使用顶层 database 中的值
+	// DatabaseKeys 配置文件中该实例 database 下显式设置的字段，由 LoadConfig 填写。
+	// 显式设置为零值（例如 connect_timeout: 0）的字段不继承顶层的值
+	DatabaseKeys map[string]bool `mapstructure:"-"`
 }
 
 type ServerConfig struct {
-	Host         string    `mapstructure:"host"`
-	Port         string    `mapstructure:"port"`
-	DataBaseInfo DataBase  `mapstructure:"database"`
-	Timezone     string    `mapstructure:"timezone"`
-	Interval     int       `mapstructure:"interval"`
-	LogInfo      LogConfig `mapstructure:"log"`
+	Host               string           `mapstructure:"host"`
+	Port               string           `mapstructure:"port"`
+	DisableMetrics     bool             `mapstructure:"disable_metrics_endpoint"` // 只通过推送导出指标时关闭 /metrics
+	WatchConfig        bool             `mapstructure:"watch_config"`             // 配置文件变化时自动重新加载，默认开启
+	DataBaseInfo       DataBase         `mapstructure:"database"`
+	Instances          []InstanceConfig `mapstructure:"instances"` // 多个 Bitbucket 实例，为空时只采集 database
+	Timezone           string           `mapstructure:"timezone"`
+	Interval           int              `mapstructure:"interval"`
+	CycleTimeout       int              `mapstructure:"cycle_timeout"` // 每轮采集的超时时间（秒），0 表示与 interval 相同
+	LogInfo            LogConfig        `mapstructure:"log"`
+	ReportKeys         []string         `mapstructure:"report_keys"` // 需要统计的 code insight 报告类型
+	Projects           ProjectsConfig   `mapstructure:"projects"`
+	DisabledCollectors []string         `mapstructure:"disabled_collectors"` // 不执行的采集任务，例如 ClosedPRReport
+	Engine             string           `mapstructure:"engine"`              // 采集方式，queries 或 unified，默认 queries
+	Rules              RulesConfig      `mapstructure:"rules"`
+	Push               PushConfig       `mapstructure:"push"`
+	History            HistoryConfig    `mapstructure:"history"`
+	Web                WebConfig        `mapstructure:"web"`
 }
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SessionAttrs 支持的 target_session_attrs，含义与 libpq 相同
+var SessionAttrs = []string{"any", "read-write", "read-only", "primary", "standby", "prefer-standby"}
+
+// SplitHosts 把配置了多个 host 的数据库展开为每个 host 一份配置（顺序与配置相同），
+// 同时返回 target_session_attrs（url 中的参数优先，默认 any）。
+// 与 libpq 一样，host 用逗号分隔；port 只有一个时用于所有 host，否则数量需要与 host 相同。
+// url 中的 target_session_attrs 会被去掉，lib/pq 会把不认识的参数当作服务端参数发送
+func (db DataBase) SplitHosts() ([]DataBase, string, error) {
+	attrs := db.TargetSessionAttrs
+	if db.URL != "" {
+		return splitURLHosts(db, attrs)
+	}
+
+	hosts := strings.Split(db.Host, ",")
+	ports := strings.Split(db.Port, ",")
+	if len(ports) != 1 && len(ports) != len(hosts) {
+		return nil, "", fmt.Errorf("port 的数量 (%d) 与 host 的数量 (%d) 不一致", len(ports), len(hosts))
+	}
+	dbs := make([]DataBase, len(hosts))
+	for i, host := range hosts {
+		dbs[i] = db
+		dbs[i].Host = strings.TrimSpace(host)
+		dbs[i].Port = strings.TrimSpace(ports[0])
+		if len(ports) > 1 {
+			dbs[i].Port = strings.TrimSpace(ports[i])
+		}
+	}
+	return dbs, defaultSessionAttrs(attrs), nil
+}
+
+// url.Parse 不支持 postgres://h1:5432,h2:5432/db 这样的多个 host，先按逗号拆开 host 部分再分别解析
+func splitURLHosts(db DataBase, attrs string) ([]DataBase, string, error) {
+	scheme, rest, ok := strings.Cut(db.URL, "://")
+	if !ok {
+		return nil, "", fmt.Errorf("url 不是合法的 URL")
+	}
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		end = len(rest)
+	}
+	authority, tail := rest[:end], rest[end:]
+	userinfo := ""
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userinfo, authority = authority[:i+1], authority[i+1:]
+	}
+
+	hosts := strings.Split(authority, ",")
+	dbs := make([]DataBase, len(hosts))
+	for i, host := range hosts {
+		u, err := url.Parse(scheme + "://" + userinfo + host + tail)
+		if err != nil {
+			return nil, "", fmt.Errorf("url 不是合法的 URL")
+		}
+		query := u.Query()
+		if query.Has("target_session_attrs") {
+			attrs = query.Get("target_session_attrs")
+			query.Del("target_session_attrs")
+			u.RawQuery = query.Encode()
+		}
+		dbs[i] = db
+		dbs[i].URL = u.String()
+	}
+	return dbs, defaultSessionAttrs(attrs), nil
+}
+
+func defaultSessionAttrs(attrs string) string {
+	if attrs == "" {
+		return "any"
+	}
+	return attrs
+}
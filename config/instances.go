@@ -0,0 +1,46 @@
+package config
+
+import "reflect"
+
+// DefaultInstance 没有配置 instances 时，database 对应实例的名称
+const DefaultInstance = "default"
+
+// Targets 返回需要采集的实例。没有配置 instances 时只有 database 一个实例，名称为 default；
+// 配置了 instances 时，每个实例没有设置的数据库字段继承顶层 database 中的值，便于共用账号和 TLS 配置
+func (c *ServerConfig) Targets() []InstanceConfig {
+	if len(c.Instances) == 0 {
+		return []InstanceConfig{{Name: DefaultInstance, Database: c.DataBaseInfo}}
+	}
+	targets := make([]InstanceConfig, len(c.Instances))
+	for i, inst := range c.Instances {
+		targets[i] = InstanceConfig{Name: inst.Name, Database: inheritDatabase(inst.Database, c.DataBaseInfo, inst.DatabaseKeys)}
+	}
+	return targets
+}
+
+// 密码的各个来源作为一组继承：实例设置了其中任何一项时不继承顶层的任何一项，
+// 否则顶层的 password_file、password_command 等优先级更高的来源会覆盖实例自己的密码
+var passwordKeys = map[string]bool{"password": true, "password_file": true, "password_env": true, "password_command": true}
+
+// keys 为实例显式设置的字段，值不为零的字段也视为已设置
+func inheritDatabase(db, base DataBase, keys map[string]bool) DataBase {
+	v, b := reflect.ValueOf(&db).Elem(), reflect.ValueOf(base)
+	isSet := func(i int) bool {
+		return keys[v.Type().Field(i).Tag.Get("mapstructure")] || !v.Field(i).IsZero()
+	}
+	ownPassword := false
+	for i := 0; i < v.NumField(); i++ {
+		if passwordKeys[v.Type().Field(i).Tag.Get("mapstructure")] && isSet(i) {
+			ownPassword = true
+		}
+	}
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("mapstructure")
+		// 完整的连接字符串（例如 DATABASE_URL）只属于顶层配置，继承后会覆盖实例自己的 host 等配置
+		if key == "url" || isSet(i) || (ownPassword && passwordKeys[key]) {
+			continue
+		}
+		v.Field(i).Set(b.Field(i))
+	}
+	return db
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInheritDatabase(t *testing.T) {
+	base := DataBase{
+		URL:            "postgres://top",
+		User:           "stashreadonly",
+		PasswordFile:   "/run/secrets/db",
+		SSLMode:        "verify-full",
+		ConnectTimeout: 10,
+		MaxReplicaLag:  300,
+	}
+	tests := []struct {
+		name string
+		db   DataBase
+		keys map[string]bool
+		want DataBase
+	}{
+		{"继承未设置的字段", DataBase{Host: "prod"}, map[string]bool{"host": true},
+			DataBase{Host: "prod", User: "stashreadonly", PasswordFile: "/run/secrets/db", SSLMode: "verify-full", ConnectTimeout: 10, MaxReplicaLag: 300}},
+		{"url 不继承", DataBase{Host: "prod"}, nil,
+			DataBase{Host: "prod", User: "stashreadonly", PasswordFile: "/run/secrets/db", SSLMode: "verify-full", ConnectTimeout: 10, MaxReplicaLag: 300}},
+		{"实例自己的密码不被顶层 password_file 覆盖", DataBase{Host: "acq", Password: "secret"}, map[string]bool{"host": true, "password": true},
+			DataBase{Host: "acq", Password: "secret", User: "stashreadonly", SSLMode: "verify-full", ConnectTimeout: 10, MaxReplicaLag: 300}},
+		{"password_env 同样属于密码组", DataBase{Host: "acq", PasswordEnv: "ACQ_PW"}, nil,
+			DataBase{Host: "acq", PasswordEnv: "ACQ_PW", User: "stashreadonly", SSLMode: "verify-full", ConnectTimeout: 10, MaxReplicaLag: 300}},
+		{"显式设置为零值", DataBase{Host: "legacy"}, map[string]bool{"host": true, "max_replica_lag": true, "sslmode": true},
+			DataBase{Host: "legacy", User: "stashreadonly", PasswordFile: "/run/secrets/db", ConnectTimeout: 10}},
+		{"显式设置空密码", DataBase{Host: "trust"}, map[string]bool{"host": true, "password": true},
+			DataBase{Host: "trust", User: "stashreadonly", SSLMode: "verify-full", ConnectTimeout: 10, MaxReplicaLag: 300}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := inheritDatabase(tt.db, base, tt.keys)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inheritDatabase() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testKnown = Known{Collectors: []string{"OpenPRReport", "ClosedPRReport"}, MetricLabels: []string{"bitbucket_instance", "project"}}
+
+// 默认值组成的合法配置
+func validConfig() ServerConfig {
+	return ServerConfig{
+		Port:     "8000",
+		Interval: 15,
+		Timezone: "UTC",
+		DataBaseInfo: DataBase{
+			Driver: "postgres", Host: "db", User: "u", Dbname: "bb",
+			SSLMode: "verify-full", ConnectTimeout: 10,
+		},
+		LogInfo:    LogConfig{Level: "info", Format: "console", MaxSize: 100},
+		ReportKeys: []string{"ci", "sast"},
+		Engine:     EngineQueries,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(c *ServerConfig)
+		databases bool
+		want      []string // 出错的配置项，按发现的顺序
+	}{
+		{"合法配置", func(c *ServerConfig) {}, true, nil},
+		{"一次返回全部问题", func(c *ServerConfig) {
+			c.Port, c.Interval, c.Timezone = "70000", 0, "Mars/Base"
+			c.LogInfo.Level = "verbose"
+		}, false, []string{"port", "interval", "timezone", "log.level"}},
+		{"列表中的空值和重复值", func(c *ServerConfig) {
+			c.ReportKeys = []string{"ci", "", "ci"}
+			c.Projects = ProjectsConfig{Include: []string{"ABC"}, Exclude: []string{"ABC"}}
+		}, false, []string{"report_keys[1]", "report_keys[2]", "projects.exclude"}},
+		{"未知的采集任务", func(c *ServerConfig) {
+			c.DisabledCollectors = []string{"ClosedPRReport", "NoSuchReport"}
+		}, false, []string{"disabled_collectors[1]"}},
+		{"engine", func(c *ServerConfig) { c.Engine = "sql" }, false, []string{"engine"}},
+		{"rules", func(c *ServerConfig) {
+			c.Rules = RulesConfig{ComplianceThreshold: 1.5, For: "ten minutes"}
+		}, false, []string{"rules.compliance_threshold", "rules.for"}},
+		{"push 地址", func(c *ServerConfig) {
+			c.Push.Pushgateway.URL = "pgw:9091"
+			c.Push.Graphite.Address = "graphite"
+			c.Push.OTLP = OTLPConfig{Endpoint: "otel:4317", Protocol: "thrift", URLPath: "v1/metrics"}
+		}, false, []string{"push.pushgateway.url", "push.graphite.address", "push.otlp.protocol", "push.otlp.url_path"}},
+		{"Pushgateway 分组标签与指标标签同名", func(c *ServerConfig) {
+			c.Push.Pushgateway = PushgatewayConfig{URL: "http://pgw:9091", Grouping: map[string]string{"project": "x", "instance": "prod", "bitbucket_instance": "prod"}}
+		}, false, []string{"push.pushgateway.grouping.bitbucket_instance", "push.pushgateway.grouping.project"}},
+		{"remote_write 认证和重试", func(c *ServerConfig) {
+			c.Push.RemoteWrite = RemoteWriteConfig{URL: "http://mimir/api/v1/push", BearerToken: "t", Username: "u", MinBackoffMs: 500, MaxBackoffMs: 100}
+		}, false, []string{"push.remote_write.min_backoff_ms", "push.remote_write.bearer_token"}},
+		{"web 文件不存在", func(c *ServerConfig) {
+			c.Web.BearerTokenFile = filepath.Join(t.TempDir(), "tokens")
+		}, false, []string{"web.bearer_token_file"}},
+		{"不连接数据库时不检查数据库", func(c *ServerConfig) {
+			c.DataBaseInfo = DataBase{}
+		}, false, nil},
+		{"数据库", func(c *ServerConfig) {
+			c.DataBaseInfo.Host, c.DataBaseInfo.SSLMode, c.DataBaseInfo.SSLCert = "", "prefer", "/etc/ssl/client.crt"
+			c.DataBaseInfo.PasswordEnv = "ONECLICK_TEST_MISSING"
+		}, true, []string{"database.host", "database.password_env", "database.sslmode", "database.sslcert", "database.sslkey"}},
+		{"实例", func(c *ServerConfig) {
+			c.Instances = []InstanceConfig{
+				{Name: "prod", Database: DataBase{Host: "prod"}},
+				{Name: "prod", Database: DataBase{Host: "acq", Port: "5432x"}},
+				{Name: "a/b", Database: DataBase{Host: "legacy"}},
+			}
+		}, true, []string{"instances[1].name", "instances[1].database.port", "instances[2].name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PGSERVICE", "")
+			c := validConfig()
+			tt.modify(&c)
+			err := c.validate(testKnown, tt.databases)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("validate() = %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("validate() = %v，应返回 ValidationErrors", err)
+			}
+			var fields []string
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.want) {
+				t.Errorf("出错的配置项 = %v, want %v\n%v", fields, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidationErrorsError(t *testing.T) {
+	errs := ValidationErrors{
+		{Field: "port", Message: "不能为空"},
+		{Field: "push.remote_write.url", Message: "应为 http:// 或 https:// 开头的完整地址，当前为 \"mimir\""},
+	}
+	want := "配置校验失败，共 2 个问题:\n" +
+		"  - port: 不能为空\n" +
+		"  - push.remote_write.url: 应为 http:// 或 https:// 开头的完整地址，当前为 \"mimir\""
+	if got := errs.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -3,45 +3,166 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
-	"oneclick-metrics-go/global"
+	"net"
+	"net/url"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/secret"
+	"strconv"
 	"time"
 )
 
-// 全局连接
-var DB *sql.DB
-
-// 初始化数据库连接
-func InitDb() error {
-	var connStr string
-	config := global.ServerConfig
-	connStr = fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s",
-		//config.DataBaseInfo.Driver,
-		config.DataBaseInfo.User,
-		config.DataBaseInfo.Password,
-		config.DataBaseInfo.Host,
-		config.DataBaseInfo.Port,
-		config.DataBaseInfo.Dbname,
-	)
-
-	db, err := sql.Open(config.DataBaseInfo.Driver, connStr)
+// DSN 返回数据库连接字符串。配置了 url（或 DATABASE_URL）时以它为准，只补充其中没有的连接参数
+func DSN(cfg config.DataBase) string {
+	var u *url.URL
+	if cfg.URL != "" {
+		parsed, err := url.Parse(cfg.URL)
+		if err != nil {
+			// 校验阶段已经检查过 url，这里原样交给驱动报错
+			return cfg.URL
+		}
+		u = parsed
+	} else {
+		u = &url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(cfg.User, cfg.Password),
+			Host:   net.JoinHostPort(cfg.Host, cfg.Port),
+			Path:   "/" + cfg.Dbname,
+		}
+		if cfg.Password == "" {
+			u.User = url.User(cfg.User)
+		}
+	}
+
+	query := u.Query()
+	for key, value := range connectionParams(cfg) {
+		if value != "" && !query.Has(key) {
+			query.Set(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// 连接参数，参数名与 libpq 相同
+func connectionParams(cfg config.DataBase) map[string]string {
+	params := map[string]string{
+		"sslmode":          cfg.SSLMode,
+		"sslrootcert":      cfg.SSLRootCert,
+		"sslcert":          cfg.SSLCert,
+		"sslkey":           cfg.SSLKey,
+		"application_name": cfg.ApplicationName,
+		"search_path":      cfg.SearchPath,
+	}
+	if cfg.ConnectTimeout > 0 {
+		params["connect_timeout"] = strconv.Itoa(cfg.ConnectTimeout)
+	}
+	return params
+}
+
+// Open 补全密码后打开 name 实例的连接池并检查连接，之后每次新建连接时重新补全密码。配置了多个 host 或 target_session_attrs 时，
+// 每次新建连接都按顺序选择满足条件的服务器。连接检查失败时同时返回连接池和错误，
+// 由调用方决定是否继续使用（连接池会在之后的请求中重新建立连接）
+func Open(name string, cfg config.DataBase) (*sql.DB, error) {
+	targets, attrs, err := cfg.SplitHosts()
 	if err != nil {
-		zap.S().Errorf("打开数据库失败: %w", err)
-		return err
+		return nil, fmt.Errorf("[%s] 数据库配置有误: %w", name, err)
+	}
+
+	// 所有 host 共用一份缓存，password_command 在一次 Open 中只执行一次
+	cache := &secret.Cache{}
+	hosts := make([]hostConnector, 0, len(targets))
+	for _, target := range targets {
+		// 先获取一次密码并检查连接字符串，配置有误时立即返回错误
+		dbInfo, err := cache.Resolve(context.Background(), target)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] 获取数据库密码失败: %w", name, err)
+		}
+		dsn := DSN(dbInfo)
+		zap.S().Infof("[%s] 连接数据库: %s", name, secret.RedactDSN(dsn))
+		if _, err := pq.NewConnector(dsn); err != nil {
+			return nil, fmt.Errorf("[%s] 打开数据库失败: %w", name, err)
+		}
+		hosts = append(hosts, hostConnector{
+			addr:      hostAddr(dbInfo),
+			connector: &secretConnector{name: name, target: target, cache: cache},
+		})
+	}
+
+	var db *sql.DB
+	if len(hosts) == 1 && attrs == "any" {
+		db = sql.OpenDB(hosts[0].connector)
+	} else {
+		zap.S().Infof("[%s] 共 %d 个 host，target_session_attrs=%s", name, len(hosts), attrs)
+		db = sql.OpenDB(&failoverConnector{name: name, attrs: attrs, hosts: hosts})
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// 超时不小于 connect_timeout，避免 TLS 握手较慢时提前放弃；多个 host 时可能依次尝试每个 host
+	timeout := 5 * time.Second
+	if t := time.Duration(cfg.ConnectTimeout*len(hosts)) * time.Second; t > timeout {
+		timeout = t
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	if err := db.PingContext(ctx); err != nil {
-		zap.S().Errorf("数据库连接失败: %w", err)
-		return err
+		return db, fmt.Errorf("[%s] 数据库连接失败: %w", name, err)
 	}
+	return db, nil
+}
 
-	DB = db
+// secretConnector 在每次新建连接时补全密码。password_command 和 password_file 的密码缓存在 cache 中，
+// 认证失败时重新获取一次，密码轮换后连接池重新建立连接即可使用新密码，不需要重启
+type secretConnector struct {
+	name   string
+	target config.DataBase
+	cache  *secret.Cache
+}
 
-	return nil
+func (c *secretConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connect(ctx)
+	if isAuthError(err) && c.cache.Invalidate() {
+		zap.S().Infof("[%s] 数据库认证失败，重新获取密码", c.name)
+		conn, err = c.connect(ctx)
+	}
+	return conn, err
+}
+
+func (c *secretConnector) connect(ctx context.Context) (driver.Conn, error) {
+	dbInfo, err := c.cache.Resolve(ctx, c.target)
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库密码失败: %w", err)
+	}
+	connector, err := pq.NewConnector(DSN(dbInfo))
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *secretConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// 密码错误或用户没有权限时服务器返回的错误
+func isAuthError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "28P01" || pqErr.Code == "28000"
+}
+
+// 日志中显示的服务器地址，不包含密码
+func hostAddr(cfg config.DataBase) string {
+	if cfg.URL != "" {
+		if u, err := url.Parse(cfg.URL); err == nil {
+			return u.Host
+		}
+	}
+	return net.JoinHostPort(cfg.Host, cfg.Port)
 }
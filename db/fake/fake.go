@@ -0,0 +1,175 @@
+// Package fake 提供按查询名称返回预置结果的假数据库，满足 db.Querier，用于在没有 Bitbucket 数据库时验证采集逻辑。
+//
+// 查询名称由 db.QueryName 识别，例如 EXECUTE pr_counts_query(...) 的名称为 pr_counts_query。
+// 结果经过 database/sql 的 Scan 转换，与真实数据库一样可以扫描到 string、int、sql.NullString 等类型。
+// 每行的值按查询 select 的列顺序排列，pr_counts_query 依次为 pr_state、project_key 和数量：
+//
+//	f := fake.New()
+//	f.Set("pr_counts_query", []any{"0", "PROJ", "3"})
+//	f.Set(db.ProjectKeysQueryName, []any{"PROJ"}, []any{"OTHER"})
+//	err := metrics.ExportPRNum(ctx, inst, f)
+package fake
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	dbase "oneclick-metrics-go/db"
+	"sync"
+)
+
+type result struct {
+	rows [][]any
+	err  error
+}
+
+// DB 假数据库。没有设置结果的查询返回错误，便于发现新增的查询
+type DB struct {
+	db *sql.DB
+
+	mu         sync.Mutex
+	results    map[string]result
+	statements map[string]result
+	queries    []string
+}
+
+func New() *DB {
+	f := &DB{results: make(map[string]result), statements: make(map[string]result)}
+	f.db = Open(f.query)
+	return f
+}
+
+// Open 返回由 lookup 提供查询结果的 *sql.DB，lookup 的参数为完整语句，返回的每行值按 select 的列顺序排列
+func Open(lookup func(query string) ([][]any, error)) *sql.DB {
+	return sql.OpenDB(connector{lookup})
+}
+
+// Set 设置查询 name 返回的行，每行的值按 select 的列顺序排列；不传入行时查询返回空结果
+func (f *DB) Set(name string, rows ...[]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[name] = result{rows: rows}
+}
+
+// SetStatement 设置完整语句 statement 返回的行，优先于 Set 设置的结果，
+// 用于同一个查询的结果取决于参数的情况，例如 check_summary_query 的项目列表
+func (f *DB) SetStatement(statement string, rows ...[]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements[statement] = result{rows: rows}
+}
+
+// SetError 设置查询 name 返回的错误
+func (f *DB) SetError(name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[name] = result{err: err}
+}
+
+// Queries 返回执行过的完整语句，按执行顺序排列，用于检查传入的参数
+func (f *DB) Queries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.queries...)
+}
+
+func (f *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return f.db.QueryContext(ctx, query, args...)
+}
+
+func (f *DB) Close() error {
+	return f.db.Close()
+}
+
+func (f *DB) query(query string) ([][]any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, query)
+
+	name := dbase.QueryName(query)
+	res, ok := f.statements[query]
+	if !ok {
+		res, ok = f.results[name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("fake: 没有设置查询 %q 的结果", name)
+	}
+	return res.rows, res.err
+}
+
+func newRows(query string, values [][]any) (driver.Rows, error) {
+	rows := &rows{}
+	for _, row := range values {
+		converted := make([]driver.Value, len(row))
+		for i, v := range row {
+			value, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("fake: 查询 %q 的值 %v 无法转换: %w", dbase.QueryName(query), v, err)
+			}
+			converted[i] = value
+		}
+		rows.values = append(rows.values, converted)
+		if len(converted) > len(rows.columns) {
+			rows.columns = make([]string, len(converted))
+			for i := range rows.columns {
+				rows.columns[i] = fmt.Sprintf("col%d", i+1)
+			}
+		}
+	}
+	return rows, nil
+}
+
+type connector struct {
+	lookup func(query string) ([][]any, error)
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return conn{c.lookup}, nil }
+func (c connector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake: 只能通过 fake.New 或 fake.Open 使用")
+}
+
+type conn struct {
+	lookup func(query string) ([][]any, error)
+}
+
+func (c conn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	values, err := c.lookup(query)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(query, values)
+}
+
+func (c conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake: 不支持 prepare")
+}
+
+func (c conn) Close() error { return nil }
+
+func (c conn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake: 不支持事务")
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package fixture 把采集任务的查询结果录制为 JSON 文件，并在没有数据库时回放，
+// 用于在公司网络以外复现线上问题和离线演示。
+//
+// 文件按实例和查询名称存放：<dir>/<instance>/<query>.json，每个文件保存该查询最近一次采集时
+// 执行的所有语句（同一查询可能使用不同参数执行多次）及其结果。
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Version 文件格式版本，格式不兼容地变化时加一，回放时拒绝其他版本的文件
+const Version = 1
+
+// File 一个查询的录制结果
+type File struct {
+	Version    int       `json:"version"`
+	Instance   string    `json:"instance"`
+	Query      string    `json:"query"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Calls      []Call    `json:"calls"`
+}
+
+// Call 一次执行的语句及其结果
+type Call struct {
+	Statement string   `json:"statement"`
+	Columns   []Column `json:"columns"`
+	Rows      [][]any  `json:"rows"`
+}
+
+// Column 列名和数据库类型，回放时按类型还原数值和时间
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func path(dir, instance, query string) string {
+	return filepath.Join(dir, instance, query+".json")
+}
+
+// 没有名称的语句（不是 EXECUTE，也没有 /* 名称 */）无法对应到文件
+func fileName(query string) (string, error) {
+	if query == "" || strings.ContainsAny(query, `/\`) {
+		return "", fmt.Errorf("无法识别查询名称")
+	}
+	return query, nil
+}
+
+func writeFile(dir string, f File) error {
+	name, err := fileName(f.Query)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := path(dir, f.Instance, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// 先写临时文件再改名，回放时不会读到写了一半的文件
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func readFile(p string) (File, error) {
+	var f File
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return f, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+	if err := dec.Decode(&f); err != nil {
+		return f, fmt.Errorf("%s: %w", p, err)
+	}
+	if f.Version != Version {
+		return f, fmt.Errorf("%s: 不支持的文件版本 %d，当前版本为 %d，需要重新录制", p, f.Version, Version)
+	}
+	return f, nil
+}
+
+func ensureDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("fixture: 创建目录 %s 失败: %w", dir, err)
+	}
+	return nil
+}
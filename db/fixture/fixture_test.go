@@ -0,0 +1,244 @@
+package fixture
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeRows(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.FixedZone("CST", 8*3600))
+	columns := []Column{
+		{Name: "cnt", Type: "INT8"},
+		{Name: "pr_state", Type: "INT4"},
+		{Name: "coverage", Type: "FLOAT8"},
+		{Name: "ratio", Type: "NUMERIC"},
+		{Name: "updated", Type: "TIMESTAMPTZ"},
+		{Name: "project_key", Type: "VARCHAR"},
+		{Name: "enabled", Type: "BOOL"},
+		{Name: "repo", Type: "TEXT"},
+	}
+	tests := []struct {
+		name    string
+		columns []Column
+		rows    [][]any
+		want    [][]any
+		err     string // 错误应包含的内容，为空表示成功
+	}{
+		{"按列类型还原", columns,
+			[][]any{{json.Number("9007199254740993"), json.Number("2"), json.Number("81.25"), "0.5", at.Format(time.RFC3339Nano), "ABC", true, nil}},
+			[][]any{{int64(9007199254740993), int64(2), 81.25, "0.5", at, "ABC", true, nil}}, ""},
+		{"没有类型的数值按浮点数还原", []Column{{Name: "x"}}, [][]any{{json.Number("3")}}, [][]any{{float64(3)}}, ""},
+		{"没有行", columns, [][]any{}, [][]any{}, ""},
+		{"列数不一致", columns[:2], [][]any{{json.Number("1")}}, nil, "第 1 行有 1 列，应为 2 列"},
+		{"整数列中的小数", columns[:1], [][]any{{json.Number("1.5")}}, nil, "cnt 列"},
+		{"时间格式不对", []Column{{Name: "updated", Type: "TIMESTAMP"}}, [][]any{{"2024-05-06 07:08:09"}}, nil, "updated 列"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeRows(Call{Statement: "EXECUTE q", Columns: tt.columns, Rows: tt.rows})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("decodeRows() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range got {
+				for j, v := range row {
+					// time.Time 比较时间点，不比较内部的时区指针
+					if tm, ok := v.(time.Time); ok && tm.Equal(at) {
+						row[j] = at
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeRows() = %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 录制后回放，调用方扫描得到的值与直接查询数据库相同
+func TestRecordReplay(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	source := sql.OpenDB(typedConnector{results: map[string]typedResult{
+		"EXECUTE pr_counts_query('2024-05-06')": {
+			columns: []Column{{"pr_state", "INT4"}, {"project_key", "VARCHAR"}, {"cnt", "INT8"}},
+			rows:    [][]driver.Value{{int64(0), "ABC", int64(3)}, {int64(1), "XYZ", int64(9007199254740993)}},
+		},
+		"EXECUTE check_summary_query('{ABC}')": {
+			columns: []Column{{"project_key", "VARCHAR"}, {"ratio", "NUMERIC"}, {"updated", "TIMESTAMPTZ"}, {"repo", "TEXT"}},
+			rows:    [][]driver.Value{{"ABC", []byte("0.75"), at, nil}},
+		},
+		"EXECUTE check_summary_query('{XYZ}')": {
+			columns: []Column{{"project_key", "VARCHAR"}, {"ratio", "NUMERIC"}, {"updated", "TIMESTAMPTZ"}, {"repo", "TEXT"}},
+			rows:    [][]driver.Value{},
+		},
+	}})
+	defer source.Close()
+	statements := []string{"EXECUTE pr_counts_query('2024-05-06')", "EXECUTE check_summary_query('{ABC}')", "EXECUTE check_summary_query('{XYZ}')"}
+
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	session := rec.Session("prod", source)
+	var recorded [][][]any
+	for _, s := range statements {
+		want := scan(t, source, s)
+		got := scan(t, session, s)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("录制时返回给调用方的结果不同: %s\n%+v\nwant %+v", s, got, want)
+		}
+		recorded = append(recorded, got)
+	}
+	if err := session.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pr_counts_query", "check_summary_query"} {
+		if _, err := os.Stat(filepath.Join(dir, "prod", name+".json")); err != nil {
+			t.Errorf("没有写入 %s: %v", name, err)
+		}
+	}
+
+	replay, err := OpenReplay(dir, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	for i, s := range statements {
+		if got := scan(t, replay, s); !reflect.DeepEqual(got, recorded[i]) {
+			t.Errorf("回放结果不同: %s\n%+v\nwant %+v", s, got, recorded[i])
+		}
+	}
+	// 只执行过一次的查询，参数不同时也使用录制的结果；执行过多次的查询需要语句完全相同
+	if got := scan(t, replay, "EXECUTE pr_counts_query('2024-05-07')"); !reflect.DeepEqual(got, recorded[0]) {
+		t.Errorf("按名称回放的结果不同: %+v", got)
+	}
+	if _, err := replay.QueryContext(ctx, "EXECUTE check_summary_query('{OTHER}')"); err == nil {
+		t.Error("没有录制的语句应返回错误")
+	}
+
+	if _, err := OpenReplay(dir, "acq"); err == nil {
+		t.Error("没有录制文件的实例应返回错误")
+	}
+}
+
+func TestReadFileVersion(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeFile(dir, File{Version: Version + 1, Instance: "prod", Query: "q"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenReplay(dir, "prod"); err == nil || !strings.Contains(err.Error(), "需要重新录制") {
+		t.Fatalf("OpenReplay() = %v", err)
+	}
+	if err := writeFile(dir, File{Version: Version, Instance: "prod", Query: "../q"}); err == nil {
+		t.Error("名称中有 / 的查询不能写入文件")
+	}
+}
+
+// 扫描出的所有值，[]byte 转为 string、时间转为 UTC 后比较，整数与浮点数保持区分
+func scan(t *testing.T, q interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}, statement string) [][]any {
+	t.Helper()
+	rows, err := q.QueryContext(context.Background(), statement)
+	if err != nil {
+		t.Fatalf("%s: %v", statement, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := [][]any{}
+	for rows.Next() {
+		row := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range ptrs {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+		for i, v := range row {
+			switch v := v.(type) {
+			case []byte:
+				row[i] = string(v)
+			case time.Time:
+				row[i] = v.UTC()
+			}
+		}
+		out = append(out, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%s: %v", statement, err)
+	}
+	return out
+}
+
+// 返回带数据库类型的结果，与 lib/pq 一样通过 DatabaseTypeName 报告列类型
+type typedResult struct {
+	columns []Column
+	rows    [][]driver.Value
+}
+
+type typedConnector struct {
+	results map[string]typedResult
+}
+
+func (c typedConnector) Connect(context.Context) (driver.Conn, error) { return typedConn(c), nil }
+func (c typedConnector) Driver() driver.Driver                        { return nil }
+
+type typedConn typedConnector
+
+func (c typedConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	res, ok := c.results[query]
+	if !ok {
+		return nil, errors.New("没有设置结果: " + query)
+	}
+	return &typedRows{columns: res.columns, rows: append([][]driver.Value(nil), res.rows...)}, nil
+}
+
+func (typedConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("不支持 prepare") }
+func (typedConn) Close() error                        { return nil }
+func (typedConn) Begin() (driver.Tx, error)           { return nil, errors.New("不支持事务") }
+
+type typedRows struct {
+	columns []Column
+	rows    [][]driver.Value
+}
+
+func (r *typedRows) Columns() []string {
+	names := make([]string, len(r.columns))
+	for i, c := range r.columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+func (r *typedRows) ColumnTypeDatabaseTypeName(i int) string { return r.columns[i].Type }
+func (r *typedRows) Close() error                            { return nil }
+
+func (r *typedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,136 @@
+package fixture
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	dbase "oneclick-metrics-go/db"
+	"oneclick-metrics-go/db/fake"
+	"sync"
+	"time"
+)
+
+// Recorder 把查询结果写入 dir，每次采集覆盖上一次的记录
+type Recorder struct {
+	dir string
+}
+
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := ensureDir(dir); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// Dir 返回录制目录
+func (r *Recorder) Dir() string {
+	return r.dir
+}
+
+// Session 记录一次采集任务中 q 上执行的查询，任务结束后调用 Save 写入文件
+func (r *Recorder) Session(instance string, q dbase.Querier) *Session {
+	s := &Session{rec: r, instance: instance, q: q, calls: make(map[string][]Call)}
+	s.db = fake.Open(s.lookup)
+	return s
+}
+
+// Session 满足 db.Querier：在 q 上执行查询并读出全部结果，再把同样的结果返回给调用方
+type Session struct {
+	rec      *Recorder
+	instance string
+	q        dbase.Querier
+	db       *sql.DB
+
+	mu      sync.Mutex
+	calls   map[string][]Call
+	pending map[string][][]any // 已读出、等待返回给调用方的结果
+}
+
+func (s *Session) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	call, values, err := readRows(query, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	name := dbase.QueryName(query)
+	s.calls[name] = append(s.calls[name], call)
+	if s.pending == nil {
+		s.pending = make(map[string][][]any)
+	}
+	s.pending[query] = values
+	s.mu.Unlock()
+
+	return s.db.QueryContext(ctx, query)
+}
+
+func (s *Session) lookup(query string) ([][]any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values, ok := s.pending[query]
+	if !ok {
+		return nil, fmt.Errorf("fixture: 查询 %q 没有录制结果", dbase.QueryName(query))
+	}
+	delete(s.pending, query)
+	return values, nil
+}
+
+// Save 每个查询名称写入一个文件，覆盖上一次的记录
+func (s *Session) Save() error {
+	s.db.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for name, calls := range s.calls {
+		f := File{Version: Version, Instance: s.instance, Query: name, RecordedAt: now, Calls: calls}
+		if err := writeFile(s.rec.dir, f); err != nil {
+			return fmt.Errorf("fixture: 写入 %s 失败: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// 读出全部结果。values 保留驱动返回的类型，call 中的值转换为 JSON 可以表示的形式
+func readRows(query string, rows *sql.Rows) (call Call, values [][]any, err error) {
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return call, nil, err
+	}
+	call = Call{Statement: query, Columns: make([]Column, len(types)), Rows: [][]any{}}
+	for i, t := range types {
+		call.Columns[i] = Column{Name: t.Name(), Type: t.DatabaseTypeName()}
+	}
+
+	for rows.Next() {
+		row := make([]any, len(types))
+		ptrs := make([]any, len(types))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return call, nil, err
+		}
+		encoded := make([]any, len(row))
+		for i, v := range row {
+			encoded[i] = encodeValue(v)
+		}
+		values = append(values, row)
+		call.Rows = append(call.Rows, encoded)
+	}
+	return call, values, rows.Err()
+}
+
+func encodeValue(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
@@ -0,0 +1,104 @@
+package fixture
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	dbase "oneclick-metrics-go/db"
+	"oneclick-metrics-go/db/fake"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Replayer 满足 db.Querier，按录制的结果回答查询，不需要数据库
+type Replayer struct {
+	db         *sql.DB
+	statements map[string][][]any
+	names      map[string][][]any // 只执行过一次的查询，语句不同（例如项目列表顺序变化）时也使用该结果
+}
+
+// OpenReplay 读取 dir 中 instance 的所有录制文件
+func OpenReplay(dir, instance string) (*Replayer, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, instance, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("fixture: %s 中没有实例 %s 的录制文件", dir, instance)
+	}
+
+	r := &Replayer{statements: make(map[string][][]any), names: make(map[string][][]any)}
+	for _, p := range paths {
+		f, err := readFile(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, call := range f.Calls {
+			values, err := decodeRows(call)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", p, err)
+			}
+			r.statements[call.Statement] = values
+		}
+		if len(f.Calls) == 1 {
+			r.names[f.Query] = r.statements[f.Calls[0].Statement]
+		}
+	}
+	r.db = fake.Open(r.lookup)
+	return r, nil
+}
+
+func (r *Replayer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return r.db.QueryContext(ctx, query, args...)
+}
+
+func (r *Replayer) Close() error {
+	return r.db.Close()
+}
+
+func (r *Replayer) lookup(query string) ([][]any, error) {
+	if values, ok := r.statements[query]; ok {
+		return values, nil
+	}
+	if values, ok := r.names[dbase.QueryName(query)]; ok {
+		return values, nil
+	}
+	return nil, fmt.Errorf("fixture: 没有录制语句 %q 的结果", strings.TrimSpace(query))
+}
+
+// 按列类型还原录制时转换过的值：整数列还原为 int64，时间列还原为 time.Time
+func decodeRows(call Call) ([][]any, error) {
+	values := make([][]any, len(call.Rows))
+	for i, row := range call.Rows {
+		if len(row) != len(call.Columns) {
+			return nil, fmt.Errorf("语句 %q 第 %d 行有 %d 列，应为 %d 列", call.Statement, i+1, len(row), len(call.Columns))
+		}
+		values[i] = make([]any, len(row))
+		for j, v := range row {
+			value, err := decodeValue(v, call.Columns[j].Type)
+			if err != nil {
+				return nil, fmt.Errorf("语句 %q 第 %d 行 %s 列: %w", call.Statement, i+1, call.Columns[j].Name, err)
+			}
+			values[i][j] = value
+		}
+	}
+	return values, nil
+}
+
+func decodeValue(v any, typ string) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if strings.HasPrefix(typ, "INT") {
+			return v.Int64()
+		}
+		return v.Float64()
+	case string:
+		switch typ {
+		case "TIMESTAMP", "TIMESTAMPTZ", "DATE":
+			return time.Parse(time.RFC3339Nano, v)
+		}
+	}
+	return v, nil
+}
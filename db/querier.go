@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"oneclick-metrics-go/config"
+	"strings"
+)
+
+// Querier 采集任务依赖的数据库接口，*sql.Conn、*sql.DB 以及 db/fake 中的假数据库都满足
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// 不需要 prepare 的查询以 /* 名称 */ 开头，QueryName 据此识别
+const ProjectKeysQueryName = "project_keys_query"
+
+// ProjectKeysQuery 返回查询所有项目 key 的语句，项目过滤条件来自 cfg
+func ProjectKeysQuery(cfg *config.ServerConfig) string {
+	return `/* ` + ProjectKeysQueryName + ` */
+            SELECT DISTINCT project.project_key
+            FROM sta_pull_request pr
+            INNER JOIN repository repo ON pr.from_repository_id = repo.id
+            INNER JOIN project ON repo.project_id = project.id
+            WHERE ` + ProjectFilter(cfg.Projects, "project.project_key")
+}
+
+// QueryName 返回语句对应的查询名称：EXECUTE name(...) 返回 name，以 /* name */ 开头的语句返回 name，
+// 其他语句返回空字符串
+func QueryName(query string) string {
+	q := strings.TrimSpace(query)
+	if rest, ok := cutPrefixFold(q, "EXECUTE "); ok {
+		rest = strings.TrimSpace(rest)
+		if i := strings.IndexAny(rest, "( ;"); i >= 0 {
+			rest = rest[:i]
+		}
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(q, "/*"); ok {
+		if name, _, ok := strings.Cut(rest, "*/"); ok {
+			return strings.TrimSpace(name)
+		}
+	}
+	return ""
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return "", false
+}
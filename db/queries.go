@@ -4,21 +4,37 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
-	"log"
+	"oneclick-metrics-go/config"
+	"sort"
 	"strings"
 	"time"
 )
 
-func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
-	sqlNameMap := map[string]string{
-		"check_summary_query":     "check_summary_query(text[])",
-		"closed_pr_report_query":  "closed_pr_report_query(timestamp)",
-		"open_pr_report_query":    "open_pr_report_query",
-		"result_report_query":     "result_report_query(text)",
-		"pr_counts_query":         "pr_counts_query(timestamp)",
-		"pr_missing_report_query": "pr_missing_report_query(timestamp)",
+// 查询名称与 prepare 时使用的名称和参数类型的对应关系
+var sqlNameMap = map[string]string{
+	"check_summary_query":     "check_summary_query(text[])",
+	"closed_pr_report_query":  "closed_pr_report_query(timestamp)",
+	"open_pr_report_query":    "open_pr_report_query",
+	"result_report_query":     "result_report_query(text[])",
+	"pr_counts_query":         "pr_counts_query(timestamp)",
+	"pr_missing_report_query": "pr_missing_report_query(timestamp)",
+	"pr_compliance_query":     "pr_compliance_query(timestamp)",
+	"pr_detail_query":         "pr_detail_query(timestamp)",
+}
+
+// QueryKeys 返回所有已注册查询的名称（按字母排序）
+func QueryKeys() []string {
+	keys := make([]string, 0, len(sqlNameMap))
+	for k := range sqlNameMap {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	return keys
+}
+
+func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
 	regSQLs := map[string]string{
 		"check_summary_query(text[])": `
 			SELECT
@@ -33,12 +49,15 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
                 project.project_key = ANY($1)
 	        GROUP BY
                 project.project_key,
-				repo.slug	`,
+				repo.slug
+            ORDER BY
+                project.project_key,
+                repo.slug`,
 		"closed_pr_report_query(timestamp)": `
 			SELECT
                 repo.slug AS stash_repo,
                 pr.scoped_id AS prno,
-                string_agg (DISTINCT insrep."REPORT_KEY", ',') FILTER (WHERE insrep."REPORT_KEY" in ( 'sast','sabug','savul','sasmell','smoke','ci','codecoverage','snyk' )) AS present_reports,
+                string_agg (DISTINCT insrep."REPORT_KEY", ',') FILTER (WHERE insrep."REPORT_KEY" in (%REPORT_KEYS%)) AS present_reports,
                 project.project_key AS "project",
 				'[' || string_agg(DISTINCT nullif(insrep."DATA",''),',') || ']' AS report_data
             FROM
@@ -48,7 +67,7 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
                 LEFT  JOIN "AO_2AD648_INSIGHT_REPORT" insrep on pr.from_hash = insrep."COMMIT_ID"
                 and pr.to_repository_id = insrep."REPOSITORY_ID"
             WHERE
-                project.project_key not like '~%' AND (pr.closed_timestamp >= (date_trunc('minute', $1) - INTERVAL '1 minute')
+                %PROJECT_FILTER% AND (pr.closed_timestamp >= (date_trunc('minute', $1) - INTERVAL '1 minute')
             AND pr.closed_timestamp < date_trunc('minute', $1))
             GROUP BY
                 stash_repo,
@@ -62,7 +81,7 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
 			SELECT
                 repo.slug AS stash_repo,
                 pr.scoped_id AS prno,
-                string_agg (DISTINCT insrep."REPORT_KEY", ',') FILTER (WHERE insrep."REPORT_KEY" in ( 'sast','sabug','savul','sasmell','smoke','ci','codecoverage','snyk' )) AS present_reports,
+                string_agg (DISTINCT insrep."REPORT_KEY", ',') FILTER (WHERE insrep."REPORT_KEY" in (%REPORT_KEYS%)) AS present_reports,
                 project.project_key AS "project",
                 '[' || string_agg(DISTINCT nullif(insrep."DATA",''),',') || ']' AS report_data
             FROM
@@ -72,7 +91,7 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
                 LEFT  JOIN "AO_2AD648_INSIGHT_REPORT" insrep on pr.from_hash = insrep."COMMIT_ID"
                 and pr.to_repository_id = insrep."REPOSITORY_ID"
             WHERE
-                pr.pr_state = 0 AND project.project_key not like '~%'
+                pr.pr_state = 0 AND %PROJECT_FILTER%
             GROUP BY
                 stash_repo,
                 prno,
@@ -81,29 +100,44 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
                 stash_repo,
                 prno,
                 project.project_key`,
-		"result_report_query(text)": `
-			WITH prbase AS(
+		"result_report_query(text[])": `
+			-- 一次查询统计 $1 中所有报告类型：open pr 每种报告的每种结果各计一次，
+			-- 仓库要求该报告但 pr 没有提供时计入 status 2（notAvailable）
+			WITH openpr AS (
                 SELECT
-				    pr.id AS pr_id,
-                    CASE WHEN insrep."REPORT_KEY" = $1 THEN insrep."REPORT_KEY" ELSE null END AS report,
-					string_agg(CASE WHEN insrep."REPORT_KEY" = $1 THEN insrep."REPORT_KEY" ELSE null END,',') OVER prw AS allrepts,
-                    insrep."RESULT_ID" AS status,
-                    project.project_key AS project_key
+                    pr.id AS pr_id,
+                    pr.from_hash,
+                    pr.to_repository_id AS repo_id,
+                    project.project_key
                 FROM
                     sta_pull_request pr
                     INNER JOIN repository repo ON pr.to_repository_id = repo.id
                     INNER JOIN project ON repo.project_id = project.id
-                    LEFT JOIN "AO_2AD648_INSIGHT_REPORT" insrep ON pr.from_hash = insrep."COMMIT_ID"
-                    AND pr.to_repository_id = insrep."REPOSITORY_ID"
                 WHERE
-                    pr.pr_state = 0 AND project.project_key NOT LIKE '~%'
-                    AND ( insrep."REPORT_KEY" = $1 OR repo.id = ANY ( SELECT "RESOURCE_ID" FROM "AO_2AD648_MERGE_CHECK" WHERE "RESOURCE_ID" = repo.id AND "REPORT_KEY" = $1 ) )
-                GROUP BY
-                    project.project_key,
-                    pr.id,
-                    report,
-                    status
-				WINDOW prw AS (PARTITION BY pr.id)
+                    pr.pr_state = 0 AND %PROJECT_FILTER%
+			),
+			present AS (
+                SELECT DISTINCT
+                    openpr.pr_id,
+                    openpr.project_key,
+                    insrep."REPORT_KEY" AS report,
+                    insrep."RESULT_ID" AS status
+                FROM
+                    openpr
+                    INNER JOIN "AO_2AD648_INSIGHT_REPORT" insrep ON openpr.from_hash = insrep."COMMIT_ID"
+                    AND openpr.repo_id = insrep."REPOSITORY_ID"
+                WHERE
+                    insrep."REPORT_KEY" = ANY ($1)
+			),
+			required AS (
+                SELECT DISTINCT
+                    "RESOURCE_ID" AS repo_id,
+                    "REPORT_KEY" AS report
+                FROM
+                    "AO_2AD648_MERGE_CHECK"
+                WHERE
+                    "SCOPE_TYPE" = 'REPOSITORY'
+                    AND "REPORT_KEY" = ANY ($1)
 			)
             SELECT
                     report,
@@ -111,25 +145,25 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
                     project_key AS project,
                     COUNT(*) AS cnt
                 FROM
-				    prbase
-                WHERE
-                    report = $1
+                    present
                 GROUP BY
+                    report,
                     status,
-					report,
                     project_key
-			UNION
+			UNION ALL
 				SELECT
-                    $1 AS report,
+                    required.report,
                     2 AS status,
-                    project_key AS project,
+                    openpr.project_key AS project,
                     COUNT(*) AS cnt
                 FROM
-				    prbase
-				WHERE
-				    allrepts IS NULL
+                    openpr
+                    INNER JOIN required ON openpr.repo_id = required.repo_id
+                WHERE
+                    NOT EXISTS ( SELECT 1 FROM present WHERE present.pr_id = openpr.pr_id AND present.report = required.report )
                 GROUP BY
-                    project_key`,
+                    required.report,
+                    openpr.project_key`,
 		"pr_counts_query(timestamp)": `
 			SELECT
                 pr_state,
@@ -139,7 +173,7 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
                 sta_pull_request AS pr
                 INNER JOIN repository AS repo ON pr.to_repository_id = repo.id
                 INNER JOIN project AS project ON repo."project_id" = project.id
-            WHERE project.project_key not like '~%'
+            WHERE %PROJECT_FILTER%
                 AND ( pr.closed_timestamp is null OR (pr.closed_timestamp >= ($1 - interval '1 minute') and pr.closed_timestamp < $1 ) )
             GROUP BY
                 pr_state,
@@ -158,6 +192,7 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
             FROM
                 "AO_2AD648_INSIGHT_REPORT" AS insrep
                 INNER JOIN sta_pull_request AS pr ON insrep."COMMIT_ID" = pr.from_hash
+                AND insrep."REPOSITORY_ID" = pr.to_repository_id
                 INNER JOIN repository AS repo ON pr.to_repository_id = repo.id
                 INNER JOIN project AS project ON repo.project_id = project.id
                 INNER JOIN (
@@ -168,12 +203,12 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
                         "AO_2AD648_MERGE_CHECK" AS merge_check
                     WHERE
                         "SCOPE_TYPE" = 'REPOSITORY'
-                        AND "REPORT_KEY" IN ('sast','sabug','savul','sasmell','smoke','ci','codecoverage','snyk')
+                        AND "REPORT_KEY" IN (%REPORT_KEYS%)
                     GROUP BY
                         merge_check."RESOURCE_ID"
                 ) AS repreq ON repo.id = repreq.repo_id
             WHERE
-                project.project_key NOT LIKE '~%'
+                %PROJECT_FILTER%
                 AND ( pr.closed_timestamp is NULL OR (pr.closed_timestamp >= ($1 - interval '1 minute') and pr.closed_timestamp < $1 ) )
             GROUP BY
                 pr.id,
@@ -183,60 +218,182 @@ func ParseSqlDict(s string) (sqlNameAndParam, sqlText string) {
                 repreq.req_arr
             HAVING NOT (repreq.req_arr <@ array_agg( DISTINCT
 				CASE
-					WHEN insrep."REPORT_KEY" in ('sast','sabug','savul','sasmell','smoke','ci','codecoverage','snyk') THEN insrep."REPORT_KEY"
+					WHEN insrep."REPORT_KEY" in (%REPORT_KEYS%) THEN insrep."REPORT_KEY"
 					ELSE 'other'::VARCHAR
 				END ))
         ) AS partquery
         GROUP BY
           project_key,
           pr_state
-        UNION
+        -- 两部分的 pr 互不重叠，使用 UNION ALL，避免数量相同的两行被去重后少计
+        UNION ALL
         -- part 2: count pr(s) without any report
         SELECT count(pr.id) AS cnt, pr.pr_state, project.project_key
         FROM
           "AO_2AD648_INSIGHT_REPORT" AS insrep
           RIGHT JOIN sta_pull_request AS pr ON insrep."COMMIT_ID" = pr.from_hash
+          AND insrep."REPOSITORY_ID" = pr.to_repository_id
           INNER JOIN repository AS repo ON pr.to_repository_id = repo.id
           INNER JOIN project AS project ON repo.project_id = project.id
         WHERE
-          insrep."REPORT_KEY" is null
+          %PROJECT_FILTER%
+          AND insrep."REPORT_KEY" is null
           AND repo.id = ANY ( select "RESOURCE_ID"
                 from "AO_2AD648_MERGE_CHECK"
                 where "RESOURCE_ID" = repo.id
-                AND "REPORT_KEY" in ('sast','sabug','savul','sasmell','smoke','ci','codecoverage','snyk') )
+                AND "SCOPE_TYPE" = 'REPOSITORY'
+                AND "REPORT_KEY" in (%REPORT_KEYS%) )
           AND ( pr.closed_timestamp is null OR (pr.closed_timestamp >= ($1 - interval '1 minute') and pr.closed_timestamp < $1 ) )
         GROUP BY project.project_key,pr.pr_state
         ) AS uniontable
         GROUP BY project_key,pr_state`,
+		"pr_compliance_query(timestamp)": `
+			WITH repreq AS (
+                SELECT
+                    merge_check."RESOURCE_ID" AS repo_id,
+                    string_agg(DISTINCT merge_check."REPORT_KEY", ',') AS required_reports
+                FROM
+                    "AO_2AD648_MERGE_CHECK" AS merge_check
+                WHERE
+                    merge_check."SCOPE_TYPE" = 'REPOSITORY'
+                    AND merge_check."REPORT_KEY" IN (%REPORT_KEYS%)
+                GROUP BY
+                    merge_check."RESOURCE_ID"
+			)
+            SELECT
+                pr.pr_state,
+                project.project_key AS project,
+                repreq.required_reports,
+                string_agg(DISTINCT insrep."REPORT_KEY", ',') FILTER (WHERE insrep."REPORT_KEY" IN (%REPORT_KEYS%)) AS present_reports
+            FROM
+                sta_pull_request AS pr
+                INNER JOIN repository AS repo ON pr.to_repository_id = repo.id
+                INNER JOIN project AS project ON repo.project_id = project.id
+                LEFT JOIN repreq ON repo.id = repreq.repo_id
+                LEFT JOIN "AO_2AD648_INSIGHT_REPORT" AS insrep ON pr.from_hash = insrep."COMMIT_ID"
+                AND pr.to_repository_id = insrep."REPOSITORY_ID"
+            WHERE
+                %PROJECT_FILTER%
+                AND ( pr.closed_timestamp is null OR (pr.closed_timestamp >= ($1 - interval '1 minute') and pr.closed_timestamp < $1 ) )
+            GROUP BY
+                pr.id,
+                pr.pr_state,
+                project.project_key,
+                repreq.required_reports`,
+		"pr_detail_query(timestamp)": `
+			WITH repreq AS (
+                SELECT
+                    merge_check."RESOURCE_ID" AS repo_id,
+                    string_agg(DISTINCT merge_check."REPORT_KEY", ',') AS required_reports
+                FROM
+                    "AO_2AD648_MERGE_CHECK" AS merge_check
+                WHERE
+                    merge_check."SCOPE_TYPE" = 'REPOSITORY'
+                    AND merge_check."REPORT_KEY" IN (%REPORT_KEYS%)
+                GROUP BY
+                    merge_check."RESOURCE_ID"
+			)
+            SELECT
+                project.project_key AS project,
+                repo.slug AS stash_repo,
+                pr.scoped_id AS prno,
+                pr.pr_state,
+                repreq.required_reports,
+                string_agg(DISTINCT insrep."REPORT_KEY" || ':' || COALESCE(insrep."RESULT_ID"::text, ''), ',') FILTER (WHERE insrep."REPORT_KEY" IN (%REPORT_KEYS%)) AS report_results,
+                '[' || string_agg(DISTINCT nullif(insrep."DATA",''),',') || ']' AS report_data
+            FROM
+                sta_pull_request AS pr
+                INNER JOIN repository AS repo ON pr.to_repository_id = repo.id
+                INNER JOIN project AS project ON repo.project_id = project.id
+                LEFT JOIN repreq ON repo.id = repreq.repo_id
+                LEFT JOIN "AO_2AD648_INSIGHT_REPORT" AS insrep ON pr.from_hash = insrep."COMMIT_ID"
+                AND pr.to_repository_id = insrep."REPOSITORY_ID"
+            WHERE
+                %PROJECT_FILTER%
+                AND ( pr.closed_timestamp is null OR (pr.closed_timestamp >= ($1 - interval '1 minute') and pr.closed_timestamp < $1 ) )
+            GROUP BY
+                project.project_key,
+                repo.slug,
+                pr.id,
+                pr.scoped_id,
+                pr.pr_state,
+                repreq.required_reports
+            ORDER BY
+                project.project_key,
+                repo.slug,
+                pr.scoped_id`,
 	}
 
+	// 查询文本中的 %REPORT_KEYS% 和 %PROJECT_FILTER% 在 PrepareStatement 中按配置替换
 	sqlNameAndParam = sqlNameMap[s]
 	sqlText = regSQLs[sqlNameAndParam]
 	return sqlNameAndParam, sqlText
 }
 
+// 查询中的报告类型和项目过滤条件来自配置，配置变化后 prepare 的语句也随之变化
+func sqlPlaceholders(cfg *config.ServerConfig) *strings.Replacer {
+	return strings.NewReplacer(
+		"%REPORT_KEYS%", quoteList(cfg.ReportKeys),
+		"%PROJECT_FILTER%", ProjectFilter(cfg.Projects, "project.project_key"),
+	)
+}
+
+// ProjectFilter 返回项目过滤的 SQL 条件，column 为项目 key 所在的列
+func ProjectFilter(projects config.ProjectsConfig, column string) string {
+	cond := column + " NOT LIKE '~%'"
+	if len(projects.Include) > 0 {
+		cond += " AND " + column + " IN (" + quoteList(projects.Include) + ")"
+	}
+	if len(projects.Exclude) > 0 {
+		cond += " AND " + column + " NOT IN (" + quoteList(projects.Exclude) + ")"
+	}
+	return cond
+}
+
+// TextArray 转换为 text[] 类型的 SQL 常量，例如 '{"ci","sast"}'，用于 EXECUTE 数组类型的参数
+func TextArray(values []string) string {
+	if len(values) == 0 {
+		return "'{}'"
+	}
+	v, _ := pq.StringArray(values).Value()
+	return pq.QuoteLiteral(v.(string))
+}
+
+// 转换为 SQL 字符串常量列表，例如 'ci','sast'
+func quoteList(values []string) string {
+	if len(values) == 0 {
+		// IN () 不是合法的 SQL，用 NULL 表示不匹配任何值
+		return "NULL"
+	}
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = pq.QuoteLiteral(v)
+	}
+	return strings.Join(quoted, ",")
+}
+
 // 带重连机制的RegisterPreparedSQLs
-func RegisterPreparedSQLsWithRetry(ctx context.Context, queryKey string, conn *sql.Conn, isInit bool) error {
+func RegisterPreparedSQLsWithRetry(ctx context.Context, cfg *config.ServerConfig, queryKey string, conn *sql.Conn, isInit bool) error {
 	const maxRetries = 3
 	var err error
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		err = RegisterPreparedSQLs(ctx, queryKey, conn, isInit)
+		err = RegisterPreparedSQLs(ctx, cfg, queryKey, conn, isInit)
 		if err == nil {
 			return nil
 		}
 
-		log.Printf("RegisterPreparedSQLs 失败 [%s]（第 %d 次尝试）: %v", queryKey, attempt, err)
+		zap.S().Warnf("RegisterPreparedSQLs 失败 [%s]（第 %d 次尝试）: %v", queryKey, attempt, err)
 		time.Sleep(time.Duration(attempt) * time.Second) // 指数退避策略可选
 	}
 
 	return fmt.Errorf("RegisterPreparedSQLs 最终失败 [%s]: %w", queryKey, err)
 }
 
-// 注册pgsql语句
-func RegisterPreparedSQLs(ctx context.Context, sqlName string, db *sql.Conn, firstCall bool) error {
-	proto, sqlText := ParseSqlDict(sqlName)
-	name, param := parseSQLName(proto) // 把sql名称和参数拆分开来
+// 注册pgsql语句，语句中的报告类型和项目过滤条件来自 cfg
+func RegisterPreparedSQLs(ctx context.Context, cfg *config.ServerConfig, sqlName string, db *sql.Conn, firstCall bool) error {
+	proto, _ := ParseSqlDict(sqlName)
+	name, _ := parseSQLName(proto) // 把sql名称和参数拆分开来
 
 	if !firstCall {
 		// 如果不是首次执行sql，sql已经注册过prepare，这时需要先释放prepare过的sql，重新进行prepare
@@ -247,15 +404,29 @@ func RegisterPreparedSQLs(ctx context.Context, sqlName string, db *sql.Conn, fir
 	}
 
 	// 拼接完整的pgsql prepare语句
-	prepareStmt := "PREPARE " + name + param + " AS " + sqlText
-	_, err := db.ExecContext(ctx, prepareStmt)
+	_, err := db.ExecContext(ctx, PrepareStatement(cfg, sqlName))
 	if err != nil {
 		zap.S().Errorf("preparing SQL 时出错 %s: %v", name, err)
+		return err
 	}
 
 	return nil
 }
 
+// PrepareStatement 返回查询在配置 cfg 下的完整 PREPARE 语句
+func PrepareStatement(cfg *config.ServerConfig, sqlName string) string {
+	_, sqlText := ParseSqlDict(sqlName)
+	return PrepareStatementWith(cfg, sqlName, sqlText)
+}
+
+// PrepareStatementWith 用 sqlText 代替注册的查询文本，名称和参数类型不变，采集任务的 EXECUTE 语句不需要修改。
+// sqlText 中同样可以使用 %REPORT_KEYS% 和 %PROJECT_FILTER%，用于比较查询的不同版本
+func PrepareStatementWith(cfg *config.ServerConfig, sqlName, sqlText string) string {
+	proto, _ := ParseSqlDict(sqlName)
+	name, param := parseSQLName(proto)
+	return "PREPARE " + name + param + " AS " + sqlPlaceholders(cfg).Replace(sqlText)
+}
+
 // 解析sql名称和参数
 func parseSQLName(proto string) (name string, param string) {
 	idx := strings.Index(proto, "(")
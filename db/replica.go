@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"strings"
+)
+
+// 连接所在服务器的状态：是否为备库、是否只读以及备库的复制延迟。已经回放完收到的全部 WAL 时延迟为 0，
+// 避免主库没有写入时 pg_last_xact_replay_timestamp() 停留在最后一次事务而被误认为延迟很大
+const sessionStateQuery = `
+    SELECT
+        pg_is_in_recovery(),
+        current_setting('transaction_read_only') = 'on',
+        CASE
+            WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+            ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+        END`
+
+// SessionState 一个连接所在服务器的状态
+type SessionState struct {
+	InRecovery bool
+	ReadOnly   bool
+	Lag        float64 // 备库的复制延迟（秒），主库为 0
+}
+
+// Matches 服务器是否满足 target_session_attrs。any 和 prefer-standby 时任何服务器都满足
+func (s SessionState) Matches(attrs string) bool {
+	switch attrs {
+	case "any", "prefer-standby":
+		return true
+	case "read-write":
+		return !s.ReadOnly
+	case "read-only":
+		return s.ReadOnly
+	case "primary":
+		return !s.InRecovery
+	case "standby":
+		return s.InRecovery
+	}
+	return false
+}
+
+// ReadSessionState 查询 q 所在服务器的状态。连接池中的连接可能分布在不同的服务器上，
+// q 应为随后执行查询的同一个连接
+func ReadSessionState(ctx context.Context, q Querier) (SessionState, error) {
+	var s SessionState
+	rows, err := q.QueryContext(ctx, sessionStateQuery)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return s, err
+		}
+		return s, errors.New("查询服务器状态没有返回结果")
+	}
+	if err := rows.Scan(&s.InRecovery, &s.ReadOnly, &s.Lag); err != nil {
+		return s, err
+	}
+	return s, rows.Err()
+}
+
+// DiscardConn 关闭连接并把底层连接从连接池中移除。sql.Conn.Close 会把连接放回连接池，
+// 连接所在的服务器不再满足 target_session_attrs 时之后仍可能被取出
+func DiscardConn(conn *sql.Conn) {
+	_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+type hostConnector struct {
+	addr      string // 用于日志的 host:port
+	connector driver.Connector
+}
+
+// failoverConnector 按配置顺序尝试多个 host，返回第一个满足 target_session_attrs 的连接。
+// 只在新建连接时选择服务器，已经建立的连接不会切换：采集任务的连接在每轮采集前检查服务器状态，
+// 主备切换后不再满足条件的连接由采集任务关闭并重新建立
+type failoverConnector struct {
+	name  string
+	attrs string
+	hosts []hostConnector
+}
+
+func (c *failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	want := c.attrs
+	if want == "prefer-standby" {
+		want = "standby"
+	}
+
+	var errs []string
+	// prefer-standby 时保留第一个可用的非备库连接，没有可用的备库时使用
+	var fallback driver.Conn
+	var fallbackAddr string
+	for _, h := range c.hosts {
+		conn, err := h.connector.Connect(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", h.addr, err))
+			continue
+		}
+		ok, err := matchSessionAttrs(ctx, conn, want)
+		if err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Sprintf("%s: %v", h.addr, err))
+			continue
+		}
+		if ok {
+			if fallback != nil {
+				fallback.Close()
+			}
+			zap.S().Debugf("[%s] 新建连接到 %s", c.name, h.addr)
+			return conn, nil
+		}
+		if c.attrs == "prefer-standby" && fallback == nil {
+			fallback, fallbackAddr = conn, h.addr
+			continue
+		}
+		conn.Close()
+		errs = append(errs, h.addr+": 不满足条件")
+	}
+	if fallback != nil {
+		zap.S().Warnf("[%s] 没有可用的备库，新建连接到 %s", c.name, fallbackAddr)
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("没有满足 target_session_attrs=%s 的服务器: %s", c.attrs, strings.Join(errs, "; "))
+}
+
+func (c *failoverConnector) Driver() driver.Driver {
+	return c.hosts[0].connector.Driver()
+}
+
+func matchSessionAttrs(ctx context.Context, conn driver.Conn, attrs string) (bool, error) {
+	if attrs == "any" {
+		return true, nil
+	}
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return false, errors.New("驱动不支持查询服务器状态")
+	}
+	rows, err := queryer.QueryContext(ctx, sessionStateQuery, nil)
+	if err != nil {
+		return false, fmt.Errorf("查询服务器状态失败: %w", err)
+	}
+	defer rows.Close()
+	values := make([]driver.Value, 3)
+	if err := rows.Next(values); err != nil {
+		if err == io.EOF {
+			err = errors.New("没有返回结果")
+		}
+		return false, fmt.Errorf("查询服务器状态失败: %w", err)
+	}
+	var s SessionState
+	s.InRecovery, _ = values[0].(bool)
+	s.ReadOnly, _ = values[1].(bool)
+	return s.Matches(attrs), nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import "oneclick-metrics-go/config"
+
+// engine: unified 时每轮采集只执行以下两个查询，不需要 prepare，以 /* 名称 */ 开头便于录制和回放识别
+const (
+	TickPRsQueryName    = "tick_prs_query"
+	TickChecksQueryName = "tick_checks_query"
+)
+
+// TickPRsQuery 返回 open、未关闭以及最近一分钟关闭的 pr 及其所有 insight 报告，每个 pr 的每个报告一行，
+// 没有报告的 pr 报告列为空。$1 为时区，时间窗口与 pr_counts_query 等查询相同。
+// 结果按项目、仓库和 pr 编号排序，同一个 pr 的行相邻。项目过滤条件来自 cfg
+func TickPRsQuery(cfg *config.ServerConfig) string {
+	return `/* ` + TickPRsQueryName + ` */
+            WITH tick AS (
+                SELECT date_trunc('minute', current_timestamp AT TIME ZONE $1) AS now
+            ),
+            pr AS (
+                SELECT
+                    pr.id,
+                    pr.from_hash,
+                    pr.to_repository_id,
+                    project.project_key,
+                    repo.slug,
+                    pr.scoped_id,
+                    pr.pr_state,
+                    pr.closed_timestamp >= tick.now - interval '1 minute' AND pr.closed_timestamp < tick.now AS closed_recently,
+                    pr.closed_timestamp IS NULL AS unclosed
+                FROM
+                    sta_pull_request pr
+                    INNER JOIN repository repo ON pr.to_repository_id = repo.id
+                    INNER JOIN project ON repo.project_id = project.id
+                    CROSS JOIN tick
+                WHERE
+                    ` + ProjectFilter(cfg.Projects, "project.project_key") + `
+                    AND ( pr.pr_state = 0 OR pr.closed_timestamp IS NULL
+                        OR (pr.closed_timestamp >= tick.now - interval '1 minute' AND pr.closed_timestamp < tick.now) )
+            )
+            SELECT
+                pr.id,
+                pr.project_key,
+                pr.to_repository_id,
+                pr.slug,
+                pr.scoped_id,
+                pr.pr_state,
+                COALESCE(pr.closed_recently, false),
+                pr.unclosed,
+                insrep."REPORT_KEY",
+                insrep."RESULT_ID",
+                insrep."DATA"
+            FROM
+                pr
+                LEFT JOIN "AO_2AD648_INSIGHT_REPORT" insrep ON pr.from_hash = insrep."COMMIT_ID"
+                AND pr.to_repository_id = insrep."REPOSITORY_ID"
+            ORDER BY
+                pr.project_key,
+                pr.slug,
+                pr.scoped_id,
+                pr.id`
+}
+
+// TickChecksQuery 返回所有仓库及其仓库级 merge check 要求的报告，没有 merge check 的仓库报告列为空。
+// 结果按项目和仓库排序，与 check_summary_query 相同
+func TickChecksQuery(cfg *config.ServerConfig) string {
+	return `/* ` + TickChecksQueryName + ` */
+            SELECT
+                repo.id,
+                project.project_key,
+                repo.slug,
+                merge_check."REPORT_KEY"
+            FROM
+                repository repo
+                INNER JOIN project ON repo.project_id = project.id
+                LEFT JOIN "AO_2AD648_MERGE_CHECK" merge_check ON merge_check."RESOURCE_ID" = repo.id
+                AND merge_check."SCOPE_TYPE" = 'REPOSITORY'
+            WHERE
+                ` + ProjectFilter(cfg.Projects, "project.project_key") + `
+            ORDER BY
+                project.project_key,
+                repo.slug`
+}
@@ -0,0 +1,302 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"oneclick-metrics-go/metrics"
+	"strings"
+)
+
+// GrafanaOptions dashboard 生成参数
+type GrafanaOptions struct {
+	Title string
+	UID   string
+}
+
+// grafana 面板的栅格宽度
+const gridWidth = 24
+
+var datasourceRef = map[string]interface{}{"type": "prometheus", "uid": "${datasource}"}
+
+// dashboard 构造器，负责按顺序摆放 row 和 panel
+type dashboardBuilder struct {
+	panels []map[string]interface{}
+	nextID int
+	y      int // 当前行的纵坐标
+	x      int // 当前行已占用的宽度
+	rowH   int // 当前行的最大高度
+}
+
+func (b *dashboardBuilder) id() int {
+	b.nextID++
+	return b.nextID
+}
+
+func (b *dashboardBuilder) row(title string) {
+	b.newLine()
+	b.panels = append(b.panels, map[string]interface{}{
+		"id":        b.id(),
+		"type":      "row",
+		"title":     title,
+		"collapsed": false,
+		"gridPos":   map[string]int{"h": 1, "w": gridWidth, "x": 0, "y": b.y},
+		"panels":    []interface{}{},
+	})
+	b.y++
+}
+
+func (b *dashboardBuilder) newLine() {
+	if b.x > 0 {
+		b.y += b.rowH
+		b.x, b.rowH = 0, 0
+	}
+}
+
+func (b *dashboardBuilder) add(panel map[string]interface{}, w, h int) {
+	if b.x+w > gridWidth {
+		b.newLine()
+	}
+	panel["id"] = b.id()
+	panel["datasource"] = datasourceRef
+	panel["gridPos"] = map[string]int{"h": h, "w": w, "x": b.x, "y": b.y}
+	b.panels = append(b.panels, panel)
+	b.x += w
+	if h > b.rowH {
+		b.rowH = h
+	}
+}
+
+func target(expr, legend string) map[string]interface{} {
+	return map[string]interface{}{
+		"datasource":   datasourceRef,
+		"expr":         expr,
+		"legendFormat": legend,
+		"refId":        "A",
+	}
+}
+
+func instantTableTarget(expr string) map[string]interface{} {
+	t := target(expr, "")
+	t["instant"] = true
+	t["range"] = false
+	t["format"] = "table"
+	return t
+}
+
+func panel(kind, title, description string, targets ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        kind,
+		"title":       title,
+		"description": description,
+		"targets":     targets,
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{}, "overrides": []interface{}{}},
+		"options":     map[string]interface{}{},
+	}
+}
+
+func withUnit(p map[string]interface{}, unit string, min, max interface{}) map[string]interface{} {
+	defaults := p["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})
+	defaults["unit"] = unit
+	if min != nil {
+		defaults["min"] = min
+	}
+	if max != nil {
+		defaults["max"] = max
+	}
+	return p
+}
+
+// 隐藏表格中的指定列，例如 Time、Value 与 __name__
+func hideTableColumns(p map[string]interface{}, columns ...string) map[string]interface{} {
+	exclude := map[string]bool{}
+	for _, c := range columns {
+		exclude[c] = true
+	}
+	p["transformations"] = []interface{}{
+		map[string]interface{}{
+			"id":      "organize",
+			"options": map[string]interface{}{"excludeByName": exclude},
+		},
+	}
+	return p
+}
+
+// 只有定义里包含对应标签时才加上过滤条件，避免生成引用不存在标签的查询
+func selector(def metrics.MetricDef, matchers ...string) string {
+	var kept []string
+	for _, m := range matchers {
+		name := m[:strings.IndexAny(m, "=!")]
+		if hasLabel(def, name) {
+			kept = append(kept, m)
+		}
+	}
+	return def.Name + "{" + strings.Join(kept, ",") + "}"
+}
+
+func hasLabel(def metrics.MetricDef, label string) bool {
+	for _, l := range def.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// GrafanaDashboard 根据指标定义和采集任务生成 dashboard 的 JSON 模型
+func GrafanaDashboard(opts GrafanaOptions, defs []metrics.MetricDef, collectors []string) map[string]interface{} {
+	b := &dashboardBuilder{}
+	used := map[string]bool{}
+	def := func(d metrics.MetricDef) (metrics.MetricDef, bool) {
+		for _, candidate := range defs {
+			if candidate.Name == d.Name {
+				used[d.Name] = true
+				return candidate, true
+			}
+		}
+		return d, false
+	}
+
+	// 缺失报告
+	b.row("Missing reports")
+	if d, ok := def(metrics.PRMissingReportDef); ok {
+		b.add(panel("timeseries", "Open PRs missing reports", d.Help,
+			target("sum by (project) ("+selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`, `pr_state="open"`)+")", "{{project}}")), 12, 8)
+		b.add(panel("timeseries", "Closed PRs missing reports (last minute)", d.Help,
+			target("sum by (pr_state) ("+selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`, `pr_state!="open"`)+")", "{{pr_state}}")), 12, 8)
+	}
+	if d, ok := def(metrics.PRNumDef); ok {
+		b.add(panel("timeseries", "Open PRs", d.Help,
+			target("sum by (project) ("+selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`, `pr_state="open"`)+")", "{{project}}")), 12, 8)
+	}
+	if d, ok := def(metrics.ComplianceRatioDef); ok {
+		b.add(withUnit(panel("stat", "Open PR compliance", d.Help,
+			target(selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`, `pr_state="open"`, `report="all"`), "{{project}}")), "percentunit", 0, 1), 12, 8)
+		b.add(withUnit(panel("bargauge", "Open PR compliance by report", d.Help,
+			target("min by (report) ("+selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`, `pr_state="open"`, `report=~"$report"`)+")", "{{report}}")), "percentunit", 0, 1), 24, 8)
+	}
+
+	// 报告结果
+	b.row("Result reports")
+	if d, ok := def(metrics.ResultReportDef); ok {
+		b.add(panel("bargauge", "Report results", d.Help,
+			target("sum by (report, status) ("+selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`, `report=~"$report"`, `status=~"success|failure|notAvailable"`)+")", "{{report}} {{status}}")), 12, 10)
+		b.add(panel("timeseries", "Failing reports", d.Help,
+			target("sum by (project, report) ("+selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`, `report=~"$report"`, `status="failure"`)+")", "{{project}} {{report}}")), 12, 10)
+	}
+	if d, ok := def(metrics.OpenPRReportDef); ok {
+		b.add(hideTableColumns(panel("table", "Open PR reports", d.Help,
+			instantTableTarget(selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`))), "Time", "Value", "__name__", "job"), 24, 10)
+	}
+	if d, ok := def(metrics.ClosedPRReportDef); ok {
+		b.add(hideTableColumns(panel("table", "PRs closed in the last minute", d.Help,
+			instantTableTarget(selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`))), "Time", "Value", "__name__", "job"), 24, 8)
+	}
+
+	// merge check 配置
+	b.row("Check summary")
+	if d, ok := def(metrics.CheckSummaryDef); ok {
+		b.add(panel("stat", "Repos without merge checks", d.Help,
+			target("count by (project) ("+selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`, `valid_appearance="0"`)+")", "{{project}}")), 8, 8)
+		b.add(hideTableColumns(panel("table", "Enabled checks by repo", d.Help,
+			instantTableTarget(selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`))), "Time", "Value", "__name__", "job"), 16, 8)
+	}
+
+	// exporter 运行状态
+	b.row("Exporter health")
+	if d, ok := def(metrics.CollectorUpDef); ok {
+		b.add(withUnit(panel("stat", "Collector up", d.Help,
+			target(selector(d, `bitbucket_instance=~"$instance"`, `collector=~"$collector"`), "{{bitbucket_instance}} {{collector}}")), "bool_on_off", nil, nil), 8, 8)
+	}
+	if d, ok := def(metrics.CollectorDurationDef); ok {
+		b.add(withUnit(panel("timeseries", "Collector duration", d.Help,
+			target(selector(d, `bitbucket_instance=~"$instance"`, `collector=~"$collector"`), "{{bitbucket_instance}} {{collector}}")), "s", nil, nil), 8, 8)
+	}
+	if d, ok := def(metrics.CollectorLastSuccessDef); ok {
+		b.add(withUnit(panel("timeseries", "Data age", "Seconds since the last successful run of the collector",
+			target("time() - "+selector(d, `bitbucket_instance=~"$instance"`, `collector=~"$collector"`), "{{bitbucket_instance}} {{collector}}")), "s", nil, nil), 8, 8)
+	}
+
+	// 其余没有标准面板的指标统一生成一个时间序列面板
+	first := true
+	for _, d := range defs {
+		if used[d.Name] {
+			continue
+		}
+		if first {
+			b.row("Other metrics")
+			first = false
+		}
+		expr := selector(d, `bitbucket_instance=~"$instance"`, `project=~"$project"`)
+		if hasLabel(d, "project") {
+			expr = "sum by (project) (" + expr + ")"
+		}
+		b.add(panel("timeseries", d.Name, d.Help, target(expr, "")), 12, 8)
+	}
+
+	return map[string]interface{}{
+		"title":         opts.Title,
+		"uid":           opts.UID,
+		"tags":          []string{"oneclick"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"editable":      true,
+		"panels":        b.panels,
+		"templating": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				queryVariable("instance", "Instance", fmt.Sprintf("label_values(%s, %s)", metrics.PRNumDef.Name, metrics.InstanceLabel)),
+				queryVariable("project", "Project", fmt.Sprintf(`label_values(%s{%s=~"$instance"}, project)`, metrics.PRNumDef.Name, metrics.InstanceLabel)),
+				queryVariable("report", "Report", fmt.Sprintf("label_values(%s, report)", metrics.ResultReportDef.Name)),
+				customVariable("collector", "Collector", collectors),
+			},
+		},
+	}
+}
+
+func queryVariable(name, label, query string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       name,
+		"label":      label,
+		"type":       "query",
+		"datasource": datasourceRef,
+		"query":      map[string]string{"query": query, "refId": name},
+		"definition": query,
+		"refresh":    2,
+		"sort":       1,
+		"multi":      true,
+		"includeAll": true,
+		"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+	}
+}
+
+func customVariable(name, label string, values []string) map[string]interface{} {
+	options := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		options = append(options, map[string]interface{}{"text": v, "value": v, "selected": false})
+	}
+	return map[string]interface{}{
+		"name":       name,
+		"label":      label,
+		"type":       "custom",
+		"query":      strings.Join(values, ","),
+		"options":    options,
+		"multi":      true,
+		"includeAll": true,
+		"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+	}
+}
+
+// WriteGrafanaDashboard 生成 dashboard 并以缩进 JSON 写出
+func WriteGrafanaDashboard(w io.Writer, opts GrafanaOptions, defs []metrics.MetricDef, collectors []string) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(GrafanaDashboard(opts, defs, collectors))
+}
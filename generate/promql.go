@@ -0,0 +1,152 @@
+package generate
+
+import (
+	"fmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"oneclick-metrics-go/metrics"
+	"sort"
+)
+
+// Prometheus 会给每个抓取目标附加的标签
+var targetLabels = []string{"job", "instance"}
+
+// PromQLValidator 校验生成的 PromQL 表达式：语法是否正确、引用的指标是否由 exporter 注册、
+// 标签匹配、by/without 和 on/ignoring 中的标签是否属于对应指标，用于防止规则与 metrics.go 中的定义脱节
+type PromQLValidator struct {
+	labels map[string]map[string]bool // 指标名称 -> 标签集合
+}
+
+// NewPromQLValidator 根据指标定义创建校验器，up 指标总是可用
+func NewPromQLValidator(defs []metrics.MetricDef) *PromQLValidator {
+	v := &PromQLValidator{labels: map[string]map[string]bool{}}
+	v.AddMetric("up")
+	for _, def := range defs {
+		v.AddMetric(def.Name, def.Labels...)
+	}
+	return v
+}
+
+// AddMetric 添加一个可引用的指标，例如记录规则产生的新指标
+func (v *PromQLValidator) AddMetric(name string, labels ...string) {
+	set := map[string]bool{}
+	for _, l := range append(labels, targetLabels...) {
+		set[l] = true
+	}
+	v.labels[name] = set
+}
+
+// Validate 校验单个表达式，返回发现的全部问题。语法错误（括号不配对、未知函数等）由 Prometheus 的解析器报告
+func (v *PromQLValidator) Validate(expr string) []error {
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	parser.Inspect(node, func(n parser.Node, _ []parser.Node) error {
+		switch n := n.(type) {
+		case *parser.VectorSelector:
+			name := selectorName(n)
+			known, ok := v.labels[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("指标 %s 不是由 exporter 注册的", name))
+				return nil
+			}
+			for _, m := range n.LabelMatchers {
+				if m.Name != labels.MetricName && !known[m.Name] {
+					errs = append(errs, fmt.Errorf("指标 %s 没有标签 %s", name, m.Name))
+				}
+			}
+		case *parser.AggregateExpr:
+			// by/without 中的标签至少要存在于被聚合的表达式引用的一个指标上
+			errs = append(errs, v.checkLabels("分组标签", n.Grouping, n.Expr)...)
+		case *parser.BinaryExpr:
+			if n.VectorMatching != nil {
+				errs = append(errs, v.checkLabels("匹配标签", n.VectorMatching.MatchingLabels, n.LHS, n.RHS)...)
+				errs = append(errs, v.checkLabels("group_left/group_right 标签", n.VectorMatching.Include, n.LHS, n.RHS)...)
+			}
+		}
+		return nil
+	})
+	return errs
+}
+
+// 选择器引用的指标名称，写成 {__name__="x"} 时名称在标签匹配中
+func selectorName(s *parser.VectorSelector) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	for _, m := range s.LabelMatchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			return m.Value
+		}
+	}
+	return ""
+}
+
+// 表达式中引用到的已知指标的标签并集
+func (v *PromQLValidator) referencedLabels(nodes ...parser.Node) map[string]bool {
+	set := map[string]bool{}
+	for _, node := range nodes {
+		parser.Inspect(node, func(n parser.Node, _ []parser.Node) error {
+			if s, ok := n.(*parser.VectorSelector); ok {
+				for l := range v.labels[selectorName(s)] {
+					set[l] = true
+				}
+			}
+			return nil
+		})
+	}
+	return set
+}
+
+func (v *PromQLValidator) checkLabels(kind string, names []string, nodes ...parser.Node) []error {
+	if len(names) == 0 {
+		return nil
+	}
+	known := v.referencedLabels(nodes...)
+	var errs []error
+	for _, l := range names {
+		if !known[l] {
+			errs = append(errs, fmt.Errorf("%s %s 不属于表达式引用的任何指标", kind, l))
+		}
+	}
+	return errs
+}
+
+// 表达式最外层聚合结果保留的标签：by 为列出的标签，without 为被聚合的指标的标签去掉列出的标签。
+// 表达式无法解析或不含聚合时返回空
+func (v *PromQLValidator) groupingLabels(expr string) []string {
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil
+	}
+	// Inspect 先访问外层节点，第一个聚合即为最外层
+	var agg *parser.AggregateExpr
+	parser.Inspect(node, func(n parser.Node, _ []parser.Node) error {
+		if a, ok := n.(*parser.AggregateExpr); ok && agg == nil {
+			agg = a
+		}
+		return nil
+	})
+	if agg == nil {
+		return nil
+	}
+	if !agg.Without {
+		return append([]string(nil), agg.Grouping...)
+	}
+
+	dropped := map[string]bool{}
+	for _, l := range agg.Grouping {
+		dropped[l] = true
+	}
+	var result []string
+	for l := range v.referencedLabels(agg.Expr) {
+		if !dropped[l] {
+			result = append(result, l)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,90 @@
+package generate
+
+import (
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/metrics"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testValidator() *PromQLValidator {
+	return NewPromQLValidator([]metrics.MetricDef{
+		{Name: "oneclick_a", Labels: []string{"project", "pr_state"}},
+		{Name: "oneclick_b", Labels: []string{"project", "report"}},
+	})
+}
+
+func TestPromQLValidator(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want []string // 每个错误应包含的内容，为空表示合法
+	}{
+		{"选择器", `oneclick_a{project="PROJ", pr_state=~"open|merged"} > 0`, nil},
+		{"up 与抓取标签", `up{job="oneclick", instance!=""} == 0`, nil},
+		{"函数与范围查询", `time() - max_over_time(oneclick_a[5m]) > 300`, nil},
+		{"by", `sum by (instance, project) (oneclick_a)`, nil},
+		{"without", `sum without (pr_state) (oneclick_a)`, nil},
+		{"by 写在后面", `sum(oneclick_a) by (project)`, nil},
+		{"on 与 group_left", `oneclick_a * on (project) group_left (report) oneclick_b`, nil},
+		{"__name__", `{__name__="oneclick_b", report="ci"}`, nil},
+		{"字符串中的括号和关键字", `oneclick_a{project="by (x"}`, nil},
+
+		{"未知指标", `sum(oneclick_c)`, []string{"oneclick_c 不是由 exporter 注册的"}},
+		{"未知标签", `oneclick_a{report="ci"}`, []string{"oneclick_a 没有标签 report"}},
+		{"分组标签", `sum by (project, report) (oneclick_a)`, []string{"分组标签 report"}},
+		{"分组标签只看被聚合的表达式", `sum by (report) (oneclick_a) * on (project) group_left sum by (project) (oneclick_b)`, []string{"分组标签 report"}},
+		{"without 中的标签", `sum without (report) (oneclick_a)`, []string{"分组标签 report"}},
+		{"匹配标签", `oneclick_a / on (repo) oneclick_b`, []string{"匹配标签 repo"}},
+		{"括号不配对", `sum(oneclick_a`, []string{"unclosed left parenthesis"}},
+		{"未知函数", `foo(oneclick_a)`, []string{"unknown function"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := testValidator().Validate(tt.expr)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Validate(%q) = %v, want %d 个错误", tt.expr, errs, len(tt.want))
+			}
+			for i, err := range errs {
+				if !strings.Contains(err.Error(), tt.want[i]) {
+					t.Fatalf("Validate(%q) 第 %d 个错误 = %v, 应包含 %q", tt.expr, i, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGroupingLabels(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{`sum by (instance, project) (oneclick_a)`, []string{"instance", "project"}},
+		{`min(oneclick_a{pr_state="open"}) by (project)`, []string{"project"}},
+		// 取最外层的聚合
+		{`max by (project) (sum by (project, pr_state) (oneclick_a))`, []string{"project"}},
+		{`sum without (pr_state, job) (oneclick_a)`, []string{"instance", "project"}},
+		{`oneclick_a > 0`, nil},
+	}
+	for _, tt := range tests {
+		if got := testValidator().groupingLabels(tt.expr); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("groupingLabels(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+// 按默认阈值生成的规则可以通过校验，记录规则产生的指标可以被告警引用
+func TestPrometheusRulesValid(t *testing.T) {
+	cfg := config.RulesConfig{Job: "oneclick", MissingReportThreshold: 5, ComplianceThreshold: 0.9, StaleAfter: 300, For: "10m"}
+	if err := ValidateRules(PrometheusRules(cfg), metrics.Definitions()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 规则引用了未注册的指标时报告错误
+	rf := PrometheusRules(cfg)
+	rf.Groups[0].Rules[0].Expr = `sum by (project) (oneclick_removed)`
+	if err := ValidateRules(rf, metrics.Definitions()); err == nil || !strings.Contains(err.Error(), "oneclick_removed") {
+		t.Fatalf("应报告未注册的指标: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/metrics"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleFile 对应 Prometheus 规则文件的结构
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+type RuleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule 记录规则设置 Record，告警规则设置 Alert
+type Rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// 未配置时使用的默认值
+const (
+	defaultRulesJob   = "oneclick-metrics"
+	defaultStaleAfter = 300
+	defaultRulesFor   = "10m"
+)
+
+// 记录规则名称，遵循 level:metric:operations 的命名约定
+const (
+	recordProjectCompliance = "project:oneclick_pr_compliance_ratio:open"
+	recordProjectMissing    = "project:oneclick_pr_missing_report:open"
+	recordProjectFailures   = "project:oneclick_result_report_failure:open"
+)
+
+func withRulesDefaults(cfg config.RulesConfig) config.RulesConfig {
+	if cfg.Job == "" {
+		cfg.Job = defaultRulesJob
+	}
+	if cfg.StaleAfter <= 0 {
+		cfg.StaleAfter = defaultStaleAfter
+	}
+	if cfg.For == "" {
+		cfg.For = defaultRulesFor
+	}
+	return cfg
+}
+
+// sel 拼接指标选择器
+func sel(def metrics.MetricDef, matchers ...string) string {
+	return def.Name + "{" + strings.Join(matchers, ",") + "}"
+}
+
+// PrometheusRules 根据指标定义和配置的阈值生成记录规则和告警规则
+func PrometheusRules(cfg config.RulesConfig) RuleFile {
+	cfg = withRulesDefaults(cfg)
+	job := fmt.Sprintf(`job=%q`, cfg.Job)
+
+	recording := RuleGroup{
+		Name: "oneclick-metrics.recording",
+		Rules: []Rule{
+			{
+				Record: recordProjectCompliance,
+				Expr:   fmt.Sprintf(`min by (instance, %s, project) (%s)`, metrics.InstanceLabel, sel(metrics.ComplianceRatioDef, `pr_state="open"`, `report="all"`)),
+			},
+			{
+				Record: recordProjectMissing,
+				Expr:   fmt.Sprintf(`sum by (instance, %s, project) (%s)`, metrics.InstanceLabel, sel(metrics.PRMissingReportDef, `pr_state="open"`)),
+			},
+			{
+				Record: recordProjectFailures,
+				Expr:   fmt.Sprintf(`sum by (instance, %s, project) (%s)`, metrics.InstanceLabel, sel(metrics.ResultReportDef, `status="failure"`)),
+			},
+		},
+	}
+
+	alerts := RuleGroup{
+		Name: "oneclick-metrics.alerts",
+		Rules: []Rule{
+			{
+				Alert:  "OneClickExporterDown",
+				Expr:   fmt.Sprintf(`up{%s} == 0`, job),
+				For:    cfg.For,
+				Labels: map[string]string{"severity": "critical"},
+				Annotations: map[string]string{
+					"summary":     "OneClick metrics exporter {{ $labels.instance }} is down",
+					"description": "Prometheus cannot scrape the OneClick metrics exporter.",
+				},
+			},
+			{
+				Alert:  "OneClickCollectorDown",
+				Expr:   fmt.Sprintf(`%s == 0`, sel(metrics.CollectorUpDef, job)),
+				For:    cfg.For,
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "OneClick collector {{ $labels.collector }} is failing on {{ $labels.bitbucket_instance }}",
+					"description": "The last run of collector {{ $labels.collector }} for Bitbucket instance {{ $labels.bitbucket_instance }} on exporter {{ $labels.instance }} failed.",
+				},
+			},
+			{
+				Alert:  "OneClickDataStale",
+				Expr:   fmt.Sprintf(`time() - %s > %d`, sel(metrics.CollectorLastSuccessDef, job), cfg.StaleAfter),
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "OneClick collector {{ $labels.collector }} data is stale on {{ $labels.bitbucket_instance }}",
+					"description": fmt.Sprintf("Collector {{ $labels.collector }} for Bitbucket instance {{ $labels.bitbucket_instance }} on exporter {{ $labels.instance }} has not succeeded for more than %d seconds.", cfg.StaleAfter),
+				},
+			},
+			{
+				Alert:  "OneClickMissingReportsHigh",
+				Expr:   fmt.Sprintf(`%s > %d`, recordProjectMissing, cfg.MissingReportThreshold),
+				For:    cfg.For,
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "Project {{ $labels.project }} has open pull requests missing reports",
+					"description": fmt.Sprintf("{{ $value }} open pull requests in {{ $labels.project }} ({{ $labels.bitbucket_instance }}) are missing required reports (threshold %d).", cfg.MissingReportThreshold),
+				},
+			},
+		},
+	}
+
+	if cfg.ComplianceThreshold > 0 {
+		alerts.Rules = append(alerts.Rules, Rule{
+			Alert:  "OneClickComplianceLow",
+			Expr:   fmt.Sprintf(`%s < %g`, recordProjectCompliance, cfg.ComplianceThreshold),
+			For:    cfg.For,
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Project {{ $labels.project }} report compliance is low",
+				"description": fmt.Sprintf("Open pull request compliance in {{ $labels.project }} ({{ $labels.bitbucket_instance }}) is {{ $value | humanizePercentage }} (threshold %g).", cfg.ComplianceThreshold),
+			},
+		})
+	}
+
+	return RuleFile{Groups: []RuleGroup{recording, alerts}}
+}
+
+// ValidateRules 校验规则名称、持续时间以及 PromQL 中引用的指标和标签
+func ValidateRules(rf RuleFile, defs []metrics.MetricDef) error {
+	v := NewPromQLValidator(defs)
+	var errs []error
+	for _, g := range rf.Groups {
+		for _, r := range g.Rules {
+			name := r.Record + r.Alert
+			if r.Record != "" {
+				if !model.IsValidMetricName(model.LabelValue(r.Record)) {
+					errs = append(errs, fmt.Errorf("[%s] 记录规则名称不合法", name))
+				}
+			}
+			if r.For != "" {
+				if _, err := model.ParseDuration(r.For); err != nil {
+					errs = append(errs, fmt.Errorf("[%s] for 不合法: %w", name, err))
+				}
+			}
+			for _, err := range v.Validate(r.Expr) {
+				errs = append(errs, fmt.Errorf("[%s] %w", name, err))
+			}
+			// 记录规则产生的指标可以被后续规则引用，标签为最外层聚合保留的标签
+			if r.Record != "" {
+				v.AddMetric(r.Record, v.groupingLabels(r.Expr)...)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WritePrometheusRules 生成规则、校验后以 YAML 写出
+func WritePrometheusRules(w io.Writer, cfg config.RulesConfig, defs []metrics.MetricDef) error {
+	rf := PrometheusRules(cfg)
+	if err := ValidateRules(rf, defs); err != nil {
+		return fmt.Errorf("生成的规则校验失败: %w", err)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(rf)
+}
@@ -1,7 +1,14 @@
 package global
 
-import "oneclick-metrics-go/config"
-
-var (
-	ServerConfig *config.ServerConfig = &config.ServerConfig{}
+import (
+	"oneclick-metrics-go/config"
+	"sync/atomic"
 )
+
+// ServerConfig 当前生效的配置。热加载时构造新的配置整体替换，已经发布的配置不再修改；
+// 读取方在一次处理中只 Load 一次，得到前后一致的配置
+var ServerConfig atomic.Pointer[config.ServerConfig]
+
+func init() {
+	ServerConfig.Store(&config.ServerConfig{})
+}
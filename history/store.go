@@ -0,0 +1,293 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// 日期格式，同时用作 key 的一部分，字典序与时间顺序一致
+const DateLayout = "2006-01-02"
+
+const defaultRetentionDays = 400
+
+// 已关闭 pr 的去重记录保留天数，closed 查询只覆盖最近一分钟，两天足够
+const closedSeenDays = 2
+
+var (
+	dailyBucket  = []byte("daily")  // key: instance/project/date，value: DailyRecord 的 JSON
+	closedBucket = []byte("closed") // key: instance/project/repo/pr_no/state，value: 首次出现的日期
+)
+
+// ResultCount 单个报告类型的结果统计
+type ResultCount struct {
+	Success      int `json:"success"`
+	Failure      int `json:"failure"`
+	NotAvailable int `json:"notAvailable"`
+}
+
+// DailyRecord 单个实例中单个项目某一天的汇总。open 相关字段取当天最后一次采集的值，
+// closed 相关字段累计当天关闭的 pr（按 pr 去重）
+type DailyRecord struct {
+	Date                 string                 `json:"date"`
+	Instance             string                 `json:"instance"`
+	Project              string                 `json:"project"`
+	UpdatedAt            time.Time              `json:"updated_at"`
+	OpenPRs              int                    `json:"open_prs"`
+	OpenMissing          int                    `json:"open_missing"`
+	ComplianceRatio      float64                `json:"compliance_ratio"` // 报告齐全的 open pr 所占比例
+	ResultsByReport      map[string]ResultCount `json:"results_by_report"`
+	ClosedByState        map[string]int         `json:"closed_by_state"`
+	ClosedMissingByState map[string]int         `json:"closed_missing_by_state"`
+}
+
+// Store 基于 bbolt 的每日快照存储
+type Store struct {
+	db        *bolt.DB
+	retention int
+	loc       *time.Location
+
+	mu           sync.Mutex
+	lastRecorded map[string]time.Time // 每个实例最近一次写入的快照时间，快照没有更新时不重复写入
+	lastPruned   string               // 最近一次清理过期数据的日期
+}
+
+// Open 打开（不存在时创建）历史数据文件，loc 决定每天的起止时间
+func Open(cfg config.HistoryConfig, loc *time.Location) (*Store, error) {
+	db, err := bolt.Open(cfg.Path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开历史数据文件 %s 失败: %w", cfg.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{dailyBucket, closedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化历史数据文件失败: %w", err)
+	}
+
+	retention := cfg.RetentionDays
+	if retention <= 0 {
+		retention = defaultRetentionDays
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &Store{db: db, retention: retention, loc: loc, lastRecorded: map[string]time.Time{}}, nil
+}
+
+// Close 关闭数据文件
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func dailyKey(instance, project, date string) []byte {
+	return []byte(instance + "/" + project + "/" + date)
+}
+
+// Record 将一个实例一轮采集的快照合并到当天的记录中
+func (s *Store) Record(snapshot metrics.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instance := snapshot.Instance
+	if snapshot.UpdatedAt.IsZero() || !snapshot.UpdatedAt.After(s.lastRecorded[instance]) {
+		return nil
+	}
+	date := snapshot.UpdatedAt.In(s.loc).Format(DateLayout)
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		daily, closed := tx.Bucket(dailyBucket), tx.Bucket(closedBucket)
+
+		records := map[string]*DailyRecord{}
+		record := func(project string) (*DailyRecord, error) {
+			if r, ok := records[project]; ok {
+				return r, nil
+			}
+			r := &DailyRecord{ClosedByState: map[string]int{}, ClosedMissingByState: map[string]int{}}
+			if v := daily.Get(dailyKey(instance, project, date)); v != nil {
+				if err := json.Unmarshal(v, r); err != nil {
+					return nil, fmt.Errorf("解析 %s 的历史记录失败: %w", dailyKey(instance, project, date), err)
+				}
+			}
+			// open 相关字段每次重新计算
+			r.Date, r.Instance, r.Project, r.UpdatedAt = date, instance, project, snapshot.UpdatedAt
+			r.OpenPRs, r.OpenMissing, r.ComplianceRatio = 0, 0, 1
+			r.ResultsByReport = map[string]ResultCount{}
+			records[project] = r
+			return r, nil
+		}
+
+		// 没有 pr 的项目也记录一条，open pr 数量为 0
+		for _, repo := range snapshot.Repos {
+			if _, err := record(repo.Project); err != nil {
+				return err
+			}
+		}
+		for _, pr := range snapshot.PRs {
+			r, err := record(pr.Project)
+			if err != nil {
+				return err
+			}
+			if pr.State == "open" {
+				addOpen(r, pr)
+				continue
+			}
+			key := []byte(strings.Join([]string{instance, pr.Project, pr.Repo, pr.PrNo, pr.State}, "/"))
+			if closed.Get(key) != nil {
+				continue
+			}
+			if err := closed.Put(key, []byte(date)); err != nil {
+				return err
+			}
+			r.ClosedByState[pr.State]++
+			if len(pr.MissingReports) > 0 {
+				r.ClosedMissingByState[pr.State]++
+			}
+		}
+
+		for _, r := range records {
+			if r.OpenPRs > 0 {
+				r.ComplianceRatio = float64(r.OpenPRs-r.OpenMissing) / float64(r.OpenPRs)
+			}
+			v, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := daily.Put(dailyKey(instance, r.Project, date), v); err != nil {
+				return err
+			}
+		}
+
+		if s.lastPruned != date {
+			if err := s.prune(tx, snapshot.UpdatedAt.In(s.loc)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.lastRecorded[instance] = snapshot.UpdatedAt
+	s.lastPruned = date
+	return nil
+}
+
+// 与 /api/v1/projects/{key}/summary 的统计口径一致，缺少的必需报告计入 notAvailable
+func addOpen(r *DailyRecord, pr metrics.PRDetail) {
+	r.OpenPRs++
+	if len(pr.MissingReports) > 0 {
+		r.OpenMissing++
+	}
+	for _, report := range pr.MissingReports {
+		c := r.ResultsByReport[report]
+		c.NotAvailable++
+		r.ResultsByReport[report] = c
+	}
+	for report, res := range pr.Results {
+		c := r.ResultsByReport[report]
+		switch res {
+		case metrics.ResultSuccess:
+			c.Success++
+		case metrics.ResultFailure:
+			c.Failure++
+		default:
+			c.NotAvailable++
+		}
+		r.ResultsByReport[report] = c
+	}
+}
+
+// 删除超过保留天数的每日记录以及过期的去重记录
+func (s *Store) prune(tx *bolt.Tx, now time.Time) error {
+	dailyCutoff := now.AddDate(0, 0, -s.retention).Format(DateLayout)
+	closedCutoff := now.AddDate(0, 0, -closedSeenDays).Format(DateLayout)
+
+	var expired [][]byte
+	daily := tx.Bucket(dailyBucket)
+	err := daily.ForEach(func(k, v []byte) error {
+		if date := k[bytes.LastIndexByte(k, '/')+1:]; string(date) < dailyCutoff {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := daily.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	removed := len(expired)
+	expired = expired[:0]
+	closed := tx.Bucket(closedBucket)
+	err = closed.ForEach(func(k, v []byte) error {
+		if string(v) < closedCutoff {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := closed.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	if removed > 0 {
+		zap.S().Infof("清理了 %d 条超过 %d 天的历史记录", removed, s.retention)
+	}
+	return nil
+}
+
+// Query 返回 [from, to] 日期范围内的每日记录，instance、project 为空时不按其过滤，结果按实例、项目、日期排序
+func (s *Store) Query(instance, project, from, to string) ([]DailyRecord, error) {
+	records := []DailyRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(dailyBucket).Cursor()
+		prefix := []byte{}
+		if instance != "" {
+			prefix = []byte(instance + "/")
+		}
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			slash := bytes.LastIndexByte(k, '/')
+			if date := string(k[slash+1:]); date < from || date > to {
+				continue
+			}
+			// 项目名称中不包含 /，key 的第二段即为项目
+			if project != "" && !bytes.HasSuffix(k[:slash], []byte("/"+project)) {
+				continue
+			}
+			var r DailyRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("解析 %s 的历史记录失败: %w", k, err)
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Today 返回存储所用时区的当天日期
+func (s *Store) Today() string {
+	return time.Now().In(s.loc).Format(DateLayout)
+}
@@ -0,0 +1,174 @@
+package history
+
+import (
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/metrics"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T, retention int, loc *time.Location) *Store {
+	t.Helper()
+	s, err := Open(config.HistoryConfig{Path: filepath.Join(t.TempDir(), "history.db"), RetentionDays: retention}, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func record(t *testing.T, s *Store, instance string, at time.Time, prs ...metrics.PRDetail) {
+	t.Helper()
+	repos := []metrics.RepoCheck{{Instance: instance, Project: "EMPTY", Repo: "r"}}
+	if err := s.Record(metrics.Snapshot{Instance: instance, UpdatedAt: at, PRs: prs, Repos: repos}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func open(project, no string, missing []string, results map[string]string) metrics.PRDetail {
+	return metrics.PRDetail{Project: project, Repo: "api", PrNo: no, State: "open", MissingReports: missing, Results: results}
+}
+
+func closed(project, no, state string, missing ...string) metrics.PRDetail {
+	return metrics.PRDetail{Project: project, Repo: "api", PrNo: no, State: state, MissingReports: missing}
+}
+
+func TestRecord(t *testing.T) {
+	s := openStore(t, 0, time.UTC)
+	day := time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC)
+
+	record(t, s, "prod", day,
+		open("ABC", "1", []string{"sast"}, map[string]string{"ci": metrics.ResultSuccess}),
+		open("ABC", "2", nil, map[string]string{"ci": metrics.ResultFailure, "sast": metrics.ResultSuccess}),
+		closed("ABC", "3", "merged", "ci"),
+	)
+	// 同一天的下一轮：open 字段取最新的值，已经记录过的关闭 pr 不重复计数
+	record(t, s, "prod", day.Add(time.Minute),
+		open("ABC", "1", nil, map[string]string{"ci": metrics.ResultSuccess, "sast": metrics.ResultSuccess}),
+		closed("ABC", "3", "merged", "ci"),
+		closed("ABC", "4", "declined"),
+	)
+	// 快照没有更新时不重复写入
+	record(t, s, "prod", day.Add(time.Minute), closed("ABC", "5", "merged", "ci"))
+
+	got, err := s.Query("prod", "ABC", "2024-05-06", "2024-05-06")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DailyRecord{{
+		Date:                 "2024-05-06",
+		Instance:             "prod",
+		Project:              "ABC",
+		UpdatedAt:            day.Add(time.Minute),
+		OpenPRs:              1,
+		ComplianceRatio:      1,
+		ResultsByReport:      map[string]ResultCount{"ci": {Success: 1}, "sast": {Success: 1}},
+		ClosedByState:        map[string]int{"merged": 1, "declined": 1},
+		ClosedMissingByState: map[string]int{"merged": 1},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestRecordComplianceRatio(t *testing.T) {
+	s := openStore(t, 0, time.UTC)
+	at := time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC)
+	record(t, s, "prod", at,
+		open("ABC", "1", []string{"sast", "ci"}, nil),
+		open("ABC", "2", nil, map[string]string{"ci": metrics.ResultNotAvailable}),
+		open("ABC", "3", nil, nil),
+		open("ABC", "4", []string{"sast"}, map[string]string{"ci": metrics.ResultFailure}),
+	)
+	got, err := s.Query("", "", "2024-05-06", "2024-05-06")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("记录数 = %d, want 2（ABC 和没有 pr 的 EMPTY）", len(got))
+	}
+	abc, empty := got[0], got[1]
+	if abc.OpenPRs != 4 || abc.OpenMissing != 2 || abc.ComplianceRatio != 0.5 {
+		t.Errorf("ABC = %+v", abc)
+	}
+	wantResults := map[string]ResultCount{"ci": {Failure: 1, NotAvailable: 2}, "sast": {NotAvailable: 2}}
+	if !reflect.DeepEqual(abc.ResultsByReport, wantResults) {
+		t.Errorf("ResultsByReport = %+v, want %+v", abc.ResultsByReport, wantResults)
+	}
+	if empty.Project != "EMPTY" || empty.OpenPRs != 0 || empty.ComplianceRatio != 1 {
+		t.Errorf("EMPTY = %+v", empty)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	s := openStore(t, 0, shanghai)
+	// UTC 5 月 6 日 20 点在东八区是 5 月 7 日
+	base := time.Date(2024, 5, 5, 20, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		at := base.AddDate(0, 0, i)
+		record(t, s, "prod", at, open("ABC", "1", nil, nil), open("XYZ", "2", nil, nil))
+		record(t, s, "acq", at, open("ABC", "1", nil, nil))
+	}
+
+	tests := []struct {
+		name              string
+		instance, project string
+		from, to          string
+		want              []string // instance/project/date
+	}{
+		{"按实例和项目", "prod", "ABC", "2024-05-01", "2024-05-31", []string{"prod/ABC/2024-05-06", "prod/ABC/2024-05-07", "prod/ABC/2024-05-08"}},
+		{"日期范围包含两端", "acq", "", "2024-05-07", "2024-05-08", []string{"acq/ABC/2024-05-07", "acq/ABC/2024-05-08", "acq/EMPTY/2024-05-07", "acq/EMPTY/2024-05-08"}},
+		{"所有实例", "", "XYZ", "2024-05-08", "2024-05-08", []string{"prod/XYZ/2024-05-08"}},
+		{"项目名称按整段匹配", "", "BC", "2024-05-01", "2024-05-31", []string{}},
+		{"不存在的实例", "pro", "", "2024-05-01", "2024-05-31", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := s.Query(tt.instance, tt.project, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, r := range records {
+				got = append(got, r.Instance+"/"+r.Project+"/"+r.Date)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 新的一天第一次写入时删除超过保留天数的记录，关闭 pr 的去重记录只保留两天
+func TestPrune(t *testing.T) {
+	s := openStore(t, 3, time.UTC)
+	first := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	record(t, s, "prod", first, closed("ABC", "1", "merged"))
+	record(t, s, "prod", first.AddDate(0, 0, 2), open("ABC", "2", nil, nil))
+
+	// 3 天后 5 月 1 日的记录仍在保留期内，去重记录在当天第一次写入后删除，同一个 pr 再次出现时重新计数
+	record(t, s, "prod", first.AddDate(0, 0, 3), open("ABC", "2", nil, nil))
+	record(t, s, "prod", first.AddDate(0, 0, 3).Add(time.Minute), closed("ABC", "1", "merged"))
+	if got, _ := s.Query("prod", "ABC", "2024-05-01", "2024-05-01"); len(got) != 1 {
+		t.Fatalf("保留期内的记录被删除: %+v", got)
+	}
+	if got, _ := s.Query("prod", "ABC", "2024-05-04", "2024-05-04"); len(got) != 1 || got[0].ClosedByState["merged"] != 1 {
+		t.Fatalf("过期的去重记录没有被删除: %+v", got)
+	}
+
+	record(t, s, "prod", first.AddDate(0, 0, 4), open("ABC", "2", nil, nil))
+	got, err := s.Query("prod", "ABC", "2024-01-01", "2024-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dates []string
+	for _, r := range got {
+		dates = append(dates, r.Date)
+	}
+	if want := []string{"2024-05-03", "2024-05-04", "2024-05-05"}; !reflect.DeepEqual(dates, want) {
+		t.Errorf("清理后的日期 = %v, want %v", dates, want)
+	}
+}
@@ -1,22 +1,281 @@
 package initialize
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
+	"oneclick-metrics-go/config"
 	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/metrics"
+)
+
+// 环境变量前缀，例如 database.host 对应 ONECLICK_DATABASE_HOST
+const envPrefix = "ONECLICK"
+
+const defaultConfigFile = "config.yaml"
+
+// 由 ConfigFlags 注册的命令行参数
+var (
+	configFlags *flag.FlagSet
+	configPath  *string
+	flagKeys    = map[string]bool{} // 与配置项同名的参数
 )
 
-// 初始化配置类
+// 没有配置文件时也能只通过环境变量启动
+var configDefaults = map[string]interface{}{
+	"port":                      "8000",
+	"database.driver":           "postgres",
+	"database.sslmode":          "verify-full",
+	"database.application_name": "oneclick-metrics",
+	"database.connect_timeout":  10,
+	"timezone":                  "UTC",
+	"interval":                  15,
+	"log.level":                 "info",
+	"log.format":                "console",
+	"log.max_size":              100,
+	"watch_config":              true,
+	"engine":                    "queries",
+	"report_keys":               []string{"ci", "codecoverage", "sast", "smoke", "snyk", "sabug", "sasmell", "savul"},
+}
+
+// ConfigFlags 在 fs 上注册 --config 以及 config.ServerConfig 中每个配置项对应的参数，
+// 参数名与配置项相同，例如 --database.host、--push.remote_write.url
+func ConfigFlags(fs *flag.FlagSet) {
+	configFlags = fs
+	configPath = fs.String("config", "", fmt.Sprintf("配置文件路径，默认读取 %s_CONFIG 或 %s（不存在时忽略）", envPrefix, defaultConfigFile))
+
+	walkConfig(reflect.TypeOf(config.ServerConfig{}), "", func(key string, t reflect.Type) {
+		usage := fmt.Sprintf("覆盖配置项 %s（环境变量 %s）", key, envName(key))
+		switch t.Kind() {
+		case reflect.String:
+			fs.String(key, "", usage)
+		case reflect.Int:
+			fs.Int(key, 0, usage)
+		case reflect.Bool:
+			fs.Bool(key, false, usage)
+		case reflect.Float64:
+			fs.Float64(key, 0, usage)
+		case reflect.Map:
+			fs.Var(&mapValue{}, key, usage+"，格式 k1=v1,k2=v2")
+		case reflect.Slice:
+			fs.Var(&listValue{}, key, usage+"，多个值用逗号分隔")
+		default:
+			return
+		}
+		flagKeys[key] = true
+	})
+}
+
+// 按 mapstructure 标签遍历配置结构体的所有叶子字段
+func walkConfig(t reflect.Type, prefix string, fn func(key string, t reflect.Type)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		key := prefix + tag
+		if field.Type.Kind() == reflect.Struct {
+			walkConfig(field.Type, key+".", fn)
+			continue
+		}
+		// 结构体列表（例如 instances）只能在配置文件中设置
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			continue
+		}
+		fn(key, field.Type)
+	}
+}
+
+func envName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// 初始化配置类，优先级：命令行参数 > 环境变量 > 配置文件 > 默认值。
+// 读取或校验失败时输出所有问题并以状态码 2 退出。只校验不需要连接数据库的配置项，
+// 需要连接数据库的子命令使用 InitDatabaseConfig
 func InitConfig() {
-	configFileName := "config.yaml"
+	initConfig(false)
+}
+
+// InitDatabaseConfig 与 InitConfig 相同，同时校验实例和数据库配置（密码来源、TLS 证书和私钥等）
+func InitDatabaseConfig() {
+	initConfig(true)
+}
+
+func initConfig(databases bool) {
+	cfg, err := LoadConfig()
+	switch {
+	case err != nil:
+	case databases:
+		err = cfg.ValidateWithDatabases(metrics.KnownNames())
+	default:
+		err = cfg.Validate(metrics.KnownNames())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	global.ServerConfig.Store(cfg)
+}
+
+// ConfigFile 返回读取的配置文件路径，没有使用配置文件时为空
+func ConfigFile() string {
+	return configFile
+}
+
+// 最近一次读取的配置文件
+var configFile string
+
+// LoadConfig 按与 InitConfig 相同的规则重新读取配置，不修改当前生效的配置，用于热加载
+func LoadConfig() (*config.ServerConfig, error) {
 	v := viper.New()
-	v.SetConfigFile(configFileName)
+	for key, value := range configDefaults {
+		v.SetDefault(key, value)
+	}
+
+	// 环境变量需要逐个绑定，否则配置文件中不存在的配置项不会被读取
+	walkConfig(reflect.TypeOf(config.ServerConfig{}), "", func(key string, _ reflect.Type) {
+		_ = v.BindEnv(key, envName(key))
+	})
+	// 完整的连接字符串，优先于 database 下的单独配置
+	_ = v.BindEnv("database.url", envName("database.url"), "DATABASE_URL")
+
+	path, explicit := defaultConfigFile, false
+	if configPath != nil && *configPath != "" {
+		path, explicit = *configPath, true
+	} else if env := os.Getenv(envPrefix + "_CONFIG"); env != "" {
+		path, explicit = env, true
+	}
+	configFile = ""
+	if _, err := os.Stat(path); err == nil || explicit {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("读取配置文件 %s 失败: %w", path, err)
+		}
+		configFile = path
+	}
+
+	if configFlags != nil {
+		configFlags.Visit(func(f *flag.Flag) {
+			if flagKeys[f.Name] {
+				v.Set(f.Name, f.Value.(flag.Getter).Get())
+			}
+		})
+	}
+
+	hook := mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		stringToMapHookFunc,
+	)
+	cfg := &config.ServerConfig{}
+	if err := v.Unmarshal(cfg, viper.DecodeHook(hook)); err != nil {
+		return nil, fmt.Errorf("解析配置失败: %w", err)
+	}
+	recordInstanceKeys(v, cfg)
+	return cfg, nil
+}
+
+// 记录每个实例的 database 下显式设置的字段，解析后的结构体无法区分未设置和设置为零值
+func recordInstanceKeys(v *viper.Viper, cfg *config.ServerConfig) {
+	raw, _ := v.Get("instances").([]interface{})
+	for i, item := range raw {
+		if i >= len(cfg.Instances) {
+			break
+		}
+		inst, _ := item.(map[string]interface{})
+		db, _ := inst["database"].(map[string]interface{})
+		keys := map[string]bool{}
+		for k := range db {
+			keys[strings.ToLower(k)] = true
+		}
+		cfg.Instances[i].DatabaseKeys = keys
+	}
+}
+
+// 环境变量中的 map 配置使用 k1=v1,k2=v2 格式
+func stringToMapHookFunc(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to.Kind() != reflect.Map {
+		return data, nil
+	}
+	return parseMap(data.(string))
+}
+
+func parseMap(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, errors.New("格式应为 k1=v1,k2=v2: " + s)
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(val)
+	}
+	return m, nil
+}
 
-	if err := v.ReadInConfig(); err != nil {
-		panic(err)
+// map 类型的命令行参数
+type mapValue struct {
+	m map[string]string
+}
+
+func (v *mapValue) String() string {
+	if v == nil || len(v.m) == 0 {
+		return ""
 	}
+	pairs := make([]string, 0, len(v.m))
+	for k, val := range v.m {
+		pairs = append(pairs, k+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
 
-	if err := v.Unmarshal(global.ServerConfig); err != nil {
-		panic(err)
+func (v *mapValue) Set(s string) error {
+	m, err := parseMap(s)
+	if err != nil {
+		return err
 	}
+	v.m = m
+	return nil
+}
+
+func (v *mapValue) Get() interface{} {
+	return v.m
+}
+
+// 列表类型的命令行参数
+type listValue struct {
+	items []string
+}
+
+func (v *listValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(v.items, ",")
+}
+
+func (v *listValue) Set(s string) error {
+	v.items = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			v.items = append(v.items, item)
+		}
+	}
+	return nil
+}
 
+func (v *listValue) Get() interface{} {
+	return v.items
 }
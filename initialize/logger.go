@@ -3,27 +3,79 @@ package initialize
 import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"net/http"
+	"oneclick-metrics-go/config"
 	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/secret"
+	"os"
 	"strings"
+	"time"
 )
 
-func InitLogger() {
+// 日志级别，热加载和 /-/log-level 直接修改，不需要重建 logger
+var logLevel = zap.NewAtomicLevel()
+
+func parseLevel(s string) zapcore.Level {
 	var level zapcore.Level
-	err := level.UnmarshalText([]byte(strings.ToLower(global.ServerConfig.LogInfo.Level)))
-	if err != nil {
-		level = zapcore.InfoLevel
+	if err := level.UnmarshalText([]byte(strings.ToLower(s))); err != nil {
+		return zapcore.InfoLevel
 	}
+	return level
+}
+
+// SetLogLevel 修改日志级别，无法识别的级别按 info 处理
+func SetLogLevel(level string) {
+	logLevel.SetLevel(parseLevel(level))
+}
+
+// LogLevelHandler 查看和修改日志级别：GET 返回 {"level":"info"}，
+// PUT 的请求体为 {"level":"debug"} 或表单 level=debug。热加载配置时恢复为配置中的级别
+func LogLevelHandler() http.Handler {
+	return logLevel
+}
 
-	cfg := zap.Config{
-		Level:            zap.NewAtomicLevelAt(level),
-		Development:      true,
-		Encoding:         "console",
-		EncoderConfig:    zap.NewDevelopmentEncoderConfig(),
-		OutputPaths:      []string{"stderr"},
-		ErrorOutputPaths: []string{"stderr"},
+func InitLogger() {
+	serverCfg := global.ServerConfig.Load()
+	cfg := serverCfg.LogInfo
+	SetLogLevel(cfg.Level)
+
+	// json 供日志平台解析，console 保持原来的开发格式
+	var encoder zapcore.Encoder
+	opts := []zap.Option{zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr))}
+	if cfg.Format == "json" {
+		encoderConfig := zap.NewProductionEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+		opts = append(opts, zap.AddStacktrace(zapcore.ErrorLevel))
+	} else {
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+		opts = append(opts, zap.Development(), zap.AddStacktrace(zapcore.WarnLevel))
 	}
 
-	logger, _ := cfg.Build()
+	// 日志中的密码、token 等敏感信息统一脱敏
+	secret.RegisterConfig(serverCfg)
+	core := secret.NewCore(zapcore.NewCore(encoder, logOutput(cfg), logLevel))
+	// 采样在 Check 中进行，需要包在最外层
+	if cfg.Sampling.Initial > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, cfg.Sampling.Initial, cfg.Sampling.Thereafter)
+	}
+	logger := zap.New(core, opts...)
 
 	zap.ReplaceGlobals(logger)
 }
+
+// 设置了 file 时写入文件并按大小滚动，否则输出到标准错误
+func logOutput(cfg config.LogConfig) zapcore.WriteSyncer {
+	if cfg.File == "" {
+		return zapcore.Lock(os.Stderr)
+	}
+	return zapcore.AddSync(&lumberjack.Logger{
+		Filename:   cfg.File,
+		MaxSize:    cfg.MaxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge,
+		Compress:   cfg.Compress,
+		LocalTime:  true,
+	})
+}
@@ -3,90 +3,393 @@ package metrics
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"go.uber.org/zap"
+	"oneclick-metrics-go/config"
 	dbase "oneclick-metrics-go/db"
-	"oneclick-metrics-go/global"
 	"sync"
 	"time"
 )
 
-func CollectMetrics(ctx0 context.Context, m *Metrics, db0 *sql.DB, wg *sync.WaitGroup) {
-	ticker := time.NewTicker(time.Duration(global.ServerConfig.Interval) * time.Second)
-
-	type MetricTask struct {
-		Name     string
-		QueryKey string
-		ExportFn func(ctx context.Context, m *Metrics, db *sql.Conn)
-	}
+// MetricTask 描述一个采集任务：名称、使用的预编译 sql 以及导出函数
+type MetricTask struct {
+	Name     string
+	QueryKey string
+	ExportFn func(ctx context.Context, inst *Instance, db dbase.Querier) error
+}
 
-	tasks := []MetricTask{
+// Tasks 返回所有注册的采集任务
+func Tasks() []MetricTask {
+	return []MetricTask{
 		{"PRMissingReport", "pr_missing_report_query", ExportPRMissingReport},
 		{"PRNum", "pr_counts_query", ExportPRNum},
 		{"OpenPRReport", "open_pr_report_query", ExportOpenPRReport},
 		{"ClosedPRReport", "closed_pr_report_query", ExportClosedPRReport},
 		{"ResultReport", "result_report_query", ExportResultReport},
 		{"CheckSummary", "check_summary_query", ExportCheckSummary},
+		{"ComplianceRatio", "pr_compliance_query", ExportComplianceRatio},
+		{"PRDetail", "pr_detail_query", ExportPRDetail},
+	}
+}
+
+// TaskNames 返回所有采集任务的名称
+func TaskNames() []string {
+	var names []string
+	for _, task := range Tasks() {
+		names = append(names, task.Name)
 	}
+	return names
+}
 
-	// 初始化连接和 context
-	conns := make([]*sql.Conn, len(tasks))
+// KnownNames 返回配置校验需要的采集任务名称和指标标签
+func KnownNames() config.Known {
+	return config.Known{Collectors: TaskNames(), MetricLabels: LabelNames()}
+}
 
-	for i, task := range tasks {
-		conn, err := ensureConn(ctx0, db0, task.Name)
-		if err != nil {
-			zap.S().Infof("初始化连接失败 [%s]: %v", tasks[i].Name, err)
+// 配置项 disabled_collectors 中的任务不执行，热加载后从下一轮采集开始生效
+func taskDisabled(cfg *config.ServerConfig, name string) bool {
+	for _, disabled := range cfg.DisabledCollectors {
+		if disabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 每轮采集的超时时间，超时后取消未完成的查询。一个实例的查询卡住时不会一直占用该实例，热加载也不会一直等待
+func cycleTimeout(cfg *config.ServerConfig) time.Duration {
+	if cfg.CycleTimeout > 0 {
+		return time.Duration(cfg.CycleTimeout) * time.Second
+	}
+	return time.Duration(cfg.Interval) * time.Second
+}
+
+// CollectMetrics 为每个实例启动独立的采集循环，按 interval 持续采集，直到 ctx 结束。
+// afterCycle 在每个实例每轮任务完成后调用（例如推送指标、记录历史）。
+// reloads 中的新配置在每个实例的两轮采集之间应用，应用后按新的 interval 重新计时
+func CollectMetrics(ctx context.Context, instances []*Instance, reloads <-chan ReloadRequest, afterCycle ...func(ctx context.Context, inst *Instance)) {
+	for _, inst := range instances {
+		go inst.collect(ctx, afterCycle)
+	}
+	for {
+		select {
+		case req := <-reloads:
+			req.Done <- applyReload(ctx, instances, req.Config)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (inst *Instance) collect(ctx context.Context, afterCycle []func(ctx context.Context, inst *Instance)) {
+	tasks := Tasks()
+
+	// 初始化连接并初次 prepare，失败的任务在每轮采集时重试。回放模式和 unified 方式不需要任务的连接，
+	// 热加载切换到 queries 方式后在下一轮采集时连接；连接池没有打开时也在采集时连接
+	inst.mu.Lock()
+	cfg := inst.currentConfig()
+	inst.conns = make([]*sql.Conn, len(tasks))
+	inst.connSince = make([]time.Time, len(tasks))
+	if inst.replay == nil && inst.DB != nil && !unifiedEngine(cfg) {
+		initCtx, cancel := context.WithTimeout(ctx, cycleTimeout(cfg))
+		var wg sync.WaitGroup
+		for i, task := range tasks {
+			wg.Add(1)
+			go func(i int, task MetricTask) {
+				defer wg.Done()
+				inst.connect(initCtx, cfg, i, task)
+			}(i, task)
+		}
+		wg.Wait()
+		cancel()
+	}
+	ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+	inst.mu.Unlock()
+
+	defer ticker.Stop()
+	defer func() {
+		for _, conn := range inst.conns {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-inst.intervalChanged:
+			ticker.Reset(time.Duration(inst.currentConfig().Interval) * time.Second)
+			continue
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !inst.runCycle(ctx, tasks) {
 			continue
 		}
-		conns[i] = conn
 
-		// 初次 prepare
-		if err := dbase.RegisterPreparedSQLsWithRetry(ctx0, task.QueryKey, conn, true); err != nil {
-			zap.S().Infof("初次 prepare 失败 [%s]: %v", task.Name, err)
+		for _, fn := range afterCycle {
+			fn(ctx, inst)
 		}
 	}
+}
+
+// 并发执行一轮所有未禁用的任务，等待全部完成或超过 cycle_timeout 后返回，整轮使用同一份配置。
+// 连接池无法打开或备库复制延迟过大时跳过本轮并返回 false
+func (inst *Instance) runCycle(ctx context.Context, tasks []MetricTask) bool {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	cfg := inst.currentConfig()
+	ctx, cancel := context.WithTimeout(ctx, cycleTimeout(cfg))
+	defer cancel()
+	start := time.Now()
+	inst.beginSnapshot()
+
+	if err := inst.ensureDB(); err != nil {
+		inst.logger().Errorf("跳过本轮采集: %v", err)
+		for _, task := range tasks {
+			if !taskDisabled(cfg, task.Name) {
+				inst.m.CollectorUp.WithLabelValues(task.Name).Set(0)
+			}
+		}
+		return false
+	}
 
-	for _, conn := range conns {
-		defer conn.Close()
+	if unifiedEngine(cfg) {
+		var q dbase.Querier = inst.replay
+		if q == nil {
+			conn, state, err := inst.sessionConn(ctx, cfg, inst.taskLogger(UnifiedTask))
+			if err != nil {
+				inst.taskLogger(UnifiedTask).Infof("采集失败: %v", err)
+				inst.unifiedDown(cfg)
+				return true
+			}
+			defer conn.Close()
+			if err := inst.checkReplicaLag(cfg, []*dbase.SessionState{state}); err != nil {
+				inst.logger().Warnf("跳过本轮采集: %v", err)
+				return false
+			}
+			q = conn
+		}
+		if err := inst.runUnified(ctx, q); err != nil {
+			inst.taskLogger(UnifiedTask).Infof("采集失败: %v", err)
+		}
+		inst.publishSnapshot(cfg, start)
+		return true
 	}
 
-	for range ticker.C {
+	// 先检查所有任务的连接，按这些连接所在服务器的复制延迟决定是否跳过本轮
+	usable := make([]bool, len(tasks))
+	if inst.replay == nil {
+		states := make([]*dbase.SessionState, len(tasks))
+		var wg sync.WaitGroup
 		for i, task := range tasks {
-			// 检查conn连接初始化是否完成
-			if conns[i] == nil {
-				zap.S().Infof("[%s] 跳过执行：连接未初始化", task.Name)
+			if taskDisabled(cfg, task.Name) {
 				continue
 			}
 			wg.Add(1)
 			go func(i int, task MetricTask) {
 				defer wg.Done()
-				//start := time.Now()
-
-				// 检查连接是否有效
-				if err := conns[i].PingContext(ctx0); err != nil {
-					zap.S().Infof("[%s] 连接失效，尝试重连: %v", task.Name, err)
-					newConn, err := ensureConn(ctx0, db0, task.Name)
-					if err != nil {
-						zap.S().Infof("[%s] 重连失败: %v", task.Name, err)
-						return
-					}
-					conns[i].Close()
-					conns[i] = newConn
-					// 重新进行prepare
-					if err := dbase.RegisterPreparedSQLsWithRetry(ctx0, task.QueryKey, conns[i], true); err != nil {
-						zap.S().Infof("初次 prepare 失败 [%s]: %v", task.Name, err)
-					}
+				usable[i], states[i] = inst.checkConn(ctx, cfg, i, task)
+			}(i, task)
+		}
+		wg.Wait()
+		if err := inst.checkReplicaLag(cfg, states); err != nil {
+			inst.logger().Warnf("跳过本轮采集: %v", err)
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		if taskDisabled(cfg, task.Name) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, task MetricTask) {
+			defer wg.Done()
+			var q dbase.Querier = inst.replay
+			if q == nil {
+				if !usable[i] {
+					inst.m.CollectorUp.WithLabelValues(task.Name).Set(0)
+					return
 				}
+				q = inst.conns[i]
+			}
+			if err := runTask(ctx, inst, q, task); err != nil {
+				inst.taskLogger(task).Infof("采集失败: %v", err)
+			}
+		}(i, task)
+	}
+	wg.Wait() // 等待所有任务完成后再进入下一轮 tick
+	inst.publishSnapshot(cfg, start)
+	return true
+}
 
-				task.ExportFn(ctx0, m, conns[i])
-				//log.Printf("%s 执行耗时: %v", task.Name, time.Since(start))
-			}(i, task)
+// 建立第 i 个任务的连接并按 cfg prepare，失败时连接保持为空
+func (inst *Instance) connect(ctx context.Context, cfg *config.ServerConfig, i int, task MetricTask) bool {
+	conn, err := ensureConn(ctx, inst.DB, inst.taskLogger(task))
+	if err != nil {
+		inst.taskLogger(task).Infof("初始化连接失败: %v", err)
+		return false
+	}
+	inst.conns[i] = conn
+	inst.connSince[i] = time.Now()
+	if err := dbase.RegisterPreparedSQLsWithRetry(ctx, cfg, task.QueryKey, conn, true); err != nil {
+		inst.taskLogger(task).Infof("初次 prepare 失败: %v", err)
+	}
+	return true
+}
+
+// 检查第 i 个任务的连接并查询其所在服务器的状态。连接未初始化、失效或者所在的服务器不再满足
+// target_session_attrs（例如主备切换后）时重新连接并 prepare。返回连接是否可用以及服务器状态，状态未知时为空
+func (inst *Instance) checkConn(ctx context.Context, cfg *config.ServerConfig, i int, task MetricTask) (bool, *dbase.SessionState) {
+	attrs := inst.sessionAttrs(cfg)
+	if inst.conns[i] == nil {
+		inst.taskLogger(task).Infof("连接未初始化，尝试连接")
+	} else {
+		state, err := dbase.ReadSessionState(ctx, inst.conns[i])
+		switch {
+		case err != nil:
+			inst.taskLogger(task).Infof("连接失效，尝试重连: %v", err)
+		case inst.keepConn(attrs, i, state):
+			return true, &state
+		default:
+			inst.taskLogger(task).Infof("连接所在的服务器不满足 target_session_attrs=%s，重新连接", attrs)
 		}
-		wg.Wait() // 等待所有任务完成后再进入下一轮 tick
+		inst.dropConn(i)
+	}
+	if !inst.connect(ctx, cfg, i, task) {
+		return false, nil
+	}
+	state, err := dbase.ReadSessionState(ctx, inst.conns[i])
+	if err != nil {
+		inst.taskLogger(task).Infof("查询服务器状态失败: %v", err)
+		return true, nil
+	}
+	return true, &state
+}
+
+// 关闭第 i 个任务的连接，并从连接池中移除，重连时会重新选择服务器
+func (inst *Instance) dropConn(i int) {
+	if inst.conns[i] != nil {
+		dbase.DiscardConn(inst.conns[i])
+		inst.conns[i] = nil
+	}
+}
+
+// CollectOnce 所有实例并发执行每个采集任务一次，同一实例中的任务依次执行且使用独立的连接，返回所有失败任务的错误
+func CollectOnce(ctx context.Context, instances []*Instance) error {
+	errs := make([][]error, len(instances))
+	var wg sync.WaitGroup
+	for i, inst := range instances {
+		wg.Add(1)
+		go func(i int, inst *Instance) {
+			defer wg.Done()
+			inst.mu.Lock()
+			defer inst.mu.Unlock()
+			cfg := inst.currentConfig()
+			start := time.Now()
+			inst.beginSnapshot()
+			defer inst.publishSnapshot(cfg, start)
+			if err := inst.ensureDB(); err != nil {
+				inst.logger().Errorf("跳过采集: %v", err)
+				errs[i] = append(errs[i], fmt.Errorf("[%s] %w", inst.Name, err))
+				return
+			}
+			if unifiedEngine(cfg) {
+				if err := inst.collectUnifiedOnce(ctx, cfg); err != nil {
+					inst.taskLogger(UnifiedTask).Errorf("采集失败: %v", err)
+					errs[i] = append(errs[i], fmt.Errorf("[%s/%s] %w", inst.Name, UnifiedTask.Name, err))
+				}
+				return
+			}
+			for _, task := range Tasks() {
+				if taskDisabled(cfg, task.Name) {
+					continue
+				}
+				if err := collectTaskOnce(ctx, cfg, inst, task); err != nil {
+					inst.taskLogger(task).Errorf("采集失败: %v", err)
+					inst.m.CollectorUp.WithLabelValues(task.Name).Set(0)
+					errs[i] = append(errs[i], fmt.Errorf("[%s/%s] %w", inst.Name, task.Name, err))
+				}
+			}
+		}(i, inst)
+	}
+	wg.Wait()
+
+	var all []error
+	for _, e := range errs {
+		all = append(all, e...)
+	}
+	return errors.Join(all...)
+}
+
+// 在一个独立的连接上执行任务，复制延迟按这个连接所在的服务器检查
+func collectTaskOnce(ctx context.Context, cfg *config.ServerConfig, inst *Instance, task MetricTask) error {
+	if inst.replay != nil {
+		return runTask(ctx, inst, inst.replay, task)
+	}
+	conn, state, err := inst.sessionConn(ctx, cfg, inst.taskLogger(task))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := inst.checkReplicaLag(cfg, []*dbase.SessionState{state}); err != nil {
+		return err
+	}
+
+	if err := dbase.RegisterPreparedSQLsWithRetry(ctx, cfg, task.QueryKey, conn, true); err != nil {
+		return err
+	}
+	return runTask(ctx, inst, conn, task)
+}
+
+// unified 方式的两个查询在同一个连接上执行，复制延迟按这个连接所在的服务器检查
+func (inst *Instance) collectUnifiedOnce(ctx context.Context, cfg *config.ServerConfig) error {
+	if inst.replay != nil {
+		return inst.runUnified(ctx, inst.replay)
+	}
+	conn, state, err := inst.sessionConn(ctx, cfg, inst.taskLogger(UnifiedTask))
+	if err != nil {
+		inst.unifiedDown(cfg)
+		return err
+	}
+	defer conn.Close()
+	if err := inst.checkReplicaLag(cfg, []*dbase.SessionState{state}); err != nil {
+		return err
+	}
+	return inst.runUnified(ctx, conn)
+}
+
+// 执行单个采集任务，并记录任务的耗时和成功状态。开启录制时同时把查询结果写入录制目录
+func runTask(ctx context.Context, inst *Instance, q dbase.Querier, task MetricTask) error {
+	m := inst.m
+	if inst.recorder != nil {
+		session := inst.recorder.Session(inst.Name, q)
+		defer func() {
+			if err := session.Save(); err != nil {
+				inst.taskLogger(task).Errorf("录制查询结果失败: %v", err)
+			}
+		}()
+		q = session
+	}
+	start := time.Now()
+	err := task.ExportFn(withLogger(ctx, inst.taskLogger(task)), inst, q)
+	m.CollectorDuration.WithLabelValues(task.Name).Set(time.Since(start).Seconds())
+	if err != nil {
+		m.CollectorUp.WithLabelValues(task.Name).Set(0)
+		return err
 	}
+	m.CollectorUp.WithLabelValues(task.Name).Set(1)
+	m.CollectorLastSuccess.WithLabelValues(task.Name).SetToCurrentTime()
+	return nil
 }
 
 // 连接初始化和重连机制
-func ensureConn(ctx context.Context, db *sql.DB, taskName string) (*sql.Conn, error) {
+func ensureConn(ctx context.Context, db *sql.DB, log *zap.SugaredLogger) (*sql.Conn, error) {
 	var conn *sql.Conn
 	var err error
 	for retry := 0; retry < 3; retry++ {
@@ -94,9 +397,9 @@ func ensureConn(ctx context.Context, db *sql.DB, taskName string) (*sql.Conn, er
 		if err == nil {
 			return conn, nil
 		}
-		zap.S().Infof("[%s] 获取连接失败（重试 %d）: %v", taskName, retry+1, err)
+		log.Infof("获取连接失败（重试 %d）: %v", retry+1, err)
 		time.Sleep(1 * time.Second)
 	}
-	zap.S().Errorf("[%s] 获取连接失败，已重试 3 次: %w", taskName, err)
+	log.Errorf("获取连接失败，已重试 3 次: %v", err)
 	return nil, err
 }
@@ -5,17 +5,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"go.uber.org/zap"
-	"oneclick-metrics-go/global"
+	dbase "oneclick-metrics-go/db"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 )
 
-var (
-	allProjects     map[string]struct{} = make(map[string]struct{})
-	allProjectsLock sync.Mutex
-)
+// pr_state 数值与标签值的对应关系
+var prStates = [3]string{"open", "merged", "declined"}
 
 type PrMissingReport struct {
 	Count   string
@@ -52,52 +49,29 @@ type CheckSummary struct {
 	Project       string
 }
 
-// GetAllProjects 从数据库中获取所有项目的名称
-func GetAllProjects(ctx context.Context, db *sql.Conn, knownProjects map[string]struct{}) (map[string]struct{}, error) {
-	allProjectsLock.Lock()
-	defer allProjectsLock.Unlock()
-	// 如果缓存为空，则从数据库查询
-	if len(allProjects) == 0 {
-		query := `
-            SELECT DISTINCT project.project_key
-            FROM sta_pull_request pr
-            INNER JOIN repository repo ON pr.from_repository_id = repo.id
-            INNER JOIN project ON repo.project_id = project.id
-            WHERE project.project_key NOT LIKE '~%';
-        `
-		rows, err := db.QueryContext(ctx, query)
-		if err != nil {
-			zap.S().Errorf("获取项目名称过程中有误: %v", err)
-			return nil, err
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var projectKey string
-			if err = rows.Scan(&projectKey); err != nil {
-				zap.S().Errorf("浏览项目key时出错: %v", err)
-				continue
-			}
-			allProjects[projectKey] = struct{}{}
-		}
-
-		if err = rows.Err(); err != nil {
-			return nil, err
-		}
-	}
-
-	// 合并 knownProjects
-	for k := range knownProjects {
-		allProjects[k] = struct{}{}
-	}
+type PrCompliance struct {
+	State           string
+	Project         string
+	RequiredReports sql.NullString
+	PresentReports  sql.NullString
+}
 
-	// 返回副本，避免外部 goroutine 访问共享 map
-	result := make(map[string]struct{}, len(allProjects))
-	for k := range allProjects {
-		result[k] = struct{}{}
-	}
+type PrDetailRow struct {
+	Project         string
+	StashRepo       string
+	PrNo            string
+	State           string
+	RequiredReports sql.NullString
+	ReportResults   sql.NullString
+	ReportData      sql.NullString
+}
 
-	return result, nil
+// 单个 project、单个 pr_state 下的合规计数
+type complianceCount struct {
+	total     int            // pr 总数
+	compliant int            // 报告齐全的 pr 数量
+	required  map[string]int // 每种报告被要求的 pr 数量
+	present   map[string]int // 每种报告被要求且已提供的 pr 数量
 }
 
 // 将从sql中获取的原始数据解析为代码覆盖率
@@ -134,21 +108,24 @@ func ExtractCodeCoverage(rawData string) string {
 	return ""
 }
 
-func ExportPRMissingReport(ctx context.Context, m *Metrics, db *sql.Conn) {
+func ExportPRMissingReport(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	m := inst.m
 	m.OneClickPRMissingReport.Reset()
 
-	pgsql := fmt.Sprintf("EXECUTE pr_missing_report_query(date_trunc('minute',current_timestamp AT TIME ZONE '%s'));", global.ServerConfig.Timezone)
+	pgsql := fmt.Sprintf("EXECUTE pr_missing_report_query(date_trunc('minute',current_timestamp AT TIME ZONE '%s'));", inst.currentConfig().Timezone)
 	rows, err := db.QueryContext(ctx, pgsql)
 	if err != nil {
-		zap.S().Errorf("ExportPRMissingReport QueryContext 过程中发生错误: %v", err)
-		return
+		logger(ctx).Errorf("ExportPRMissingReport QueryContext 过程中发生错误: %v", err)
+		return err
 	}
+	defer rows.Close()
+
 	var rawResults []PrMissingReport          // 获取本次sql查询的结果
 	knowProjects := make(map[string]struct{}) // 保存本次查询中涉及的project
 	for rows.Next() {
 		var r PrMissingReport
 		if err = rows.Scan(&r.Count, &r.State, &r.Project); err != nil {
-			zap.S().Errorf("ExportPRMissingReport scan 过程中发生错误: %v", err)
+			logger(ctx).Errorf("ExportPRMissingReport scan 过程中发生错误: %v", err)
 			continue
 		}
 		rawResults = append(rawResults, r)
@@ -156,23 +133,18 @@ func ExportPRMissingReport(ctx context.Context, m *Metrics, db *sql.Conn) {
 	}
 
 	if err = rows.Err(); err != nil {
-		zap.S().Errorf("ExportPRMissingReport Err 过程中发生错误: %v", err)
-		return
+		logger(ctx).Errorf("ExportPRMissingReport Err 过程中发生错误: %v", err)
+		return err
 	}
 
-	allProjects, err := GetAllProjects(ctx, db, knowProjects)
+	allProjects, err := inst.GetAllProjects(ctx, db, knowProjects)
 	if err != nil {
-		zap.S().Errorf("ExportPRMissingReport GetAllProjects 过程中发生错误: %v", err)
-		return
-	}
-
-	// 初始化计数器
-	cntArr := make(map[string][3]int)
-	for project := range allProjects {
-		cntArr[project] = [3]int{0, 0, 0}
+		logger(ctx).Errorf("ExportPRMissingReport GetAllProjects 过程中发生错误: %v", err)
+		return err
 	}
 
 	// 填充数据, cntArr 中的数据是每一个project中每一个状态的丢失报告的pr的数量
+	cntArr := make(map[string][3]int)
 	for _, r := range rawResults {
 		if state, _ := strconv.Atoi(r.State); state >= 0 && state <= 2 {
 			arr := cntArr[r.Project]
@@ -181,23 +153,19 @@ func ExportPRMissingReport(ctx context.Context, m *Metrics, db *sql.Conn) {
 			cntArr[r.Project] = arr
 		}
 	}
+	setStateCounts(m.OneClickPRMissingReport, cntArr, allProjects)
 
-	for project, counts := range cntArr {
-		m.OneClickPRMissingReport.WithLabelValues("open", project).Set(float64(counts[0]))
-		m.OneClickPRMissingReport.WithLabelValues("merged", project).Set(float64(counts[1]))
-		m.OneClickPRMissingReport.WithLabelValues("declined", project).Set(float64(counts[2]))
-	}
-
-	return
+	return nil
 }
 
-func ExportPRNum(ctx context.Context, m *Metrics, db *sql.Conn) {
+func ExportPRNum(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	m := inst.m
 	m.OneClickPRNum.Reset()
-	pgsql := fmt.Sprintf("EXECUTE pr_counts_query(date_trunc('minute',current_timestamp AT TIME ZONE '%s'))", global.ServerConfig.Timezone)
+	pgsql := fmt.Sprintf("EXECUTE pr_counts_query(date_trunc('minute',current_timestamp AT TIME ZONE '%s'))", inst.currentConfig().Timezone)
 	rows, err := db.QueryContext(ctx, pgsql)
 	if err != nil {
-		zap.S().Errorf("ExportPRNum QueryContext 过程中发生错误: %v", err)
-		return
+		logger(ctx).Errorf("ExportPRNum QueryContext 过程中发生错误: %v", err)
+		return err
 	}
 	defer rows.Close()
 
@@ -207,31 +175,26 @@ func ExportPRNum(ctx context.Context, m *Metrics, db *sql.Conn) {
 	for rows.Next() {
 		var r PrNum
 		if err = rows.Scan(&r.State, &r.Project, &r.Count); err != nil {
-			zap.S().Errorf("ExportPRNum scan 过程中发生错误: %v", err)
+			logger(ctx).Errorf("ExportPRNum scan 过程中发生错误: %v", err)
 			continue
 		}
 		rawResults = append(rawResults, r)
 		knowProjects[r.Project] = struct{}{}
 	}
 	if err = rows.Err(); err != nil {
-		zap.S().Errorf("ExportPRNum Err 过程中发生错误: %v", err)
-		return
+		logger(ctx).Errorf("ExportPRNum Err 过程中发生错误: %v", err)
+		return err
 	}
 
 	// 获取所有项目
-	allProjects, err := GetAllProjects(ctx, db, knowProjects)
+	allProjects, err := inst.GetAllProjects(ctx, db, knowProjects)
 	if err != nil {
-		zap.S().Errorf("ExportPRNum GetAllProjects 过程中发生错误: %v", err)
-		return
-	}
-
-	// 初始化计数器
-	cntArr := make(map[string][3]int)
-	for project := range allProjects {
-		cntArr[project] = [3]int{0, 0, 0}
+		logger(ctx).Errorf("ExportPRNum GetAllProjects 过程中发生错误: %v", err)
+		return err
 	}
 
 	// 填充数据
+	cntArr := make(map[string][3]int)
 	for _, r := range rawResults {
 		if state, _ := strconv.Atoi(r.State); state >= 0 && state <= 2 {
 			arr := cntArr[r.Project]
@@ -240,195 +203,183 @@ func ExportPRNum(ctx context.Context, m *Metrics, db *sql.Conn) {
 			cntArr[r.Project] = arr
 		}
 	}
+	setStateCounts(m.OneClickPRNum, cntArr, allProjects)
 
-	for project, counts := range cntArr {
-		m.OneClickPRNum.WithLabelValues("open", project).Set(float64(counts[0]))
-		m.OneClickPRNum.WithLabelValues("merged", project).Set(float64(counts[1]))
-		m.OneClickPRNum.WithLabelValues("declined", project).Set(float64(counts[2]))
-	}
+	return nil
+}
 
+// 设置每个 project 每个 pr_state 的数量，allProjects 中没有数据的 project 补零
+func setStateCounts(g *instanceGauge, cntArr map[string][3]int, allProjects map[string]struct{}) {
+	for project := range allProjects {
+		counts := cntArr[project]
+		for state, label := range prStates {
+			g.WithLabelValues(label, project).Set(float64(counts[state]))
+		}
+	}
 }
 
-func ExportOpenPRReport(ctx context.Context, m *Metrics, db *sql.Conn) {
+func ExportOpenPRReport(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	m := inst.m
 	m.OneClickOpenPRReport.Reset()
 	pgsql := "EXECUTE open_pr_report_query"
 	rows, err := db.QueryContext(ctx, pgsql)
 	if err != nil {
-		zap.S().Errorf("ExportOpenPRReport QueryContext 过程中发生错误: %v", err)
-		return
+		logger(ctx).Errorf("ExportOpenPRReport QueryContext 过程中发生错误: %v", err)
+		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var r OpenPrReport
 		if err = rows.Scan(&r.StashRepo, &r.PrNo, &r.PresentReports, &r.Project, &r.ReportData); err != nil {
-			zap.S().Errorf("ExportOpenPRReport scan 过程中发生错误: %v", err)
+			logger(ctx).Errorf("ExportOpenPRReport scan 过程中发生错误: %v", err)
 			continue
 		}
 
-		validAppearance := 0 // 统计经过检验的报告的数量
-		presentReports := ""
-		if r.PresentReports.Valid {
-			presentReports = r.PresentReports.String
-		}
-
-		if presentReports != "" {
-			validAppearance = strings.Count(presentReports, ",") + 1
-		}
-
-		var codeCoverage string // 如果原始数据为null，codeCoverage直接返回为空
-		if !r.ReportData.Valid {
-			codeCoverage = ""
-		} else {
-			codeCoverage = ExtractCodeCoverage(r.ReportData.String)
-		}
-
-		m.OneClickOpenPRReport.WithLabelValues(
-			r.StashRepo,
-			r.PrNo,
-			strconv.Itoa(validAppearance),
-			presentReports,
-			r.Project,
-			codeCoverage).Set(float64(1))
+		setPRReport(m.OneClickOpenPRReport, r)
 	}
 
 	if err := rows.Err(); err != nil {
-		zap.S().Errorf("ExportOpenPRReport rows.Err 过程中发生错误: %v", err)
-		return
+		logger(ctx).Errorf("ExportOpenPRReport rows.Err 过程中发生错误: %v", err)
+		return err
 	}
 
+	return nil
 }
 
-func ExportClosedPRReport(ctx context.Context, m *Metrics, db *sql.Conn) {
-	m.OneClickOpenPRReport.Reset()
-	pgsql := fmt.Sprintf("EXECUTE closed_pr_report_query(date_trunc('minute',current_timestamp AT TIME ZONE '%s'))", global.ServerConfig.Timezone)
+func ExportClosedPRReport(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	m := inst.m
+	m.OneClickClosedPRReport.Reset()
+	pgsql := fmt.Sprintf("EXECUTE closed_pr_report_query(date_trunc('minute',current_timestamp AT TIME ZONE '%s'))", inst.currentConfig().Timezone)
 	rows, err := db.QueryContext(ctx, pgsql)
 	if err != nil {
-		zap.S().Errorf("ExportClosedPRReport QueryContext 过程中发生错误: %v", err)
-		return
+		logger(ctx).Errorf("ExportClosedPRReport QueryContext 过程中发生错误: %v", err)
+		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var r ClosedPrReport
 		if err = rows.Scan(&r.StashRepo, &r.PrNo, &r.PresentReports, &r.Project, &r.ReportData); err != nil {
-			zap.S().Errorf("ExportClosedPRReport scan 过程中发生错误: %v", err)
+			logger(ctx).Errorf("ExportClosedPRReport scan 过程中发生错误: %v", err)
 			continue
 		}
 
-		validAppearance := 0 // 统计经过检验的报告的数量
-		presentReports := ""
-		if r.PresentReports.Valid {
-			presentReports = r.PresentReports.String
-		}
+		setPRReport(m.OneClickClosedPRReport, r)
+	}
 
-		if presentReports != "" {
-			validAppearance = strings.Count(presentReports, ",") + 1
-		}
+	if err := rows.Err(); err != nil {
+		logger(ctx).Errorf("ExportClosedPRReport rows.Err 过程中发生错误: %v", err)
+		return err
+	}
 
-		var codeCoverage string // 如果原始数据为null，codeCoverage直接返回为空
-		if !r.ReportData.Valid {
-			codeCoverage = ""
-		} else {
-			codeCoverage = ExtractCodeCoverage(r.ReportData.String)
-		}
+	return nil
+}
 
-		m.OneClickClosedPRReport.WithLabelValues(
-			r.StashRepo,
-			r.PrNo,
-			strconv.Itoa(validAppearance),
-			presentReports,
-			r.Project,
-			codeCoverage).Set(float64(1))
+// 设置单个 pr 的报告详情，open 与最近关闭的 pr 共用
+func setPRReport(g *instanceGauge, r OpenPrReport) {
+	validAppearance := 0 // 统计经过检验的报告的数量
+	presentReports := ""
+	if r.PresentReports.Valid {
+		presentReports = r.PresentReports.String
 	}
 
-	if err := rows.Err(); err != nil {
-		zap.S().Errorf("ExportClosedPRReport rows.Err 过程中发生错误: %v", err)
-		return
+	if presentReports != "" {
+		validAppearance = strings.Count(presentReports, ",") + 1
 	}
+
+	var codeCoverage string // 如果原始数据为null，codeCoverage直接返回为空
+	if r.ReportData.Valid {
+		codeCoverage = ExtractCodeCoverage(r.ReportData.String)
+	}
+
+	g.WithLabelValues(
+		r.StashRepo,
+		r.PrNo,
+		strconv.Itoa(validAppearance),
+		presentReports,
+		r.Project,
+		codeCoverage).Set(float64(1))
 }
 
-func ExportResultReport(ctx context.Context, m *Metrics, db *sql.Conn) {
-	allReportKeys := []string{
-		"ci", "codecoverage", "sast", "smoke", "snyk", "sabug", "sasmell", "savul",
+func ExportResultReport(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	m := inst.m
+	reportKeys := inst.currentConfig().ReportKeys
+
+	// 一次查询得到所有报告类型的结果，counts[报告类型][项目] 依次为 failure、success、notAvailable 的数量
+	pgsql := fmt.Sprintf("EXECUTE result_report_query(%s)", dbase.TextArray(reportKeys))
+	rows, err := db.QueryContext(ctx, pgsql)
+	if err != nil {
+		logger(ctx).Errorf("ExportResultReport QueryContext 过程中发生错误: %v", err)
+		return err
 	}
-	// 用于收集所有项目
-	knownProjects := make(map[string]struct{})
-	reportResults := make(map[string][]ResultReport)
+	defer rows.Close()
 
-	for _, reportKey := range allReportKeys {
-		pgsql := fmt.Sprintf("EXECUTE result_report_query('%s')", reportKey)
-		rows, err := db.QueryContext(ctx, pgsql)
-		if err != nil {
-			zap.S().Errorf("ExportResultReport 查询 %s 时发生错误: %v", reportKey, err)
+	knownProjects := make(map[string]struct{})
+	counts := make(map[string]map[string][3]int)
+	for rows.Next() {
+		var r ResultReport
+		if err = rows.Scan(&r.Report, &r.Status, &r.Project, &r.Count); err != nil {
+			logger(ctx).Errorf("ExportResultReport scan 过程中发生错误: %v", err)
 			continue
 		}
-		defer rows.Close()
-		var results []ResultReport
-		for rows.Next() {
-			var r ResultReport
-			if err = rows.Scan(&r.Report, &r.Status, &r.Project, &r.Count); err != nil {
-				zap.S().Errorf("ExportResultReport 扫描 %s 时发生错误: %v", reportKey, err)
-				continue
-			}
-			results = append(results, r)
-			knownProjects[r.Project] = struct{}{}
+		knownProjects[r.Project] = struct{}{}
+		// 没有结果的报告和缺少的必需报告都计入 notAvailable
+		status := 2
+		if r.Status.Valid {
+			status = int(r.Status.Int64)
 		}
-		if err := rows.Err(); err != nil {
-			zap.S().Errorf("ExportResultReport rows.Err %s 时发生错误: %v", reportKey, err)
+		if status < 0 || status > 2 {
 			continue
 		}
-		reportResults[reportKey] = results
+		if counts[r.Report] == nil {
+			counts[r.Report] = make(map[string][3]int)
+		}
+		arr := counts[r.Report][r.Project]
+		arr[status] += r.Count
+		counts[r.Report][r.Project] = arr
+	}
+	if err = rows.Err(); err != nil {
+		logger(ctx).Errorf("ExportResultReport Err 过程中发生错误: %v", err)
+		return err
 	}
 
 	//获取所有项目
-	var err error
-	allProjects, err := GetAllProjects(ctx, db, knownProjects)
+	allProjects, err := inst.GetAllProjects(ctx, db, knownProjects)
 	if err != nil {
-		zap.S().Errorf("ExportResultReport 获取所有项目时发生错误: %v", err)
-		return
+		logger(ctx).Errorf("ExportResultReport 获取所有项目时发生错误: %v", err)
+		return err
 	}
 
 	m.OneClickResultReport.Reset()
+	setResultReport(m.OneClickResultReport, counts, reportKeys, allProjects)
 
-	for reportKey, results := range reportResults {
-		//初始化计数器
-		cntarr := make(map[string][3]int)
-		for project := range allProjects {
-			cntarr[project] = [3]int{0, 0, 0}
-		}
-
-		for _, r := range results {
-			status := 2
-			if r.Status.Valid {
-				status = int(r.Status.Int64)
-			}
-			if status >= 0 && status <= 2 {
-				arr := cntarr[r.Project]
-				arr[status] = r.Count
-				cntarr[r.Project] = arr
-			}
-		}
+	return nil
+}
 
+// 设置每种报告每个 project 的结果数量，counts[报告类型][项目] 依次为 failure、success、notAvailable 的数量
+func setResultReport(g *instanceGauge, counts map[string]map[string][3]int, reportKeys []string, allProjects map[string]struct{}) {
+	for _, reportKey := range reportKeys {
 		for project := range allProjects {
-			counts := cntarr[project]
-			total := counts[0] + counts[1]
+			arr := counts[reportKey][project]
+			total := arr[0] + arr[1]
 
-			m.OneClickResultReport.WithLabelValues(reportKey, "failure", project).Set(float64(counts[0]))
-			m.OneClickResultReport.WithLabelValues(reportKey, "success", project).Set(float64(counts[1]))
-			m.OneClickResultReport.WithLabelValues(reportKey, "total", project).Set(float64(total))
-			m.OneClickResultReport.WithLabelValues(reportKey, "notAvailable", project).Set(float64(counts[2]))
+			g.WithLabelValues(reportKey, "failure", project).Set(float64(arr[0]))
+			g.WithLabelValues(reportKey, "success", project).Set(float64(arr[1]))
+			g.WithLabelValues(reportKey, "total", project).Set(float64(total))
+			g.WithLabelValues(reportKey, "notAvailable", project).Set(float64(arr[2]))
 		}
 	}
 }
 
-func ExportCheckSummary(ctx context.Context, m *Metrics, db *sql.Conn) {
+func ExportCheckSummary(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	m := inst.m
 
 	// 获取所有项目
-	allProjects, err := GetAllProjects(ctx, db, map[string]struct{}{})
+	allProjects, err := inst.GetAllProjects(ctx, db, map[string]struct{}{})
 	if err != nil {
-		zap.S().Errorf("ExportCheckSummary 获取项目失败: %v", err)
-		return
+		logger(ctx).Errorf("ExportCheckSummary 获取项目失败: %v", err)
+		return err
 	}
 
 	// 构造项目列表字符串
@@ -436,48 +387,256 @@ func ExportCheckSummary(ctx context.Context, m *Metrics, db *sql.Conn) {
 	for project := range allProjects {
 		projectList = append(projectList, project)
 	}
+	// 固定顺序，相同的项目得到相同的语句，便于录制和回放
+	sort.Strings(projectList)
 	projectListStr := strings.Join(projectList, ",")
 
 	// 执行查询
 	query := fmt.Sprintf("EXECUTE check_summary_query('{%s}')", projectListStr)
 	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		zap.S().Errorf("ExportCheckSummary 查询失败: %v", err)
-		return
+		logger(ctx).Errorf("ExportCheckSummary 查询失败: %v", err)
+		return err
 	}
 	defer rows.Close()
 
 	m.OneClickCheckSummary.Reset()
 
+	var repos []RepoCheck
 	for rows.Next() {
 		var r CheckSummary
 		err = rows.Scan(&r.StashRepo, &r.PresrntReport, &r.Project)
 		if err != nil {
-			zap.S().Errorf("ExportCheckSummary 扫描失败: %v", err)
+			logger(ctx).Errorf("ExportCheckSummary 扫描失败: %v", err)
 			continue
 		}
+		repos = append(repos, setCheckSummary(inst, r))
+	}
+
+	if err = rows.Err(); err != nil {
+		logger(ctx).Errorf("ExportCheckSummary rows.Err过程中发生错误: %v", err)
+		return err
+	}
+
+	inst.setRepoChecks(repos)
+	return nil
+}
 
-		checkSummaryString := ""
-		if r.PresrntReport.Valid {
-			checkSummaryString = r.PresrntReport.String
+// 设置单个仓库启用的 merge check，返回 API 使用的仓库配置
+func setCheckSummary(inst *Instance, r CheckSummary) RepoCheck {
+	checkSummaryString := ""
+	if r.PresrntReport.Valid {
+		checkSummaryString = r.PresrntReport.String
+	}
+
+	validAppearance := 0
+	if checkSummaryString != "" {
+		validAppearance = strings.Count(checkSummaryString, ",") + 1
+	}
+
+	inst.m.OneClickCheckSummary.WithLabelValues(
+		r.StashRepo,
+		strconv.Itoa(validAppearance),
+		checkSummaryString,
+		r.Project,
+	).Set(float64(1))
+	return RepoCheck{Instance: inst.Name, Project: r.Project, Repo: r.StashRepo, EnabledChecks: splitReports(r.PresrntReport)}
+}
+
+// ExportComplianceRatio 根据同一次查询得到的每个 pr 的要求报告和已有报告计算合规率，
+// 保证分子和分母来自同一份快照
+func ExportComplianceRatio(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	m := inst.m
+	pgsql := fmt.Sprintf("EXECUTE pr_compliance_query(date_trunc('minute',current_timestamp AT TIME ZONE '%s'))", inst.currentConfig().Timezone)
+	rows, err := db.QueryContext(ctx, pgsql)
+	if err != nil {
+		logger(ctx).Errorf("ExportComplianceRatio QueryContext 过程中发生错误: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	var rawResults []PrCompliance
+	knowProjects := make(map[string]struct{})
+	for rows.Next() {
+		var r PrCompliance
+		if err = rows.Scan(&r.State, &r.Project, &r.RequiredReports, &r.PresentReports); err != nil {
+			logger(ctx).Errorf("ExportComplianceRatio scan 过程中发生错误: %v", err)
+			continue
 		}
+		rawResults = append(rawResults, r)
+		knowProjects[r.Project] = struct{}{}
+	}
+	if err = rows.Err(); err != nil {
+		logger(ctx).Errorf("ExportComplianceRatio Err 过程中发生错误: %v", err)
+		return err
+	}
 
-		validAppearance := 0
-		if checkSummaryString != "" {
-			validAppearance = strings.Count(checkSummaryString, ",") + 1
+	allProjects, err := inst.GetAllProjects(ctx, db, knowProjects)
+	if err != nil {
+		logger(ctx).Errorf("ExportComplianceRatio GetAllProjects 过程中发生错误: %v", err)
+		return err
+	}
+
+	m.OneClickComplianceRatio.Reset()
+	setComplianceRatio(m.OneClickComplianceRatio, rawResults, inst.currentConfig().ReportKeys, allProjects)
+
+	return nil
+}
+
+func setComplianceRatio(g *instanceGauge, rawResults []PrCompliance, reportKeys []string, allProjects map[string]struct{}) {
+	for project, counts := range countCompliance(rawResults, allProjects) {
+		for state, c := range counts {
+			setRatio(g, c.compliant, c.total, prStates[state], project, "all")
+			for _, reportKey := range reportKeys {
+				setRatio(g, c.present[reportKey], c.required[reportKey], prStates[state], project, reportKey)
+			}
 		}
+	}
+}
 
-		m.OneClickCheckSummary.WithLabelValues(
-			r.StashRepo,
-			strconv.Itoa(validAppearance),
-			checkSummaryString,
-			r.Project,
-		).Set(float64(1))
+// 统计每个 project 每个状态下的合规情况，没有数据的 project 也会补零
+func countCompliance(rawResults []PrCompliance, allProjects map[string]struct{}) map[string]*[3]complianceCount {
+	cntArr := make(map[string]*[3]complianceCount)
+	for project := range allProjects {
+		cntArr[project] = newComplianceCounts()
 	}
 
-	if err = rows.Err(); err != nil {
-		zap.S().Errorf("ExportCheckSummary rows.Err过程中发生错误: %v", err)
+	for _, r := range rawResults {
+		state, _ := strconv.Atoi(r.State)
+		if state < 0 || state > 2 {
+			continue
+		}
+		// 项目列表按源仓库统计，合规数据按目标仓库统计，不在列表中的项目在这里补上
+		counts, ok := cntArr[r.Project]
+		if !ok {
+			counts = newComplianceCounts()
+			cntArr[r.Project] = counts
+		}
+		c := &counts[state]
+
+		present := make(map[string]struct{})
+		if r.PresentReports.Valid && r.PresentReports.String != "" {
+			for _, key := range strings.Split(r.PresentReports.String, ",") {
+				present[key] = struct{}{}
+			}
+		}
+
+		// 没有配置 merge check 的仓库不要求任何报告，视为合规
+		compliant := true
+		if r.RequiredReports.Valid && r.RequiredReports.String != "" {
+			for _, key := range strings.Split(r.RequiredReports.String, ",") {
+				c.required[key]++
+				if _, ok := present[key]; ok {
+					c.present[key]++
+				} else {
+					compliant = false
+				}
+			}
+		}
+
+		c.total++
+		if compliant {
+			c.compliant++
+		}
+	}
+
+	return cntArr
+}
+
+func newComplianceCounts() *[3]complianceCount {
+	var counts [3]complianceCount
+	for i := range counts {
+		counts[i].required = make(map[string]int)
+		counts[i].present = make(map[string]int)
+	}
+	return &counts
+}
+
+// 导出比例。分母为 0 时没有需要检查的 pr，比例没有意义，不导出该序列，避免把没有 pr 的项目显示为完全合规
+func setRatio(g *instanceGauge, numerator, denominator int, lvs ...string) {
+	if denominator == 0 {
 		return
 	}
+	g.WithLabelValues(lvs...).Set(float64(numerator) / float64(denominator))
+}
+
+// ExportPRDetail 查询 open 与最近一分钟关闭的 pr 的报告详情，本轮结束后与 merge check 一起发布为 API 使用的快照
+func ExportPRDetail(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	pgsql := fmt.Sprintf("EXECUTE pr_detail_query(date_trunc('minute',current_timestamp AT TIME ZONE '%s'))", inst.currentConfig().Timezone)
+	rows, err := db.QueryContext(ctx, pgsql)
+	if err != nil {
+		logger(ctx).Errorf("ExportPRDetail QueryContext 过程中发生错误: %v", err)
+		return err
+	}
+	defer rows.Close()
 
+	var details []PRDetail
+	for rows.Next() {
+		var r PrDetailRow
+		if err = rows.Scan(&r.Project, &r.StashRepo, &r.PrNo, &r.State, &r.RequiredReports, &r.ReportResults, &r.ReportData); err != nil {
+			logger(ctx).Errorf("ExportPRDetail scan 过程中发生错误: %v", err)
+			continue
+		}
+		details = append(details, newPRDetail(inst.Name, r))
+	}
+	if err = rows.Err(); err != nil {
+		logger(ctx).Errorf("ExportPRDetail rows.Err 过程中发生错误: %v", err)
+		return err
+	}
+
+	inst.setPRDetails(details)
+	return nil
+}
+
+func newPRDetail(instance string, r PrDetailRow) PRDetail {
+	d := PRDetail{
+		Instance:        instance,
+		Project:         r.Project,
+		Repo:            r.StashRepo,
+		PrNo:            r.PrNo,
+		PresentReports:  []string{},
+		RequiredReports: splitReports(r.RequiredReports),
+		MissingReports:  []string{},
+		Results:         map[string]string{},
+	}
+	if state, err := strconv.Atoi(r.State); err == nil && state >= 0 && state <= 2 {
+		d.State = prStates[state]
+	}
+
+	// report_results 的格式为 key:result_id,key:result_id
+	for _, item := range splitReports(r.ReportResults) {
+		key, result, _ := strings.Cut(item, ":")
+		if _, ok := d.Results[key]; !ok {
+			d.PresentReports = append(d.PresentReports, key)
+		}
+		switch result {
+		case "0":
+			d.Results[key] = ResultFailure
+		case "1":
+			d.Results[key] = ResultSuccess
+		default:
+			d.Results[key] = ResultNotAvailable
+		}
+	}
+
+	for _, key := range d.RequiredReports {
+		if _, ok := d.Results[key]; !ok {
+			d.MissingReports = append(d.MissingReports, key)
+		}
+	}
+
+	if r.ReportData.Valid {
+		if v, err := strconv.ParseFloat(ExtractCodeCoverage(r.ReportData.String), 64); err == nil {
+			d.CodeCoverage = &v
+		}
+	}
+	return d
+}
+
+// 拆分以逗号分隔的报告列表，空值返回空切片
+func splitReports(s sql.NullString) []string {
+	if !s.Valid || s.String == "" {
+		return []string{}
+	}
+	return strings.Split(s.String, ",")
 }
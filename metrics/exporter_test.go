@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dbase "oneclick-metrics-go/db"
+	"oneclick-metrics-go/db/fake"
+	"reflect"
+	"testing"
+)
+
+// 覆盖率报告的 DATA 列，第一个 Code Coverage 为 85.5
+const coverageData = `[[{"title":"Tests","value":12},{"title":"Code Coverage","value":85.5}],[{"title":"Code Coverage","value":10}]]`
+
+// 每个采集任务在同一组项目上的结果：PROJ 和 OTHER 有数据，EMPTY 没有任何 pr，需要补零
+func TestExporters(t *testing.T) {
+	tests := []struct {
+		name   string
+		export func(ctx context.Context, inst *Instance, db dbase.Querier) error
+		gauge  func(m *Metrics) prometheus.Collector
+		rows   func(f *fake.DB)
+	}{
+		{
+			name:   "PRMissingReport",
+			export: ExportPRMissingReport,
+			gauge:  func(m *Metrics) prometheus.Collector { return m.OneClickPRMissingReport },
+			rows: func(f *fake.DB) {
+				// 列顺序为数量、pr_state、project；pr_state 超出范围的行忽略
+				f.Set("pr_missing_report_query",
+					[]any{"2", "0", "PROJ"},
+					[]any{"1", "2", "PROJ"},
+					[]any{"4", "1", "OTHER"},
+					[]any{"9", "3", "OTHER"})
+			},
+		},
+		{
+			name:   "PRNum",
+			export: ExportPRNum,
+			gauge:  func(m *Metrics) prometheus.Collector { return m.OneClickPRNum },
+			rows: func(f *fake.DB) {
+				// 列顺序为 pr_state、project、数量
+				f.Set("pr_counts_query",
+					[]any{"0", "PROJ", "3"},
+					[]any{"1", "PROJ", "5"},
+					[]any{"2", "OTHER", "1"})
+			},
+		},
+		{
+			name:   "OpenPRReport",
+			export: ExportOpenPRReport,
+			gauge:  func(m *Metrics) prometheus.Collector { return m.OneClickOpenPRReport },
+			rows: func(f *fake.DB) {
+				f.Set("open_pr_report_query",
+					[]any{"repo1", "1", "ci,codecoverage", "PROJ", coverageData},
+					[]any{"repo1", "2", nil, "PROJ", nil},
+					[]any{"repo2", "7", "ci", "OTHER", "not json"})
+			},
+		},
+		{
+			name:   "ClosedPRReport",
+			export: ExportClosedPRReport,
+			gauge:  func(m *Metrics) prometheus.Collector { return m.OneClickClosedPRReport },
+			rows: func(f *fake.DB) {
+				f.Set("closed_pr_report_query",
+					[]any{"repo1", "3", "ci", "PROJ", `[[{"title":"Code Coverage","value":70}]]`})
+			},
+		},
+		{
+			name:   "ResultReport",
+			export: ExportResultReport,
+			gauge:  func(m *Metrics) prometheus.Collector { return m.OneClickResultReport },
+			rows: func(f *fake.DB) {
+				// 列顺序为报告、结果、project、数量；结果为空时计入 notAvailable
+				f.Set("result_report_query",
+					[]any{"ci", 0, "PROJ", 1},
+					[]any{"ci", 1, "PROJ", 2},
+					[]any{"ci", nil, "PROJ", 3},
+					[]any{"codecoverage", 1, "OTHER", 4},
+					[]any{"sast", 1, "OTHER", 5})
+			},
+		},
+		{
+			name:   "CheckSummary",
+			export: ExportCheckSummary,
+			gauge:  func(m *Metrics) prometheus.Collector { return m.OneClickCheckSummary },
+			rows: func(f *fake.DB) {
+				f.SetStatement("EXECUTE check_summary_query('{EMPTY,OTHER,PROJ}')",
+					[]any{"repo1", "ci,codecoverage", "PROJ"},
+					[]any{"repo2", nil, "OTHER"})
+			},
+		},
+		{
+			name:   "ComplianceRatio",
+			export: ExportComplianceRatio,
+			gauge:  func(m *Metrics) prometheus.Collector { return m.OneClickComplianceRatio },
+			rows: func(f *fake.DB) {
+				// 列顺序为 pr_state、project、要求的报告、已有的报告；没有 merge check 的 pr 视为合规
+				f.Set("pr_compliance_query",
+					[]any{"0", "PROJ", "ci,codecoverage", "ci"},
+					[]any{"0", "PROJ", "ci", "ci,codecoverage"},
+					[]any{"0", "PROJ", nil, nil},
+					[]any{"1", "PROJ", "ci", nil},
+					[]any{"2", "OTHER", "codecoverage", "codecoverage"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			inst, m, f := newFakeInstance(t, "test")
+			f.Set(dbase.ProjectKeysQueryName, []any{"PROJ"}, []any{"OTHER"}, []any{"EMPTY"})
+			tt.rows(f)
+
+			if err := tt.export(context.Background(), inst, f); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, tt.gauge(m), tt.name)
+		})
+	}
+}
+
+func TestComplianceRatioSkipsEmptyDenominator(t *testing.T) {
+	useConfig(t, nil)
+	inst, m, f := newFakeInstance(t, "compliance-empty")
+
+	// EMPTY 项目没有 pr，PROJ 中唯一的 open pr 只要求 ci 且已提供
+	f.Set(dbase.ProjectKeysQueryName, []any{"PROJ"}, []any{"EMPTY"})
+	f.Set("pr_compliance_query", []any{"0", "PROJ", "ci", "ci"})
+	if err := ExportComplianceRatio(context.Background(), inst, f); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{
+		"pr_state=open,project=PROJ,report=all": 1,
+		"pr_state=open,project=PROJ,report=ci":  1,
+	}
+	if got := gaugeValues(t, m.OneClickComplianceRatio); !reflect.DeepEqual(got, want) {
+		t.Fatalf("分母为 0 的序列不应导出:\n got %v\nwant %v", got, want)
+	}
+}
+
+// 合规数据中的项目不在项目列表中时补上该项目，不能因为空指针中断整轮采集
+func TestCountComplianceUnknownProject(t *testing.T) {
+	rows := []PrCompliance{
+		{State: "0", Project: "FORK", RequiredReports: sql.NullString{String: "ci", Valid: true}},
+		{State: "0", Project: "PROJ", RequiredReports: sql.NullString{String: "ci", Valid: true}, PresentReports: sql.NullString{String: "ci", Valid: true}},
+	}
+	counts := countCompliance(rows, map[string]struct{}{"PROJ": {}})
+	if fork := counts["FORK"]; fork == nil || fork[0].total != 1 || fork[0].compliant != 0 {
+		t.Fatalf("FORK 的统计不符: %+v", fork)
+	}
+	if proj := counts["PROJ"]; proj[0].total != 1 || proj[0].compliant != 1 {
+		t.Fatalf("PROJ 的统计不符: %+v", proj)
+	}
+}
+
+func TestExtractCodeCoverage(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"空", "", ""},
+		{"不是 json", "not json", ""},
+		{"取第一个", coverageData, "85.50"},
+		{"整数", `[[{"title":"Code Coverage","value":70}]]`, "70.00"},
+		{"没有覆盖率", `[[{"title":"Tests","value":12}]]`, ""},
+		{"值不是数字", `[[{"title":"Code Coverage","value":"85%"}]]`, ""},
+		{"结构不符", `[{"title":"Code Coverage","value":85}]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractCodeCoverage(tt.raw); got != tt.want {
+				t.Fatalf("ExtractCodeCoverage(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+// ClosedPRReport 只清空最近关闭的 pr 的序列：两轮之间关闭的 pr 不再出现，open pr 的序列不受影响
+func TestClosedPRReportKeepsOpenSeries(t *testing.T) {
+	useConfig(t, nil)
+	inst, m, f := newFakeInstance(t, "closed-reset")
+	ctx := context.Background()
+
+	f.Set("open_pr_report_query", []any{"repo1", "1", "ci", "PROJ", nil})
+	f.Set("closed_pr_report_query", []any{"repo1", "2", "ci", "PROJ", nil})
+	for _, export := range []func(context.Context, *Instance, dbase.Querier) error{ExportOpenPRReport, ExportClosedPRReport} {
+		if err := export(ctx, inst, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 下一轮没有最近关闭的 pr，只执行 ClosedPRReport
+	f.Set("closed_pr_report_query")
+	if err := ExportClosedPRReport(ctx, inst, f); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(m.OneClickOpenPRReport); n != 1 {
+		t.Fatalf("ClosedPRReport 清空了 open pr 的序列，剩余 %d 条", n)
+	}
+	if n := testutil.CollectAndCount(m.OneClickClosedPRReport); n != 0 {
+		t.Fatalf("上一轮关闭的 pr 仍然导出，共 %d 条", n)
+	}
+}
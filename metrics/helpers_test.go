@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/db/fake"
+	"oneclick-metrics-go/global"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "用当前的输出更新 testdata 中的 golden 文件")
+
+// 测试使用的配置，modify 在发布前修改，测试结束后恢复原配置
+func useConfig(t *testing.T, modify func(cfg *config.ServerConfig)) *config.ServerConfig {
+	t.Helper()
+	cfg := &config.ServerConfig{
+		Timezone:   "UTC",
+		Interval:   15,
+		Engine:     config.EngineQueries,
+		ReportKeys: []string{"ci", "codecoverage"},
+	}
+	if modify != nil {
+		modify(cfg)
+	}
+	old := global.ServerConfig.Load()
+	global.ServerConfig.Store(cfg)
+	t.Cleanup(func() { global.ServerConfig.Store(old) })
+	return cfg
+}
+
+// 由假数据库回答查询的实例，使用独立的指标，测试结束后从 LatestSnapshot 中移除
+func newFakeInstance(t *testing.T, name string) (*Instance, *Metrics, *fake.DB) {
+	t.Helper()
+	m := SetupMetrics()
+	f := fake.New()
+	inst := NewReplayInstance(name, f, m)
+	t.Cleanup(func() {
+		inst.Close()
+		instancesLock.Lock()
+		defer instancesLock.Unlock()
+		instances = slices.DeleteFunc(instances, func(i *Instance) bool { return i == inst })
+	})
+	return inst, m, f
+}
+
+// 禁用 enabled 以外的所有采集任务
+func onlyCollectors(enabled ...string) func(cfg *config.ServerConfig) {
+	return func(cfg *config.ServerConfig) {
+		for _, name := range TaskNames() {
+			if !slices.Contains(enabled, name) {
+				cfg.DisabledCollectors = append(cfg.DisabledCollectors, name)
+			}
+		}
+	}
+}
+
+// 收集 c 的所有序列，键为按名称排序的 name=value 标签列表（不含 instance），值为序列的取值
+func gaugeValues(t *testing.T, c prometheus.Collector) map[string]float64 {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() != InstanceLabel {
+					labels = append(labels, pair.GetName()+"="+pair.GetValue())
+				}
+			}
+			values[strings.Join(labels, ",")] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+// 比较 c 导出的序列与 testdata/<name>.prom，-update 时先用当前的输出覆盖该文件
+func assertGolden(t *testing.T, c prometheus.Collector, name string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".prom")
+	if *update {
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(c)
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		for _, family := range families {
+			if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer golden.Close()
+	if err := testutil.CollectAndCompare(c, golden); err != nil {
+		t.Fatalf("与 %s 不一致（确认输出正确后使用 -update 更新）: %v", path, err)
+	}
+}
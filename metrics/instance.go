@@ -0,0 +1,300 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"oneclick-metrics-go/config"
+	dbase "oneclick-metrics-go/db"
+	"oneclick-metrics-go/db/fixture"
+	"oneclick-metrics-go/global"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InstanceLabel 区分 Bitbucket 实例的标签，所有指标都带有该标签。不能使用 instance：Prometheus 抓取时会添加同名的目标标签，
+// Pushgateway 的分组标签通常也是 instance，推送时会因为标签冲突被拒绝
+const InstanceLabel = "bitbucket_instance"
+
+// instanceGauge 一个实例下的指标：WithLabelValues 不需要传入 instance 标签，Reset 只删除该实例的序列
+type instanceGauge struct {
+	vec      *prometheus.GaugeVec
+	curried  *prometheus.GaugeVec
+	instance string
+}
+
+func newInstanceGauge(vec *prometheus.GaugeVec, instance string) *instanceGauge {
+	return &instanceGauge{
+		vec:      vec,
+		curried:  vec.MustCurryWith(prometheus.Labels{InstanceLabel: instance}),
+		instance: instance,
+	}
+}
+
+func (g *instanceGauge) WithLabelValues(lvs ...string) prometheus.Gauge {
+	return g.curried.WithLabelValues(lvs...)
+}
+
+// Reset 删除该实例的所有序列。curried vec 的 Reset 和空条件的 DeletePartialMatch 会删除所有实例的序列，不能使用
+func (g *instanceGauge) Reset() {
+	g.vec.DeletePartialMatch(prometheus.Labels{InstanceLabel: g.instance})
+}
+
+// instanceMetrics 与 Metrics 的字段一一对应，只作用于一个实例
+type instanceMetrics struct {
+	OneClickPRMissingReport *instanceGauge
+	OneClickPRNum           *instanceGauge
+	OneClickOpenPRReport    *instanceGauge
+	OneClickClosedPRReport  *instanceGauge
+	OneClickResultReport    *instanceGauge
+	OneClickCheckSummary    *instanceGauge
+	OneClickComplianceRatio *instanceGauge
+
+	CollectorUp          *instanceGauge
+	CollectorDuration    *instanceGauge
+	CollectorLastSuccess *instanceGauge
+	DatabaseInRecovery   *instanceGauge
+	ReplicaLag           *instanceGauge
+	CycleSkipped         *instanceGauge
+}
+
+func (m *Metrics) forInstance(name string) *instanceMetrics {
+	return &instanceMetrics{
+		OneClickPRMissingReport: newInstanceGauge(m.OneClickPRMissingReport, name),
+		OneClickPRNum:           newInstanceGauge(m.OneClickPRNum, name),
+		OneClickOpenPRReport:    newInstanceGauge(m.OneClickOpenPRReport, name),
+		OneClickClosedPRReport:  newInstanceGauge(m.OneClickClosedPRReport, name),
+		OneClickResultReport:    newInstanceGauge(m.OneClickResultReport, name),
+		OneClickCheckSummary:    newInstanceGauge(m.OneClickCheckSummary, name),
+		OneClickComplianceRatio: newInstanceGauge(m.OneClickComplianceRatio, name),
+		CollectorUp:             newInstanceGauge(m.CollectorUp, name),
+		CollectorDuration:       newInstanceGauge(m.CollectorDuration, name),
+		CollectorLastSuccess:    newInstanceGauge(m.CollectorLastSuccess, name),
+		DatabaseInRecovery:      newInstanceGauge(m.DatabaseInRecovery, name),
+		ReplicaLag:              newInstanceGauge(m.ReplicaLag, name),
+		CycleSkipped:            newInstanceGauge(m.CycleSkipped, name),
+	}
+}
+
+// Instance 一个 Bitbucket 实例的采集状态。每个实例有独立的连接池、任务连接、配置、项目缓存和快照，
+// 一个实例连接失败或查询变慢不会影响其他实例
+type Instance struct {
+	Name string
+	// 连接池，启动时无法打开（例如获取数据库密码失败）时为空，每轮采集开始时通过 open 重新打开
+	DB   *sql.DB
+	open func() (*sql.DB, error)
+
+	m *instanceMetrics
+
+	// 执行一轮采集以及热加载替换本实例配置时持有，热加载只等待本实例当前这一轮结束
+	mu sync.Mutex
+	// 本实例使用的配置，与任务连接上 prepare 的语句一致。只在持有 mu 时替换，一轮采集中不会变化
+	cfg atomic.Pointer[config.ServerConfig]
+	// 与 Tasks() 一一对应的连接及其建立时间，只在持有 mu 时访问
+	conns     []*sql.Conn
+	connSince []time.Time
+	// 热加载修改 interval 后通知采集循环重新计时
+	intervalChanged chan struct{}
+
+	// 回放模式下代替数据库回答查询，此时 DB 为空
+	replay dbase.Querier
+	// 不为空时录制每个采集任务的查询结果
+	recorder *fixture.Recorder
+
+	projects     map[string]struct{}
+	projectsLock sync.Mutex
+
+	snapshot     Snapshot
+	snapshotLock sync.RWMutex
+	// 本轮采集中 PRDetail 和 CheckSummary 写入的数据，本轮结束后整体发布为 snapshot
+	next snapshotBuilder
+}
+
+var (
+	instances     []*Instance
+	instancesLock sync.RWMutex
+)
+
+// NewInstance 创建实例，实例的快照会出现在 LatestSnapshot 中
+func NewInstance(name string, db *sql.DB, m *Metrics) *Instance {
+	inst := &Instance{
+		Name:            name,
+		DB:              db,
+		m:               m.forInstance(name),
+		intervalChanged: make(chan struct{}, 1),
+		projects:        make(map[string]struct{}),
+		snapshot:        Snapshot{Instance: name},
+	}
+	inst.cfg.Store(global.ServerConfig.Load())
+	instancesLock.Lock()
+	defer instancesLock.Unlock()
+	instances = append(instances, inst)
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	return inst
+}
+
+// OpenInstance 创建实例并用 open 打开连接池。open 没有返回连接池时记录错误，
+// 之后每轮采集开始时重新打开，其他实例照常采集
+func OpenInstance(name string, open func() (*sql.DB, error), m *Metrics) *Instance {
+	inst := NewInstance(name, nil, m)
+	inst.open = open
+	if err := inst.ensureDB(); err != nil {
+		inst.logger().Errorf("%v，将在采集时重试", err)
+	}
+	return inst
+}
+
+// 连接池未打开时重新打开。open 返回连接池和错误时（例如首次连接失败），连接池仍然可用，之后的请求会重新连接
+func (inst *Instance) ensureDB() error {
+	if inst.DB != nil || inst.replay != nil {
+		return nil
+	}
+	if inst.open == nil {
+		return errors.New("没有可用的数据库连接池")
+	}
+	db, err := inst.open()
+	if db == nil {
+		return err
+	}
+	inst.DB = db
+	if err != nil {
+		inst.logger().Errorf("%v，将在采集时重试", err)
+	} else {
+		inst.logger().Infof("数据库连接成功！")
+	}
+	return nil
+}
+
+// 本实例当前使用的配置。采集任务在一轮采集中读取到的总是同一份配置
+func (inst *Instance) currentConfig() *config.ServerConfig {
+	return inst.cfg.Load()
+}
+
+// NewReplayInstance 创建回放实例，采集任务的查询由 q 回答，不连接数据库
+func NewReplayInstance(name string, q dbase.Querier, m *Metrics) *Instance {
+	inst := NewInstance(name, nil, m)
+	inst.replay = q
+	return inst
+}
+
+// Record 录制之后每个采集任务的查询结果
+func (inst *Instance) Record(r *fixture.Recorder) {
+	inst.recorder = r
+}
+
+// Close 关闭实例的连接池
+func (inst *Instance) Close() error {
+	if inst.replay != nil {
+		if c, ok := inst.replay.(io.Closer); ok {
+			return c.Close()
+		}
+		return nil
+	}
+	if inst.DB == nil {
+		return nil
+	}
+	return inst.DB.Close()
+}
+
+// 清空项目缓存，项目过滤条件变化后下次采集重新查询
+func (inst *Instance) resetProjects() {
+	inst.projectsLock.Lock()
+	defer inst.projectsLock.Unlock()
+	inst.projects = make(map[string]struct{})
+}
+
+// GetAllProjects 从数据库中获取所有项目的名称，结果缓存在实例中，并合并 knownProjects
+func (inst *Instance) GetAllProjects(ctx context.Context, db dbase.Querier, knownProjects map[string]struct{}) (map[string]struct{}, error) {
+	inst.projectsLock.Lock()
+	defer inst.projectsLock.Unlock()
+	// 如果缓存为空，则从数据库查询
+	if len(inst.projects) == 0 {
+		rows, err := db.QueryContext(ctx, dbase.ProjectKeysQuery(inst.currentConfig()))
+		if err != nil {
+			logger(ctx).Errorf("获取项目名称过程中有误: %v", err)
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var projectKey string
+			if err = rows.Scan(&projectKey); err != nil {
+				logger(ctx).Errorf("浏览项目key时出错: %v", err)
+				continue
+			}
+			inst.projects[projectKey] = struct{}{}
+		}
+
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 合并 knownProjects
+	for k := range knownProjects {
+		inst.projects[k] = struct{}{}
+	}
+
+	// 返回副本，避免外部 goroutine 访问共享 map
+	result := make(map[string]struct{}, len(inst.projects))
+	for k := range inst.projects {
+		result[k] = struct{}{}
+	}
+
+	return result, nil
+}
+
+// Snapshot 返回实例最近一轮采集的快照，调用方不能修改其中的数据
+func (inst *Instance) Snapshot() Snapshot {
+	inst.snapshotLock.RLock()
+	defer inst.snapshotLock.RUnlock()
+	return inst.snapshot
+}
+
+// snapshotBuilder 收集一轮采集中各任务写入的快照数据
+type snapshotBuilder struct {
+	mu       sync.Mutex
+	prs      []PRDetail
+	repos    []RepoCheck
+	prsSet   bool
+	reposSet bool
+}
+
+// 开始新一轮采集，清空上一轮写入的数据
+func (inst *Instance) beginSnapshot() {
+	inst.next.mu.Lock()
+	defer inst.next.mu.Unlock()
+	inst.next.prs, inst.next.repos = nil, nil
+	inst.next.prsSet, inst.next.reposSet = false, false
+}
+
+// 写入本轮的 pr 详情
+func (inst *Instance) setPRDetails(prs []PRDetail) {
+	inst.next.mu.Lock()
+	defer inst.next.mu.Unlock()
+	inst.next.prs, inst.next.prsSet = prs, true
+}
+
+// 写入本轮仓库的 merge check 配置
+func (inst *Instance) setRepoChecks(repos []RepoCheck) {
+	inst.next.mu.Lock()
+	defer inst.next.mu.Unlock()
+	inst.next.repos, inst.next.reposSet = repos, true
+}
+
+// 在本轮所有任务完成后整体替换快照，UpdatedAt 为本轮开始的时间。未禁用的 PRDetail 或 CheckSummary
+// 本轮失败时保留上一轮的快照，读取方看到的 pr 列表和 merge check 总是来自同一轮采集
+func (inst *Instance) publishSnapshot(cfg *config.ServerConfig, at time.Time) {
+	inst.next.mu.Lock()
+	defer inst.next.mu.Unlock()
+	if (!inst.next.prsSet && !taskDisabled(cfg, "PRDetail")) || (!inst.next.reposSet && !taskDisabled(cfg, "CheckSummary")) {
+		return
+	}
+	inst.snapshotLock.Lock()
+	defer inst.snapshotLock.Unlock()
+	inst.snapshot = Snapshot{Instance: inst.Name, UpdatedAt: at, PRs: inst.next.prs, Repos: inst.next.repos}
+}
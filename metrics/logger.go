@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"context"
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// 实例的 logger，日志带有 instance 字段
+func (inst *Instance) logger() *zap.SugaredLogger {
+	return zap.S().With("instance", inst.Name)
+}
+
+// 采集任务的 logger，日志带有 instance、collector 和 query 字段，便于在日志平台中按任务过滤
+func (inst *Instance) taskLogger(task MetricTask) *zap.SugaredLogger {
+	return inst.logger().With("collector", task.Name, "query", task.QueryKey)
+}
+
+func withLogger(ctx context.Context, l *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// 返回 runTask 放入 ctx 的任务 logger，没有时返回全局 logger
+func logger(ctx context.Context) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return l
+	}
+	return zap.S()
+}
@@ -3,11 +3,106 @@ package metrics
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"sort"
 	"sync"
 )
 
 var wg sync.WaitGroup
 
+// MetricDef 描述一个指标的名称、说明和标签，SetupMetrics 与 dashboard 等生成工具共用同一份定义
+type MetricDef struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+var (
+	PRMissingReportDef = MetricDef{
+		Name:   "oneclick_pr_missing_report",
+		Help:   "The number of pull requests in one project with missing reports",
+		Labels: []string{InstanceLabel, "pr_state", "project"},
+	}
+	PRNumDef = MetricDef{
+		Name:   "oneclick_pr_num",
+		Help:   "The number of pull requests in one project by state",
+		Labels: []string{InstanceLabel, "pr_state", "project"},
+	}
+	OpenPRReportDef = MetricDef{
+		Name:   "oneclick_open_pr_report",
+		Help:   "Report detail for open state pull requests by pull request id",
+		Labels: []string{InstanceLabel, "stash_repo", "pr_no", "valid_appearance", "present_reports", "project", "code_coverage"},
+	}
+	ClosedPRReportDef = MetricDef{
+		Name:   "oneclick_closed_pr_report",
+		Help:   "Report detail for recent one minute closed pull requests by pull request id",
+		Labels: []string{InstanceLabel, "stash_repo", "pr_no", "valid_appearance", "present_reports", "project", "code_coverage"},
+	}
+	ResultReportDef = MetricDef{
+		Name:   "oneclick_result_report",
+		Help:   "The number of reports for open state pull requests in one project by report result category",
+		Labels: []string{InstanceLabel, "report", "status", "project"},
+	}
+	CheckSummaryDef = MetricDef{
+		Name:   "oneclick_check_summary",
+		Help:   "Report detail for enabled code insight checks by stash_repo",
+		Labels: []string{InstanceLabel, "stash_repo", "valid_appearance", "enabled_checks", "project"},
+	}
+	ComplianceRatioDef = MetricDef{
+		Name:   "oneclick_pr_compliance_ratio",
+		Help:   "The ratio of pull requests in one project carrying all required reports, by state and report key",
+		Labels: []string{InstanceLabel, "pr_state", "project", "report"},
+	}
+	CollectorUpDef = MetricDef{
+		Name:   "oneclick_collector_up",
+		Help:   "Whether the last run of the collector succeeded (1) or failed (0)",
+		Labels: []string{InstanceLabel, "collector"},
+	}
+	CollectorDurationDef = MetricDef{
+		Name:   "oneclick_collector_duration_seconds",
+		Help:   "Duration of the last run of the collector in seconds",
+		Labels: []string{InstanceLabel, "collector"},
+	}
+	CollectorLastSuccessDef = MetricDef{
+		Name:   "oneclick_collector_last_success_timestamp_seconds",
+		Help:   "Unix timestamp of the last successful run of the collector",
+		Labels: []string{InstanceLabel, "collector"},
+	}
+	DatabaseInRecoveryDef = MetricDef{
+		Name:   "oneclick_database_in_recovery",
+		Help:   "Whether the database the collector reads from is a standby (1) or a primary (0)",
+		Labels: []string{InstanceLabel},
+	}
+	ReplicaLagDef = MetricDef{
+		Name:   "oneclick_replica_lag_seconds",
+		Help:   "Replication lag of the standby the collector reads from in seconds, 0 on a primary",
+		Labels: []string{InstanceLabel},
+	}
+	CycleSkippedDef = MetricDef{
+		Name:   "oneclick_collection_skipped",
+		Help:   "Whether the last collection cycle was skipped because replica lag exceeded max_replica_lag (1) or not (0)",
+		Labels: []string{InstanceLabel},
+	}
+)
+
+// Definitions 返回 exporter 注册的全部指标定义
+func Definitions() []MetricDef {
+	return []MetricDef{
+		PRMissingReportDef,
+		PRNumDef,
+		OpenPRReportDef,
+		ClosedPRReportDef,
+		ResultReportDef,
+		CheckSummaryDef,
+		ComplianceRatioDef,
+		CollectorUpDef,
+		CollectorDurationDef,
+		CollectorLastSuccessDef,
+		DatabaseInRecoveryDef,
+		ReplicaLagDef,
+		CycleSkippedDef,
+	}
+}
+
 type Metrics struct {
 	OneClickPRMissingReport *prometheus.GaugeVec
 	OneClickPRNum           *prometheus.GaugeVec
@@ -15,63 +110,81 @@ type Metrics struct {
 	OneClickClosedPRReport  *prometheus.GaugeVec
 	OneClickResultReport    *prometheus.GaugeVec
 	OneClickCheckSummary    *prometheus.GaugeVec
+	OneClickComplianceRatio *prometheus.GaugeVec
+
+	// exporter 自身的运行状态
+	CollectorUp          *prometheus.GaugeVec
+	CollectorDuration    *prometheus.GaugeVec
+	CollectorLastSuccess *prometheus.GaugeVec
+	DatabaseInRecovery   *prometheus.GaugeVec
+	ReplicaLag           *prometheus.GaugeVec
+	CycleSkipped         *prometheus.GaugeVec
+}
+
+func newGaugeVec(def MetricDef) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: def.Name,
+			Help: def.Help,
+		},
+		def.Labels,
+	)
 }
 
 func SetupMetrics() *Metrics {
 	return &Metrics{
-		OneClickPRMissingReport: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "oneclick_pr_missing_report",
-				Help: "The number of pull requests in one project with missing reports",
-			},
-			[]string{"pr_state", "project"},
-		),
-		OneClickPRNum: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "oneclick_pr_num",
-				Help: "The number of pull requests in one project by state",
-			},
-			[]string{"pr_state", "project"},
-		),
-		OneClickOpenPRReport: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "oneclick_open_pr_report",
-				Help: "Report detail for open state pull requests by pull request id",
-			},
-			[]string{"stash_repo", "pr_no", "valid_appearance", "present_reports", "project", "code_coverage"},
-		),
-		OneClickClosedPRReport: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "oneclick_closed_pr_report",
-				Help: "Report detail for recent one minute closed pull requests by pull request id",
-			},
-			[]string{"stash_repo", "pr_no", "valid_appearance", "present_reports", "project", "code_coverage"},
-		),
-		OneClickResultReport: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "oneclick_result_report",
-				Help: "The number of reports for open state pull requests in one project by report result category",
-			},
-			[]string{"report", "status", "project"},
-		),
-		OneClickCheckSummary: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "oneclick_check_summary",
-				Help: "Report detail for enabled code insight checks by stash_repo",
-			},
-			[]string{"stash_repo", "valid_appearance", "enabled_checks", "project"},
-		),
+		OneClickPRMissingReport: newGaugeVec(PRMissingReportDef),
+		OneClickPRNum:           newGaugeVec(PRNumDef),
+		OneClickOpenPRReport:    newGaugeVec(OpenPRReportDef),
+		OneClickClosedPRReport:  newGaugeVec(ClosedPRReportDef),
+		OneClickResultReport:    newGaugeVec(ResultReportDef),
+		OneClickCheckSummary:    newGaugeVec(CheckSummaryDef),
+		OneClickComplianceRatio: newGaugeVec(ComplianceRatioDef),
+		CollectorUp:             newGaugeVec(CollectorUpDef),
+		CollectorDuration:       newGaugeVec(CollectorDurationDef),
+		CollectorLastSuccess:    newGaugeVec(CollectorLastSuccessDef),
+		DatabaseInRecovery:      newGaugeVec(DatabaseInRecoveryDef),
+		ReplicaLag:              newGaugeVec(ReplicaLagDef),
+		CycleSkipped:            newGaugeVec(CycleSkippedDef),
 	}
 }
 
-func RegisterMetrics(m *Metrics) {
-	prometheus.MustRegister(
+// LabelNames 返回所有指标使用的标签名称，已排序
+func LabelNames() []string {
+	set := map[string]bool{}
+	for _, def := range Definitions() {
+		for _, l := range def.Labels {
+			set[l] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for l := range set {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collectors 返回需要注册的全部指标
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
 		m.OneClickPRMissingReport,
 		m.OneClickPRNum,
 		m.OneClickOpenPRReport,
 		m.OneClickClosedPRReport,
 		m.OneClickResultReport,
 		m.OneClickCheckSummary,
-	)
+		m.OneClickComplianceRatio,
+		m.CollectorUp,
+		m.CollectorDuration,
+		m.CollectorLastSuccess,
+		m.DatabaseInRecovery,
+		m.ReplicaLag,
+		m.CycleSkipped,
+	}
+}
+
+func RegisterMetrics(m *Metrics) {
+	prometheus.MustRegister(m.Collectors()...)
 	zap.S().Info("Registered Prometheus metrics")
 }
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"oneclick-metrics-go/config"
+	dbase "oneclick-metrics-go/db"
+	"oneclick-metrics-go/db/fake"
+	"reflect"
+	"testing"
+)
+
+// 一致性测试的数据：
+//
+//	repo1 (PROJ)  merge check: ci, codecoverage, sast（sast 不在 report_keys 中）
+//	repo2 (PROJ)  没有 merge check
+//	repo3 (OTHER) merge check: ci
+//	repo4 (EMPTY) merge check: ci，没有 pr
+//
+//	#1 repo1 open      ci=1, codecoverage=0（带覆盖率数据）  报告齐全
+//	#2 repo1 open      ci=0                                   缺少 codecoverage
+//	#3 repo1 open      没有报告                               缺少 ci、codecoverage
+//	#4 repo2 open      ci 没有结果                            不要求报告
+//	#5 repo3 merged    ci=1，最近一分钟关闭                    报告齐全
+//	#6 repo3 declined  没有报告，最近一分钟关闭                缺少 ci
+//	#7 repo3 open      只有 sast                              缺少 ci
+//
+// unified 方式的两个查询返回原始数据，queries 方式的各查询返回按 SQL 的语义在同一份数据上手工计算的结果，
+// 行的顺序与查询的 ORDER BY 相同
+const parityCoverage = `[{"title":"Code Coverage","value":81.5}]`
+
+func setParityTick(f *fake.DB) {
+	// id, project, repo id, repo, pr 编号, pr_state, 最近一分钟关闭, 未关闭, 报告, 结果, DATA
+	f.Set(dbase.TickPRsQueryName,
+		[]any{15, "OTHER", 3, "repo3", "5", 1, true, false, "ci", 1, nil},
+		[]any{16, "OTHER", 3, "repo3", "6", 2, true, false, nil, nil, nil},
+		[]any{17, "OTHER", 3, "repo3", "7", 0, false, true, "sast", 1, nil},
+		[]any{11, "PROJ", 1, "repo1", "1", 0, false, true, "ci", 1, nil},
+		[]any{11, "PROJ", 1, "repo1", "1", 0, false, true, "codecoverage", 0, parityCoverage},
+		[]any{12, "PROJ", 1, "repo1", "2", 0, false, true, "ci", 0, nil},
+		[]any{13, "PROJ", 1, "repo1", "3", 0, false, true, nil, nil, nil},
+		[]any{14, "PROJ", 2, "repo2", "4", 0, false, true, "ci", nil, nil})
+	// repo id, project, repo, merge check 要求的报告
+	f.Set(dbase.TickChecksQueryName,
+		[]any{4, "EMPTY", "repo4", "ci"},
+		[]any{3, "OTHER", "repo3", "ci"},
+		[]any{1, "PROJ", "repo1", "ci"},
+		[]any{1, "PROJ", "repo1", "codecoverage"},
+		[]any{1, "PROJ", "repo1", "sast"},
+		[]any{2, "PROJ", "repo2", nil})
+}
+
+func setParityQueries(f *fake.DB) {
+	data := "[" + parityCoverage + "]"
+	// part 1：#2（PROJ open）、#7（OTHER open）；part 2：#3（PROJ open）、#6（OTHER declined）
+	f.Set("pr_missing_report_query",
+		[]any{"2", "0", "PROJ"},
+		[]any{"1", "0", "OTHER"},
+		[]any{"1", "2", "OTHER"})
+	f.Set("pr_counts_query",
+		[]any{"0", "PROJ", "4"},
+		[]any{"0", "OTHER", "1"},
+		[]any{"1", "OTHER", "1"},
+		[]any{"2", "OTHER", "1"})
+	f.Set("open_pr_report_query",
+		[]any{"repo1", "1", "ci,codecoverage", "PROJ", data},
+		[]any{"repo1", "2", "ci", "PROJ", nil},
+		[]any{"repo1", "3", nil, "PROJ", nil},
+		[]any{"repo2", "4", "ci", "PROJ", nil},
+		[]any{"repo3", "7", nil, "OTHER", nil})
+	f.Set("closed_pr_report_query",
+		[]any{"repo3", "5", "ci", "OTHER", nil},
+		[]any{"repo3", "6", nil, "OTHER", nil})
+	// 已有的报告按 (pr, 报告, 结果) 去重计数，缺少的必需报告计入结果 2
+	f.Set("result_report_query",
+		[]any{"ci", 0, "PROJ", 1},
+		[]any{"ci", 1, "PROJ", 1},
+		[]any{"ci", nil, "PROJ", 1},
+		[]any{"codecoverage", 0, "PROJ", 1},
+		[]any{"ci", 2, "PROJ", 1},
+		[]any{"ci", 2, "OTHER", 1},
+		[]any{"codecoverage", 2, "PROJ", 2})
+	f.SetStatement("EXECUTE check_summary_query('{EMPTY,OTHER,PROJ}')",
+		[]any{"repo4", "ci", "EMPTY"},
+		[]any{"repo3", "ci", "OTHER"},
+		[]any{"repo1", "ci,codecoverage,sast", "PROJ"},
+		[]any{"repo2", nil, "PROJ"})
+	f.Set("pr_compliance_query",
+		[]any{"0", "PROJ", "ci,codecoverage", "ci,codecoverage"},
+		[]any{"0", "PROJ", "ci,codecoverage", "ci"},
+		[]any{"0", "PROJ", "ci,codecoverage", nil},
+		[]any{"0", "PROJ", nil, "ci"},
+		[]any{"1", "OTHER", "ci", "ci"},
+		[]any{"2", "OTHER", "ci", nil},
+		[]any{"0", "OTHER", "ci", nil})
+	f.Set("pr_detail_query",
+		[]any{"OTHER", "repo3", "5", "1", "ci", "ci:1", nil},
+		[]any{"OTHER", "repo3", "6", "2", "ci", nil, nil},
+		[]any{"OTHER", "repo3", "7", "0", "ci", nil, nil},
+		[]any{"PROJ", "repo1", "1", "0", "ci,codecoverage", "ci:1,codecoverage:0", data},
+		[]any{"PROJ", "repo1", "2", "0", "ci,codecoverage", "ci:0", nil},
+		[]any{"PROJ", "repo1", "3", "0", "ci,codecoverage", nil, nil},
+		[]any{"PROJ", "repo2", "4", "0", nil, "ci:", nil})
+}
+
+// 同一份数据分别由 queries 和 unified 方式采集，导出的指标和快照相同
+func TestEngineParity(t *testing.T) {
+	collect := func(t *testing.T, engine string, set func(f *fake.DB)) (*Metrics, Snapshot) {
+		useConfig(t, func(cfg *config.ServerConfig) { cfg.Engine = engine })
+		inst, m, f := newFakeInstance(t, "parity")
+		f.Set(dbase.ProjectKeysQueryName, []any{"PROJ"}, []any{"OTHER"}, []any{"EMPTY"})
+		set(f)
+		if err := CollectOnce(context.Background(), []*Instance{inst}); err != nil {
+			t.Fatal(err)
+		}
+		return m, inst.Snapshot()
+	}
+	queries, queriesSnapshot := collect(t, config.EngineQueries, setParityQueries)
+	unified, unifiedSnapshot := collect(t, config.EngineUnified, setParityTick)
+
+	gauges := []struct {
+		name string
+		get  func(m *Metrics) prometheus.Collector
+	}{
+		{"PRMissingReport", func(m *Metrics) prometheus.Collector { return m.OneClickPRMissingReport }},
+		{"PRNum", func(m *Metrics) prometheus.Collector { return m.OneClickPRNum }},
+		{"OpenPRReport", func(m *Metrics) prometheus.Collector { return m.OneClickOpenPRReport }},
+		{"ClosedPRReport", func(m *Metrics) prometheus.Collector { return m.OneClickClosedPRReport }},
+		{"ResultReport", func(m *Metrics) prometheus.Collector { return m.OneClickResultReport }},
+		{"CheckSummary", func(m *Metrics) prometheus.Collector { return m.OneClickCheckSummary }},
+		{"ComplianceRatio", func(m *Metrics) prometheus.Collector { return m.OneClickComplianceRatio }},
+	}
+	for _, g := range gauges {
+		want, got := gaugeValues(t, g.get(queries)), gaugeValues(t, g.get(unified))
+		if len(want) == 0 {
+			t.Errorf("%s: queries 方式没有导出任何序列", g.name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s 不一致:\nunified %v\nqueries %v", g.name, got, want)
+		}
+	}
+
+	if !reflect.DeepEqual(unifiedSnapshot.PRs, queriesSnapshot.PRs) {
+		t.Errorf("PR 详情不一致:\nunified %+v\nqueries %+v", unifiedSnapshot.PRs, queriesSnapshot.PRs)
+	}
+	if !reflect.DeepEqual(unifiedSnapshot.Repos, queriesSnapshot.Repos) {
+		t.Errorf("merge check 不一致:\nunified %+v\nqueries %+v", unifiedSnapshot.Repos, queriesSnapshot.Repos)
+	}
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"oneclick-metrics-go/config"
+	dbase "oneclick-metrics-go/db"
+	"oneclick-metrics-go/global"
+	"reflect"
+)
+
+// ReloadRequest 一次配置热加载请求，由采集循环在两轮采集之间应用，结果写入 Done
+type ReloadRequest struct {
+	Config *config.ServerConfig
+	Done   chan error
+}
+
+// 依次在每个实例的两轮采集之间替换该实例的配置，并重新 prepare 语句发生变化的查询，只等待该实例当前这一轮结束，
+// 不会被其他实例卡住的查询阻塞。任一实例 prepare 失败时已经替换的实例恢复原配置和原语句。
+// 连接已经失效的任务直接关闭连接，下一轮采集时按新配置重连。全部实例替换完成后再发布为全局配置
+func applyReload(ctx context.Context, instances []*Instance, newCfg *config.ServerConfig) error {
+	tasks := Tasks()
+	old := global.ServerConfig.Load()
+	before := preparedStatements(old, tasks)
+	after := preparedStatements(newCfg, tasks)
+
+	var changed []int
+	for i := range tasks {
+		if before[i] != after[i] {
+			changed = append(changed, i)
+		}
+	}
+
+	for n, inst := range instances {
+		if err := inst.applyConfig(ctx, newCfg, tasks, changed); err != nil {
+			// 失败的实例上可能已有部分查询按新配置 prepare，同样需要恢复
+			for _, rb := range instances[:n+1] {
+				if rbErr := rb.applyConfig(ctx, old, tasks, changed); rbErr != nil {
+					rb.logger().Errorf("回滚时重新 prepare 查询失败: %v", rbErr)
+				}
+			}
+			return fmt.Errorf("[%s] 重新 prepare 查询失败，已回滚到原配置: %w", inst.Name, err)
+		}
+	}
+	global.ServerConfig.Store(newCfg)
+	zap.S().Infof("配置已重新加载，%d 个实例上 %d 个查询重新 prepare", len(instances), len(changed))
+	return nil
+}
+
+// 等待本实例当前这一轮采集结束后替换配置：在任务连接上重新 prepare indexes 中的查询，
+// 项目过滤条件变化时清空项目缓存，interval 变化时通知采集循环重新计时
+func (inst *Instance) applyConfig(ctx context.Context, cfg *config.ServerConfig, tasks []MetricTask, indexes []int) error {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, cycleTimeout(cfg))
+	defer cancel()
+
+	old := inst.currentConfig()
+	if err := inst.reprepare(ctx, cfg, tasks, indexes); err != nil {
+		return err
+	}
+	inst.cfg.Store(cfg)
+	if !reflect.DeepEqual(old.Projects, cfg.Projects) {
+		inst.resetProjects()
+	}
+	if old.Interval != cfg.Interval {
+		select {
+		case inst.intervalChanged <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func preparedStatements(cfg *config.ServerConfig, tasks []MetricTask) []string {
+	statements := make([]string, len(tasks))
+	for i, task := range tasks {
+		statements[i] = dbase.PrepareStatement(cfg, task.QueryKey)
+	}
+	return statements
+}
+
+func (inst *Instance) reprepare(ctx context.Context, cfg *config.ServerConfig, tasks []MetricTask, indexes []int) error {
+	for _, i := range indexes {
+		// 连接未初始化的任务在重连时会按新配置 prepare
+		if inst.conns == nil || inst.conns[i] == nil {
+			continue
+		}
+		// 实例不可用时不影响其他实例的热加载
+		if err := inst.conns[i].PingContext(ctx); err != nil {
+			inst.taskLogger(tasks[i]).Infof("连接失效，下一轮采集时按新配置重连: %v", err)
+			inst.dropConn(i)
+			continue
+		}
+		if err := dbase.RegisterPreparedSQLs(ctx, cfg, tasks[i].QueryKey, inst.conns[i], false); err != nil {
+			return fmt.Errorf("[%s] %w", tasks[i].Name, err)
+		}
+	}
+	return nil
+}
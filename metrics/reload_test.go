@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/global"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 记录执行的语句，执行包含 fail 的 PREPARE 语句时返回错误
+type execConnector struct {
+	mu   sync.Mutex
+	log  []string
+	fail string
+}
+
+func (c *execConnector) Connect(context.Context) (driver.Conn, error) { return execConn{c}, nil }
+func (c *execConnector) Driver() driver.Driver                        { return nil }
+
+func (c *execConnector) prepared() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []string
+	for _, s := range c.log {
+		if strings.HasPrefix(s, "PREPARE ") {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+type execConn struct{ c *execConnector }
+
+func (e execConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	e.c.log = append(e.c.log, query)
+	if e.c.fail != "" && strings.HasPrefix(query, "PREPARE ") && strings.Contains(query, e.c.fail) {
+		return nil, errors.New("syntax error")
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (e execConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("不支持 prepare") }
+func (e execConn) Close() error                        { return nil }
+func (e execConn) Begin() (driver.Tx, error)           { return nil, errors.New("不支持事务") }
+
+// 给实例的每个任务分配一个由 c 记录语句的连接
+func useExecConns(t *testing.T, inst *Instance, c *execConnector) {
+	t.Helper()
+	db := sql.OpenDB(c)
+	t.Cleanup(func() { db.Close() })
+	inst.conns = make([]*sql.Conn, len(Tasks()))
+	for i := range inst.conns {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst.conns[i] = conn
+	}
+}
+
+func TestApplyReload(t *testing.T) {
+	newConfig := func(old *config.ServerConfig) *config.ServerConfig {
+		cfg := *old
+		cfg.ReportKeys = []string{"ci", "codecoverage", "zzsast"}
+		cfg.Interval = 30
+		return &cfg
+	}
+
+	t.Run("全部实例成功后发布新配置", func(t *testing.T) {
+		old := useConfig(t, nil)
+		a, _, _ := newFakeInstance(t, "a")
+		b, _, _ := newFakeInstance(t, "b")
+		ca, cb := &execConnector{}, &execConnector{}
+		useExecConns(t, a, ca)
+		useExecConns(t, b, cb)
+
+		cfg := newConfig(old)
+		if err := applyReload(context.Background(), []*Instance{a, b}, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if global.ServerConfig.Load() != cfg || a.currentConfig() != cfg || b.currentConfig() != cfg {
+			t.Fatal("没有替换为新配置")
+		}
+		for _, c := range []*execConnector{ca, cb} {
+			prepared := c.prepared()
+			if len(prepared) == 0 {
+				t.Fatal("语句变化的查询没有重新 prepare")
+			}
+			for _, s := range prepared {
+				if !strings.Contains(s, "zzsast") {
+					t.Errorf("重新 prepare 的语句没有使用新配置: %s", s)
+				}
+			}
+		}
+		select {
+		case <-a.intervalChanged:
+		default:
+			t.Error("interval 变化后没有通知采集循环")
+		}
+	})
+
+	t.Run("任一实例失败时全部回滚", func(t *testing.T) {
+		old := useConfig(t, nil)
+		a, _, _ := newFakeInstance(t, "a")
+		b, _, _ := newFakeInstance(t, "b")
+		ca, cb := &execConnector{}, &execConnector{fail: "zzsast"}
+		useExecConns(t, a, ca)
+		useExecConns(t, b, cb)
+
+		err := applyReload(context.Background(), []*Instance{a, b}, newConfig(old))
+		if err == nil || !strings.Contains(err.Error(), "[b]") || !strings.Contains(err.Error(), "已回滚") {
+			t.Fatalf("applyReload() = %v", err)
+		}
+		if global.ServerConfig.Load() != old || a.currentConfig() != old || b.currentConfig() != old {
+			t.Fatal("失败后没有恢复原配置")
+		}
+
+		// a 已经按新配置 prepare，回滚时按原配置重新 prepare 同样的查询
+		prepared := ca.prepared()
+		if len(prepared)%2 != 0 || len(prepared) == 0 {
+			t.Fatalf("a 上 prepare 了 %d 次", len(prepared))
+		}
+		half := len(prepared) / 2
+		for i, s := range prepared {
+			if (i < half) != strings.Contains(s, "zzsast") {
+				t.Errorf("a 上第 %d 次 prepare 的配置不对: %s", i+1, s)
+			}
+		}
+		// b 在第一个查询上失败，回滚后所有查询都是原语句
+		last := cb.prepared()
+		if len(last) < 2 || !strings.Contains(last[0], "zzsast") || strings.Contains(last[len(last)-1], "zzsast") {
+			t.Errorf("b 上的 prepare: %v", last)
+		}
+	})
+}
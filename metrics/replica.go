@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go.uber.org/zap"
+	"oneclick-metrics-go/config"
+	dbase "oneclick-metrics-go/db"
+	"time"
+)
+
+// prefer-standby 的连接因为没有可用的备库而连到主库时，每隔这段时间重新连接一次，备库恢复后切回备库
+const preferStandbyRecheck = time.Minute
+
+// 实例在 cfg 中的数据库配置
+func (inst *Instance) database(cfg *config.ServerConfig) config.DataBase {
+	for _, target := range cfg.Targets() {
+		if target.Name == inst.Name {
+			return target.Database
+		}
+	}
+	return config.DataBase{}
+}
+
+// 实例的 target_session_attrs，配置有误时按 any 处理（Open 已经拒绝这样的配置）
+func (inst *Instance) sessionAttrs(cfg *config.ServerConfig) string {
+	_, attrs, err := inst.database(cfg).SplitHosts()
+	if err != nil {
+		return "any"
+	}
+	return attrs
+}
+
+// 第 i 个任务的连接所在服务器是否仍然可以使用
+func (inst *Instance) keepConn(attrs string, i int, state dbase.SessionState) bool {
+	if attrs == "prefer-standby" && !state.InRecovery {
+		return time.Since(inst.connSince[i]) < preferStandbyRecheck
+	}
+	return state.Matches(attrs)
+}
+
+// 从连接池中取出一个所在服务器满足 target_session_attrs 的连接，同时返回服务器状态；查询状态失败时状态为空。
+// 连接池中早于主备切换建立的连接不再满足条件，从连接池中移除后重新取，新建的连接会重新选择服务器
+func (inst *Instance) sessionConn(ctx context.Context, cfg *config.ServerConfig, log *zap.SugaredLogger) (*sql.Conn, *dbase.SessionState, error) {
+	attrs := inst.sessionAttrs(cfg)
+	for retry := 0; ; retry++ {
+		conn, err := ensureConn(ctx, inst.DB, log)
+		if err != nil {
+			return nil, nil, err
+		}
+		state, err := dbase.ReadSessionState(ctx, conn)
+		if err != nil {
+			log.Infof("查询服务器状态失败: %v", err)
+			return conn, nil, nil
+		}
+		if state.Matches(attrs) || retry == 2 {
+			return conn, &state, nil
+		}
+		log.Infof("连接所在的服务器不再满足 target_session_attrs=%s，重新连接", attrs)
+		dbase.DiscardConn(conn)
+	}
+}
+
+// checkReplicaLag 按本轮实际执行查询的连接记录服务器是否为备库及其复制延迟：任一连接在备库上时
+// oneclick_database_in_recovery 为 1，延迟取各连接的最大值。延迟超过 max_replica_lag 时返回错误，
+// 调用方应跳过本轮采集：备库数据落后时，按时间窗口统计的查询（例如最近一分钟关闭的 pr）会漏掉数据。
+// states 中为空的元素表示该连接的状态未知，全部未知时不影响本轮采集
+func (inst *Instance) checkReplicaLag(cfg *config.ServerConfig, states []*dbase.SessionState) error {
+	var known, inRecovery bool
+	var lag float64
+	for _, s := range states {
+		if s == nil {
+			continue
+		}
+		known = true
+		inRecovery = inRecovery || s.InRecovery
+		lag = max(lag, s.Lag)
+	}
+	if !known {
+		inst.m.CycleSkipped.WithLabelValues().Set(0)
+		return nil
+	}
+	if inRecovery {
+		inst.m.DatabaseInRecovery.WithLabelValues().Set(1)
+	} else {
+		inst.m.DatabaseInRecovery.WithLabelValues().Set(0)
+	}
+	inst.m.ReplicaLag.WithLabelValues().Set(lag)
+
+	if limit := inst.database(cfg).MaxReplicaLag; limit > 0 && lag > float64(limit) {
+		inst.m.CycleSkipped.WithLabelValues().Set(1)
+		return fmt.Errorf("备库复制延迟 %.1f 秒，超过 max_replica_lag %d 秒", lag, limit)
+	}
+	inst.m.CycleSkipped.WithLabelValues().Set(0)
+	return nil
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import "time"
+
+// 报告结果，与 oneclick_result_report 的 status 标签取值一致
+const (
+	ResultFailure      = "failure"
+	ResultSuccess      = "success"
+	ResultNotAvailable = "notAvailable"
+)
+
+// PRDetail 单个 pr 的报告合规详情
+type PRDetail struct {
+	Instance        string            `json:"instance"`
+	Project         string            `json:"project"`
+	Repo            string            `json:"repo"`
+	PrNo            string            `json:"pr_no"`
+	State           string            `json:"state"`
+	PresentReports  []string          `json:"present_reports"`
+	RequiredReports []string          `json:"required_reports"`
+	MissingReports  []string          `json:"missing_reports"`
+	Results         map[string]string `json:"results"`
+	CodeCoverage    *float64          `json:"code_coverage"`
+}
+
+// RepoCheck 单个仓库启用的 merge check
+type RepoCheck struct {
+	Instance      string   `json:"instance"`
+	Project       string   `json:"project"`
+	Repo          string   `json:"repo"`
+	EnabledChecks []string `json:"enabled_checks"`
+}
+
+// Snapshot 最近一轮采集得到的数据，供 API 和 web 页面读取
+type Snapshot struct {
+	Instance  string // 合并多个实例的快照时为空
+	UpdatedAt time.Time
+	PRs       []PRDetail
+	Repos     []RepoCheck
+}
+
+// LatestSnapshot 合并所有实例最近一轮采集的快照，UpdatedAt 取最近一次更新的时间，调用方不能修改其中的数据
+func LatestSnapshot() Snapshot {
+	instancesLock.RLock()
+	defer instancesLock.RUnlock()
+	if len(instances) == 1 {
+		return instances[0].Snapshot()
+	}
+
+	var merged Snapshot
+	for _, inst := range instances {
+		s := inst.Snapshot()
+		merged.PRs = append(merged.PRs, s.PRs...)
+		merged.Repos = append(merged.Repos, s.Repos...)
+		if s.UpdatedAt.After(merged.UpdatedAt) {
+			merged.UpdatedAt = s.UpdatedAt
+		}
+	}
+	return merged
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	dbase "oneclick-metrics-go/db"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotPublishedPerCycle(t *testing.T) {
+	useConfig(t, onlyCollectors("PRDetail", "CheckSummary"))
+	inst, _, f := newFakeInstance(t, "snapshot")
+	ctx := context.Background()
+
+	f.Set(dbase.ProjectKeysQueryName, []any{"PROJ"})
+	f.Set("pr_detail_query", []any{"PROJ", "repo1", "1", "0", "ci", "ci:1", nil})
+	f.Set("check_summary_query", []any{"repo1", "ci", "PROJ"})
+	if err := CollectOnce(ctx, []*Instance{inst}); err != nil {
+		t.Fatal(err)
+	}
+	first := inst.Snapshot()
+	if len(first.PRs) != 1 || len(first.Repos) != 1 || first.UpdatedAt.IsZero() {
+		t.Fatalf("第一轮的快照不完整: %+v", first)
+	}
+
+	// CheckSummary 失败的一轮不发布，pr 列表也保持上一轮的数据
+	f.Set("pr_detail_query", []any{"PROJ", "repo2", "2", "0", "ci", "ci:0", nil})
+	f.SetError("check_summary_query", errors.New("boom"))
+	if err := CollectOnce(ctx, []*Instance{inst}); err == nil {
+		t.Fatal("CheckSummary 失败时 CollectOnce 应返回错误")
+	}
+	if got := inst.Snapshot(); !reflect.DeepEqual(got, first) {
+		t.Fatalf("失败的一轮替换了快照:\n got %+v\nwant %+v", got, first)
+	}
+
+	// 两个任务都成功后一起替换
+	f.Set("check_summary_query", []any{"repo2", "ci,sast", "PROJ"})
+	if err := CollectOnce(ctx, []*Instance{inst}); err != nil {
+		t.Fatal(err)
+	}
+	got := inst.Snapshot()
+	if len(got.PRs) != 1 || got.PRs[0].Repo != "repo2" || len(got.Repos) != 1 || got.Repos[0].Repo != "repo2" {
+		t.Fatalf("快照没有整体替换为同一轮的数据: %+v", got)
+	}
+	if got.UpdatedAt.Before(first.UpdatedAt) {
+		t.Fatalf("UpdatedAt 没有更新: %v < %v", got.UpdatedAt, first.UpdatedAt)
+	}
+}
+
+func TestSnapshotWithDisabledHalf(t *testing.T) {
+	// CheckSummary 被禁用时只需要 PRDetail 成功
+	useConfig(t, onlyCollectors("PRDetail"))
+	inst, _, f := newFakeInstance(t, "snapshot-disabled")
+
+	f.Set("pr_detail_query", []any{"PROJ", "repo1", "1", "0", "ci", "ci:1", nil})
+	if err := CollectOnce(context.Background(), []*Instance{inst}); err != nil {
+		t.Fatal(err)
+	}
+	got := inst.Snapshot()
+	if len(got.PRs) != 1 || len(got.Repos) != 0 || got.UpdatedAt.IsZero() {
+		t.Fatalf("快照没有发布: %+v", got)
+	}
+}
@@ -0,0 +1,450 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"oneclick-metrics-go/config"
+	dbase "oneclick-metrics-go/db"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnifiedTask 在 engine 为 unified 时代替所有采集任务：每轮只读取一次 pr、报告和 merge check，
+// 在内存中计算所有指标，各指标来自同一份数据，相互一致
+var UnifiedTask = MetricTask{Name: "Unified", ExportFn: ExportUnified}
+
+func unifiedEngine(cfg *config.ServerConfig) bool {
+	return cfg.Engine == config.EngineUnified
+}
+
+// tickPR 一个 pr 及其 insight 报告
+type tickPR struct {
+	id             int64
+	project        string
+	repoID         int64
+	repo           string
+	prNo           string
+	state          int
+	closedRecently bool // 最近一分钟内关闭
+	current        bool // 未关闭或最近一分钟内关闭，pr_counts_query 等查询统计的 pr
+	reports        []tickReport
+}
+
+type tickReport struct {
+	key    string
+	result sql.NullInt64
+	data   sql.NullString
+}
+
+// tickRepo 一个仓库及其仓库级 merge check 要求的所有报告
+type tickRepo struct {
+	id      int64
+	project string
+	slug    string
+	checks  []string
+}
+
+// tickData 一轮采集读取的全部数据
+type tickData struct {
+	prs      []*tickPR
+	repos    []*tickRepo
+	required map[int64][]string // 仓库要求的报告，只包括 report_keys 中的报告，已排序
+}
+
+// ExportUnified 读取一轮的 pr、报告和 merge check，计算所有未禁用的采集任务的指标
+func ExportUnified(ctx context.Context, inst *Instance, db dbase.Querier) error {
+	cfg := inst.currentConfig()
+	data, err := extractTick(ctx, cfg, db)
+	if err != nil {
+		logger(ctx).Errorf("ExportUnified 读取数据过程中发生错误: %v", err)
+		return err
+	}
+
+	knownProjects := make(map[string]struct{})
+	for _, pr := range data.prs {
+		knownProjects[pr.project] = struct{}{}
+	}
+	allProjects, err := inst.GetAllProjects(ctx, db, knownProjects)
+	if err != nil {
+		logger(ctx).Errorf("ExportUnified GetAllProjects 过程中发生错误: %v", err)
+		return err
+	}
+
+	m := inst.m
+	reportKeys := cfg.ReportKeys
+	if !taskDisabled(cfg, "PRMissingReport") {
+		m.OneClickPRMissingReport.Reset()
+		setStateCounts(m.OneClickPRMissingReport, data.missingCounts(reportKeys), allProjects)
+	}
+	if !taskDisabled(cfg, "PRNum") {
+		m.OneClickPRNum.Reset()
+		setStateCounts(m.OneClickPRNum, data.stateCounts(), allProjects)
+	}
+	if !taskDisabled(cfg, "OpenPRReport") {
+		m.OneClickOpenPRReport.Reset()
+		for _, r := range data.prReports(reportKeys, func(pr *tickPR) bool { return pr.state == 0 }) {
+			setPRReport(m.OneClickOpenPRReport, r)
+		}
+	}
+	if !taskDisabled(cfg, "ClosedPRReport") {
+		m.OneClickClosedPRReport.Reset()
+		for _, r := range data.prReports(reportKeys, func(pr *tickPR) bool { return pr.closedRecently }) {
+			setPRReport(m.OneClickClosedPRReport, r)
+		}
+	}
+	if !taskDisabled(cfg, "ResultReport") {
+		m.OneClickResultReport.Reset()
+		setResultReport(m.OneClickResultReport, data.resultCounts(reportKeys), reportKeys, allProjects)
+	}
+	if !taskDisabled(cfg, "CheckSummary") {
+		m.OneClickCheckSummary.Reset()
+		var repos []RepoCheck
+		for _, r := range data.checkSummaries(allProjects) {
+			repos = append(repos, setCheckSummary(inst, r))
+		}
+		inst.setRepoChecks(repos)
+	}
+	if !taskDisabled(cfg, "ComplianceRatio") {
+		m.OneClickComplianceRatio.Reset()
+		setComplianceRatio(m.OneClickComplianceRatio, data.compliance(reportKeys), reportKeys, allProjects)
+	}
+	if !taskDisabled(cfg, "PRDetail") {
+		var details []PRDetail
+		for _, r := range data.details(reportKeys) {
+			details = append(details, newPRDetail(inst.Name, r))
+		}
+		inst.setPRDetails(details)
+	}
+	return nil
+}
+
+// 执行 UnifiedTask，并把结果同步到各采集任务的 oneclick_collector_up 和最近成功时间，
+// 切换采集方式后按采集任务配置的告警仍然有效
+func (inst *Instance) runUnified(ctx context.Context, q dbase.Querier) error {
+	err := runTask(ctx, inst, q, UnifiedTask)
+	cfg := inst.currentConfig()
+	for _, task := range Tasks() {
+		if taskDisabled(cfg, task.Name) {
+			continue
+		}
+		if err != nil {
+			inst.m.CollectorUp.WithLabelValues(task.Name).Set(0)
+			continue
+		}
+		inst.m.CollectorUp.WithLabelValues(task.Name).Set(1)
+		inst.m.CollectorLastSuccess.WithLabelValues(task.Name).SetToCurrentTime()
+	}
+	return err
+}
+
+// 没有可用的连接时把 UnifiedTask 和各采集任务都记为失败
+func (inst *Instance) unifiedDown(cfg *config.ServerConfig) {
+	inst.m.CollectorUp.WithLabelValues(UnifiedTask.Name).Set(0)
+	for _, task := range Tasks() {
+		if !taskDisabled(cfg, task.Name) {
+			inst.m.CollectorUp.WithLabelValues(task.Name).Set(0)
+		}
+	}
+}
+
+func extractTick(ctx context.Context, cfg *config.ServerConfig, db dbase.Querier) (*tickData, error) {
+	data := &tickData{required: make(map[int64][]string)}
+	start := time.Now()
+	if err := data.readPRs(ctx, cfg, db); err != nil {
+		return nil, err
+	}
+	if err := data.readRepos(ctx, cfg, db); err != nil {
+		return nil, err
+	}
+	logger(ctx).Debugw("读取数据完成", "prs", len(data.prs), "repos", len(data.repos), "duration", time.Since(start))
+	return data, nil
+}
+
+func (d *tickData) readPRs(ctx context.Context, cfg *config.ServerConfig, db dbase.Querier) error {
+	rows, err := db.QueryContext(ctx, dbase.TickPRsQuery(cfg), cfg.Timezone)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// 同一个 pr 的行相邻
+	var last *tickPR
+	for rows.Next() {
+		var pr tickPR
+		var key sql.NullString
+		var rep tickReport
+		if err := rows.Scan(&pr.id, &pr.project, &pr.repoID, &pr.repo, &pr.prNo, &pr.state,
+			&pr.closedRecently, &pr.current, &key, &rep.result, &rep.data); err != nil {
+			logger(ctx).Errorf("ExportUnified scan pr 过程中发生错误: %v", err)
+			continue
+		}
+		pr.current = pr.current || pr.closedRecently
+		if last == nil || last.id != pr.id {
+			last = &pr
+			d.prs = append(d.prs, last)
+		}
+		if key.Valid {
+			rep.key = key.String
+			last.reports = append(last.reports, rep)
+		}
+	}
+	return rows.Err()
+}
+
+func (d *tickData) readRepos(ctx context.Context, cfg *config.ServerConfig, db dbase.Querier) error {
+	rows, err := db.QueryContext(ctx, dbase.TickChecksQuery(cfg))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*tickRepo)
+	for rows.Next() {
+		var repo tickRepo
+		var key sql.NullString
+		if err := rows.Scan(&repo.id, &repo.project, &repo.slug, &key); err != nil {
+			logger(ctx).Errorf("ExportUnified scan merge check 过程中发生错误: %v", err)
+			continue
+		}
+		r, ok := byID[repo.id]
+		if !ok {
+			r = &repo
+			byID[repo.id] = r
+			d.repos = append(d.repos, r)
+		}
+		if key.Valid {
+			r.checks = append(r.checks, key.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	keys := keySet(cfg.ReportKeys)
+	for _, r := range d.repos {
+		r.checks = distinct(r.checks, nil)
+		if required := distinct(r.checks, keys); len(required) > 0 {
+			d.required[r.id] = required
+		}
+	}
+	return nil
+}
+
+// 每个 project 每个状态的 pr 数量，与 pr_counts_query 相同
+func (d *tickData) stateCounts() map[string][3]int {
+	counts := make(map[string][3]int)
+	for _, pr := range d.prs {
+		if !pr.current || pr.state < 0 || pr.state > 2 {
+			continue
+		}
+		arr := counts[pr.project]
+		arr[pr.state]++
+		counts[pr.project] = arr
+	}
+	return counts
+}
+
+// 缺少仓库要求的报告的 pr 数量，与 pr_missing_report_query 相同
+func (d *tickData) missingCounts(reportKeys []string) map[string][3]int {
+	keys := keySet(reportKeys)
+	counts := make(map[string][3]int)
+	for _, pr := range d.prs {
+		if !pr.current || pr.state < 0 || pr.state > 2 {
+			continue
+		}
+		present := keySet(pr.presentKeys(keys))
+		for _, key := range d.required[pr.repoID] {
+			if _, ok := present[key]; !ok {
+				arr := counts[pr.project]
+				arr[pr.state]++
+				counts[pr.project] = arr
+				break
+			}
+		}
+	}
+	return counts
+}
+
+// open_pr_report_query 和 closed_pr_report_query 的结果，include 选择 pr
+func (d *tickData) prReports(reportKeys []string, include func(pr *tickPR) bool) []OpenPrReport {
+	keys := keySet(reportKeys)
+	var reports []OpenPrReport
+	for _, pr := range d.prs {
+		if !include(pr) {
+			continue
+		}
+		reports = append(reports, OpenPrReport{
+			StashRepo:      pr.repo,
+			PrNo:           pr.prNo,
+			PresentReports: joinReports(pr.presentKeys(keys)),
+			Project:        pr.project,
+			ReportData:     pr.reportData(),
+		})
+	}
+	return reports
+}
+
+// open pr 每种报告每种结果的数量，与 result_report_query 相同：每个 pr 的每种结果计一次，
+// 没有结果的报告和缺少的必需报告计入 notAvailable
+func (d *tickData) resultCounts(reportKeys []string) map[string]map[string][3]int {
+	counts := make(map[string]map[string][3]int)
+	add := func(key, project string, status int) {
+		if counts[key] == nil {
+			counts[key] = make(map[string][3]int)
+		}
+		arr := counts[key][project]
+		arr[status]++
+		counts[key][project] = arr
+	}
+
+	for _, pr := range d.prs {
+		if pr.state != 0 {
+			continue
+		}
+		required := keySet(d.required[pr.repoID])
+		for _, key := range reportKeys {
+			statuses := make(map[int]struct{})
+			for _, rep := range pr.reports {
+				if rep.key != key {
+					continue
+				}
+				status := 2
+				if rep.result.Valid {
+					status = int(rep.result.Int64)
+				}
+				statuses[status] = struct{}{}
+			}
+			if _, ok := required[key]; ok && len(statuses) == 0 {
+				statuses[2] = struct{}{}
+			}
+			for status := range statuses {
+				if status >= 0 && status <= 2 {
+					add(key, pr.project, status)
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// check_summary_query 的结果，只包括 allProjects 中的项目
+func (d *tickData) checkSummaries(allProjects map[string]struct{}) []CheckSummary {
+	var summaries []CheckSummary
+	for _, r := range d.repos {
+		if _, ok := allProjects[r.project]; !ok {
+			continue
+		}
+		summaries = append(summaries, CheckSummary{StashRepo: r.slug, PresrntReport: joinReports(r.checks), Project: r.project})
+	}
+	return summaries
+}
+
+// pr_compliance_query 的结果
+func (d *tickData) compliance(reportKeys []string) []PrCompliance {
+	keys := keySet(reportKeys)
+	var rows []PrCompliance
+	for _, pr := range d.prs {
+		if !pr.current {
+			continue
+		}
+		rows = append(rows, PrCompliance{
+			State:           strconv.Itoa(pr.state),
+			Project:         pr.project,
+			RequiredReports: joinReports(d.required[pr.repoID]),
+			PresentReports:  joinReports(pr.presentKeys(keys)),
+		})
+	}
+	return rows
+}
+
+// pr_detail_query 的结果，按项目、仓库和 pr 编号排序
+func (d *tickData) details(reportKeys []string) []PrDetailRow {
+	keys := keySet(reportKeys)
+	var rows []PrDetailRow
+	for _, pr := range d.prs {
+		if !pr.current {
+			continue
+		}
+		var results []string
+		for _, rep := range pr.reports {
+			if _, ok := keys[rep.key]; !ok {
+				continue
+			}
+			result := ""
+			if rep.result.Valid {
+				result = strconv.FormatInt(rep.result.Int64, 10)
+			}
+			results = append(results, rep.key+":"+result)
+		}
+		rows = append(rows, PrDetailRow{
+			Project:         pr.project,
+			StashRepo:       pr.repo,
+			PrNo:            pr.prNo,
+			State:           strconv.Itoa(pr.state),
+			RequiredReports: joinReports(d.required[pr.repoID]),
+			ReportResults:   joinReports(distinct(results, nil)),
+			ReportData:      pr.reportData(),
+		})
+	}
+	return rows
+}
+
+// pr 提供的 keys 中的报告，已排序
+func (pr *tickPR) presentKeys(keys map[string]struct{}) []string {
+	var present []string
+	for _, rep := range pr.reports {
+		present = append(present, rep.key)
+	}
+	return distinct(present, keys)
+}
+
+// 所有报告中非空的 DATA 去重后拼接为 JSON 数组，与查询中的 string_agg(DISTINCT nullif(DATA, ...)) 相同，没有时为 NULL
+func (pr *tickPR) reportData() sql.NullString {
+	var data []string
+	for _, rep := range pr.reports {
+		if rep.data.Valid && rep.data.String != "" {
+			data = append(data, rep.data.String)
+		}
+	}
+	data = distinct(data, nil)
+	if len(data) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: "[" + strings.Join(data, ",") + "]", Valid: true}
+}
+
+// 去重并排序，only 不为空时只保留其中的值
+func distinct(values []string, only map[string]struct{}) []string {
+	seen := make(map[string]struct{}, len(values))
+	var result []string
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		if _, ok := only[v]; only != nil && !ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func keySet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return set
+}
+
+// 与查询中的 string_agg 相同，没有值时为 NULL
+func joinReports(values []string) sql.NullString {
+	if len(values) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: strings.Join(values, ","), Valid: true}
+}
@@ -0,0 +1,79 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"oneclick-metrics-go/config"
+	"strings"
+	"time"
+)
+
+// GraphiteSink 通过 TCP 以 plaintext 协议推送，标签写成 Graphite 1.1 的 tag 形式：
+// prefix.name;tag1=value1;tag2=value2 value timestamp
+type GraphiteSink struct {
+	cfg    config.GraphiteConfig
+	dialer net.Dialer
+}
+
+func NewGraphiteSink(cfg config.GraphiteConfig) *GraphiteSink {
+	return &GraphiteSink{cfg: cfg}
+}
+
+func (s *GraphiteSink) Name() string {
+	return "graphite"
+}
+
+func (s *GraphiteSink) Push(ctx context.Context, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	conn, err := s.dialer.DialContext(ctx, "tcp", s.cfg.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	now := time.Now().Unix()
+	for _, smp := range flatten(families) {
+		value, ok := formatValue(smp.value)
+		if !ok {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %d\n", s.path(smp), value, now); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (s *GraphiteSink) path(smp sample) string {
+	var b strings.Builder
+	if s.cfg.Prefix != "" {
+		b.WriteString(strings.TrimSuffix(s.cfg.Prefix, "."))
+		b.WriteByte('.')
+	}
+	b.WriteString(smp.name)
+	for _, lp := range smp.labels {
+		// Graphite 不允许空的 tag 值
+		if lp.GetValue() == "" {
+			continue
+		}
+		b.WriteByte(';')
+		b.WriteString(lp.GetName())
+		b.WriteByte('=')
+		b.WriteString(graphiteTagReplacer.Replace(lp.GetValue()))
+	}
+	return b.String()
+}
+
+// tag 值中不能出现 ; ~ 和空白字符
+var graphiteTagReplacer = strings.NewReplacer(";", "_", "~", "_", " ", "_", "\t", "_", "\n", "_")
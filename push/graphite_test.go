@@ -0,0 +1,68 @@
+package push
+
+import (
+	"context"
+	"io"
+	"net"
+	"oneclick-metrics-go/config"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphitePush(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		body, _ := io.ReadAll(conn)
+		received <- string(body)
+	}()
+
+	sink := NewGraphiteSink(config.GraphiteConfig{Address: ln.Addr().String(), Prefix: "oneclick."})
+	before := time.Now().Unix()
+	if err := sink.Push(context.Background(), testRegistry(t)); err != nil {
+		t.Fatal(err)
+	}
+	body := <-received
+
+	// 空的 tag 值被省略，tag 值中的空格替换为下划线，NaN 被跳过
+	want := []string{
+		"oneclick.oneclick_test_gauge;project=PROJ;repo=my_repo,a=b 2",
+		"oneclick.oneclick_test_seconds_sum 0.5",
+		"oneclick.oneclick_test_seconds_count 1",
+	}
+	if got := stripTimestamps(t, body); !reflect.DeepEqual(got, want) {
+		t.Fatalf("推送的内容不符:\n got %q\nwant %q", got, want)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		ts, err := strconv.ParseInt(line[strings.LastIndexByte(line, ' ')+1:], 10, 64)
+		if err != nil || ts < before || ts > time.Now().Unix() {
+			t.Fatalf("时间戳不是以秒为单位的当前时间: %q", line)
+		}
+	}
+}
+
+func TestGraphitePushDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	if err := NewGraphiteSink(config.GraphiteConfig{Address: addr}).Push(context.Background(), testRegistry(t)); err == nil {
+		t.Fatal("连接失败时应返回错误")
+	}
+}
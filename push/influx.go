@@ -0,0 +1,91 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"net/url"
+	"oneclick-metrics-go/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxSink 通过 HTTP 以 line protocol 推送，指标名称作为 measurement，标签作为 tag，值写入 value 字段
+type InfluxSink struct {
+	cfg    config.InfluxConfig
+	client *http.Client
+}
+
+func NewInfluxSink(cfg config.InfluxConfig) (*InfluxSink, error) {
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("influx url 不合法: %w", err)
+	}
+	return &InfluxSink{cfg: cfg, client: &http.Client{}}, nil
+}
+
+func (s *InfluxSink) Name() string {
+	return "influx"
+}
+
+func (s *InfluxSink) Push(ctx context.Context, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	for _, smp := range flatten(families) {
+		value, ok := formatValue(smp.value)
+		if !ok {
+			continue
+		}
+		body.WriteString(influxMeasurementEscaper.Replace(smp.name))
+		for _, lp := range smp.labels {
+			// line protocol 不允许空的 tag 值
+			if lp.GetValue() == "" {
+				continue
+			}
+			body.WriteByte(',')
+			body.WriteString(influxTagEscaper.Replace(lp.GetName()))
+			body.WriteByte('=')
+			body.WriteString(influxTagEscaper.Replace(lp.GetValue()))
+		}
+		body.WriteString(" value=")
+		body.WriteString(value)
+		body.WriteByte(' ')
+		body.WriteString(now)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.cfg.Token)
+	} else if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("influx 返回状态码 %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
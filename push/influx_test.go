@@ -0,0 +1,76 @@
+package push
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"oneclick-metrics-go/config"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInfluxPush(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.InfluxConfig
+		wantAuth string
+	}{
+		{"token", config.InfluxConfig{Token: "secret", Username: "u", Password: "p"}, "Token secret"},
+		{"basic auth", config.InfluxConfig{Username: "u", Password: "p"}, "Basic dTpw"},
+		{"无认证", config.InfluxConfig{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, query, auth, body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				method, query, auth, body = r.Method, r.URL.RawQuery, r.Header.Get("Authorization"), string(b)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			tt.cfg.URL = srv.URL + "/api/v2/write?org=o&bucket=b"
+			sink, err := NewInfluxSink(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Push(context.Background(), testRegistry(t)); err != nil {
+				t.Fatal(err)
+			}
+
+			if method != http.MethodPost || query != "org=o&bucket=b" {
+				t.Fatalf("请求不符: %s ?%s", method, query)
+			}
+			if auth != tt.wantAuth {
+				t.Fatalf("Authorization = %q, want %q", auth, tt.wantAuth)
+			}
+			// 空的 tag 值被省略，tag 中的空格、逗号和等号被转义，NaN 被跳过
+			want := []string{
+				`oneclick_test_gauge,project=PROJ,repo=my\ repo\,a\=b value=2`,
+				`oneclick_test_seconds_sum value=0.5`,
+				`oneclick_test_seconds_count value=1`,
+			}
+			if got := stripTimestamps(t, body); !reflect.DeepEqual(got, want) {
+				t.Fatalf("推送的内容不符:\n got %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestInfluxPushErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bucket not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	sink, err := NewInfluxSink(config.InfluxConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Push(context.Background(), testRegistry(t))
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "bucket not found") {
+		t.Fatalf("错误中应包含状态码和响应内容: %v", err)
+	}
+}
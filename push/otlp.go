@@ -0,0 +1,203 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"math"
+	"oneclick-metrics-go/config"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 未配置 service.name 时使用的默认值
+const defaultServiceName = "oneclick-metrics-go"
+
+// otlp exporter 需要实现的方法，grpc 和 http 两种 exporter 都满足
+type otlpExporter interface {
+	Export(ctx context.Context, rm *metricdata.ResourceMetrics) error
+	Shutdown(ctx context.Context) error
+}
+
+// OTLPSink 把 registry 中的指标转换为 OTel 数据模型，通过 OTLP 发送到 OpenTelemetry Collector
+type OTLPSink struct {
+	exporter   otlpExporter
+	resource   *resource.Resource
+	attributes map[string]string
+	start      time.Time // counter 与 histogram 的起始时间
+}
+
+func NewOTLPSink(cfg config.OTLPConfig) (*OTLPSink, error) {
+	exporter, err := newOTLPExporter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("创建 otlp exporter 失败: %w", err)
+	}
+
+	attrs := map[string]string{"service.name": defaultServiceName}
+	for k, v := range cfg.ResourceAttributes {
+		attrs[k] = v
+	}
+	var kvs []attribute.KeyValue
+	for k, v := range attrs {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+
+	return &OTLPSink{
+		exporter:   exporter,
+		resource:   resource.NewSchemaless(kvs...),
+		attributes: cfg.Attributes,
+		start:      time.Now(),
+	}, nil
+}
+
+func newOTLPExporter(cfg config.OTLPConfig) (otlpExporter, error) {
+	ctx := context.Background()
+	switch strings.ToLower(cfg.Protocol) {
+	case "", "grpc":
+		opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.Headers))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	case "http", "http/protobuf":
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.URLPath != "" {
+			opts = append(opts, otlpmetrichttp.WithURLPath(cfg.URLPath))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("不支持的 otlp 协议: %s", cfg.Protocol)
+	}
+}
+
+func (s *OTLPSink) Name() string {
+	return "otlp"
+}
+
+func (s *OTLPSink) Push(ctx context.Context, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	rm := &metricdata.ResourceMetrics{
+		Resource: s.resource,
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope:   instrumentation.Scope{Name: "oneclick-metrics-go"},
+			Metrics: s.convert(families, time.Now()),
+		}},
+	}
+	return s.exporter.Export(ctx, rm)
+}
+
+// Shutdown 关闭底层连接
+func (s *OTLPSink) Shutdown(ctx context.Context) error {
+	return s.exporter.Shutdown(ctx)
+}
+
+// 按配置把指标标签映射为 OTel 属性，映射为空字符串的标签被丢弃，未配置的标签保持原名
+func (s *OTLPSink) attributeSet(labels []*dto.LabelPair) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for _, lp := range labels {
+		name := lp.GetName()
+		if mapped, ok := s.attributes[name]; ok {
+			if mapped == "" {
+				continue
+			}
+			name = mapped
+		}
+		kvs = append(kvs, attribute.String(name, lp.GetValue()))
+	}
+	return attribute.NewSet(kvs...)
+}
+
+// 把 Prometheus 的 MetricFamily 转换为 OTel 指标：gauge/untyped 转为 Gauge，counter 转为单调递增的 Sum，
+// histogram 转为 Histogram，summary 只保留 _sum 与 _count 两个 Gauge
+func (s *OTLPSink) convert(families []*dto.MetricFamily, now time.Time) []metricdata.Metrics {
+	var out []metricdata.Metrics
+	for _, mf := range families {
+		name, help := mf.GetName(), mf.GetHelp()
+		switch mf.GetType() {
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			var points []metricdata.DataPoint[float64]
+			for _, m := range mf.GetMetric() {
+				v := m.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = m.GetUntyped().GetValue()
+				}
+				points = append(points, metricdata.DataPoint[float64]{Attributes: s.attributeSet(m.GetLabel()), Time: now, Value: v})
+			}
+			out = append(out, metricdata.Metrics{Name: name, Description: help, Data: metricdata.Gauge[float64]{DataPoints: points}})
+		case dto.MetricType_COUNTER:
+			var points []metricdata.DataPoint[float64]
+			for _, m := range mf.GetMetric() {
+				points = append(points, metricdata.DataPoint[float64]{Attributes: s.attributeSet(m.GetLabel()), StartTime: s.start, Time: now, Value: m.GetCounter().GetValue()})
+			}
+			out = append(out, metricdata.Metrics{Name: name, Description: help, Data: metricdata.Sum[float64]{
+				DataPoints: points, Temporality: metricdata.CumulativeTemporality, IsMonotonic: true,
+			}})
+		case dto.MetricType_HISTOGRAM:
+			var points []metricdata.HistogramDataPoint[float64]
+			for _, m := range mf.GetMetric() {
+				points = append(points, s.histogramPoint(m, now))
+			}
+			out = append(out, metricdata.Metrics{Name: name, Description: help, Data: metricdata.Histogram[float64]{
+				DataPoints: points, Temporality: metricdata.CumulativeTemporality,
+			}})
+		case dto.MetricType_SUMMARY:
+			var sums, counts []metricdata.DataPoint[float64]
+			for _, m := range mf.GetMetric() {
+				attrs := s.attributeSet(m.GetLabel())
+				sums = append(sums, metricdata.DataPoint[float64]{Attributes: attrs, Time: now, Value: m.GetSummary().GetSampleSum()})
+				counts = append(counts, metricdata.DataPoint[float64]{Attributes: attrs, Time: now, Value: float64(m.GetSummary().GetSampleCount())})
+			}
+			out = append(out,
+				metricdata.Metrics{Name: name + "_sum", Description: help, Data: metricdata.Gauge[float64]{DataPoints: sums}},
+				metricdata.Metrics{Name: name + "_count", Description: help, Data: metricdata.Gauge[float64]{DataPoints: counts}})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Prometheus 的 bucket 是累计计数，OTel 需要每个区间各自的计数，最后一个区间为 +Inf
+func (s *OTLPSink) histogramPoint(m *dto.Metric, now time.Time) metricdata.HistogramDataPoint[float64] {
+	h := m.GetHistogram()
+	var bounds []float64
+	var counts []uint64
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+		bounds = append(bounds, b.GetUpperBound())
+		counts = append(counts, b.GetCumulativeCount()-prev)
+		prev = b.GetCumulativeCount()
+	}
+	counts = append(counts, h.GetSampleCount()-prev)
+	return metricdata.HistogramDataPoint[float64]{
+		Attributes:   s.attributeSet(m.GetLabel()),
+		StartTime:    s.start,
+		Time:         now,
+		Count:        h.GetSampleCount(),
+		Bounds:       bounds,
+		BucketCounts: counts,
+		Sum:          h.GetSampleSum(),
+	}
+}
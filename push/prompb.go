@@ -0,0 +1,80 @@
+package push
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"sort"
+)
+
+// remote_write 协议中的 WriteRequest 编码，字段编号与 prometheus/prompb 保持一致：
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+type prompbLabel struct {
+	name, value string
+}
+
+type prompbSeries struct {
+	labels    []prompbLabel
+	value     float64
+	timestamp int64 // 毫秒
+}
+
+// 把样本转换为时间序列，标签按名称排序并附加外部标签，样本自身的标签优先
+func toSeries(samples []sample, externalLabels map[string]string, timestamp int64) []prompbSeries {
+	series := make([]prompbSeries, 0, len(samples))
+	for _, smp := range samples {
+		labels := map[string]string{"__name__": smp.name}
+		for k, v := range externalLabels {
+			labels[k] = v
+		}
+		for _, lp := range smp.labels {
+			if lp.GetValue() != "" {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+		}
+
+		ts := prompbSeries{value: smp.value, timestamp: timestamp}
+		for k, v := range labels {
+			ts.labels = append(ts.labels, prompbLabel{k, v})
+		}
+		sort.Slice(ts.labels, func(i, j int) bool { return ts.labels[i].name < ts.labels[j].name })
+		series = append(series, ts)
+	}
+	return series
+}
+
+func marshalWriteRequest(series []prompbSeries) []byte {
+	var buf []byte
+	for _, ts := range series {
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, marshalTimeSeries(ts))
+	}
+	return buf
+}
+
+func marshalTimeSeries(ts prompbSeries) []byte {
+	var buf []byte
+	for _, l := range ts.labels {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, l.name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, l.value)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, label)
+	}
+
+	var smp []byte
+	smp = protowire.AppendTag(smp, 1, protowire.Fixed64Type)
+	smp = protowire.AppendFixed64(smp, math.Float64bits(ts.value))
+	smp = protowire.AppendTag(smp, 2, protowire.VarintType)
+	smp = protowire.AppendVarint(smp, uint64(ts.timestamp))
+
+	buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, smp)
+	return buf
+}
@@ -0,0 +1,41 @@
+package push
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"oneclick-metrics-go/config"
+)
+
+// 未配置 job 时使用的默认值
+const defaultPushgatewayJob = "oneclick-metrics"
+
+// PushgatewaySink 使用 PUT 推送到 Pushgateway，替换同一分组下的全部指标
+type PushgatewaySink struct {
+	cfg config.PushgatewayConfig
+}
+
+func NewPushgatewaySink(cfg config.PushgatewayConfig) *PushgatewaySink {
+	if cfg.Job == "" {
+		cfg.Job = defaultPushgatewayJob
+	}
+	return &PushgatewaySink{cfg: cfg}
+}
+
+func (s *PushgatewaySink) Name() string {
+	return "pushgateway"
+}
+
+func (s *PushgatewaySink) Push(ctx context.Context, g prometheus.Gatherer) error {
+	pusher := push.New(s.cfg.URL, s.cfg.Job).Gatherer(g)
+
+	// URL 中分组标签的顺序由 push 包决定，Pushgateway 不区分顺序
+	for k, v := range s.cfg.Grouping {
+		pusher = pusher.Grouping(k, v)
+	}
+
+	if s.cfg.Username != "" {
+		pusher = pusher.BasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+	return pusher.PushContext(ctx)
+}
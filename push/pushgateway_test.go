@@ -0,0 +1,165 @@
+package push
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/metrics"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPushgatewayPush(t *testing.T) {
+	var method, path, user, password string
+	var hasAuth bool
+	families := map[string]*dto.MetricFamily{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.EscapedPath()
+		user, password, hasAuth = r.BasicAuth()
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			mf := &dto.MetricFamily{}
+			if err := dec.Decode(mf); err != nil {
+				if err != io.EOF {
+					t.Errorf("解析请求内容失败: %v", err)
+				}
+				break
+			}
+			families[mf.GetName()] = mf
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := NewPushgatewaySink(config.PushgatewayConfig{
+		URL:      srv.URL,
+		Grouping: map[string]string{"instance": "prod", "env": "a/b"},
+		Username: "u",
+		Password: "p",
+	})
+	if err := sink.Push(context.Background(), testRegistry(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未配置 job 时使用默认值，含 / 的分组标签值按 base64 编码
+	if method != http.MethodPut {
+		t.Fatalf("method = %s, want PUT", method)
+	}
+	want := map[string]string{"job": "oneclick-metrics", "env@base64": "YS9i", "instance": "prod"}
+	if got := groupingKey(t, path); !reflect.DeepEqual(got, want) {
+		t.Fatalf("分组不符: path = %s", path)
+	}
+	if !hasAuth || user != "u" || password != "p" {
+		t.Fatalf("basic auth = %q:%q (%v)", user, password, hasAuth)
+	}
+
+	gauge := families["oneclick_test_gauge"]
+	if gauge == nil || len(gauge.GetMetric()) != 2 {
+		t.Fatalf("没有推送 oneclick_test_gauge 的全部序列: %v", gauge)
+	}
+	for _, m := range gauge.GetMetric() {
+		labels := map[string]string{}
+		for _, lp := range m.GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if labels["project"] == "PROJ" && (labels["repo"] != "my repo,a=b" || m.GetGauge().GetValue() != 2) {
+			t.Fatalf("oneclick_test_gauge 的序列不符: %v", m)
+		}
+	}
+	if h := families["oneclick_test_seconds"]; h == nil || h.GetMetric()[0].GetHistogram().GetSampleCount() != 1 {
+		t.Fatalf("直方图没有原样推送: %v", h)
+	}
+}
+
+func TestPushgatewayPushWithoutAuth(t *testing.T) {
+	var hasAuth bool
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, hasAuth = r.BasicAuth()
+		path = r.URL.EscapedPath()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := NewPushgatewaySink(config.PushgatewayConfig{URL: srv.URL, Job: "custom"})
+	if err := sink.Push(context.Background(), testRegistry(t)); err != nil {
+		t.Fatal(err)
+	}
+	if hasAuth {
+		t.Fatal("未配置 username 时不应发送 basic auth")
+	}
+	if got := groupingKey(t, path); !reflect.DeepEqual(got, map[string]string{"job": "custom"}) {
+		t.Fatalf("path = %s, want /metrics/job/custom", path)
+	}
+}
+
+// 把 /metrics/job/<job>/<name>/<value>... 形式的路径解析为分组标签
+func groupingKey(t *testing.T, path string) map[string]string {
+	t.Helper()
+	parts := strings.Split(strings.TrimPrefix(path, "/metrics/"), "/")
+	if len(parts)%2 != 0 {
+		t.Fatalf("path 不是成对的标签: %s", path)
+	}
+	key := map[string]string{}
+	for i := 0; i < len(parts); i += 2 {
+		key[parts[i]] = parts[i+1]
+	}
+	return key
+}
+
+// exporter 的真实指标按 README 中的示例配置推送：指标带有 bitbucket_instance 标签，分组标签 instance 不冲突
+func TestPushgatewayPushExporterMetrics(t *testing.T) {
+	var pushed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			mf := &dto.MetricFamily{}
+			if err := dec.Decode(mf); err != nil {
+				break
+			}
+			for _, m := range mf.GetMetric() {
+				for _, lp := range m.GetLabel() {
+					pushed = append(pushed, mf.GetName()+":"+lp.GetName()+"="+lp.GetValue())
+				}
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	m := metrics.SetupMetrics()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m.Collectors()...)
+	m.OneClickPRNum.WithLabelValues("prod", "open", "PROJ").Set(3)
+	m.CollectorUp.WithLabelValues("prod", "PRNum").Set(1)
+
+	cfg := config.PushgatewayConfig{URL: srv.URL, Grouping: map[string]string{"instance": "oneclick-prod"}}
+	if err := NewPushgatewaySink(cfg).Push(context.Background(), reg); err != nil {
+		t.Fatalf("按示例配置推送失败: %v", err)
+	}
+	want := "oneclick_pr_num:" + metrics.InstanceLabel + "=prod"
+	found := false
+	for _, p := range pushed {
+		found = found || p == want
+	}
+	if !found {
+		t.Fatalf("没有推送 %s: %v", want, pushed)
+	}
+
+	// 分组标签与指标标签同名时 push 包拒绝推送，配置校验应提前报告
+	cfg.Grouping = map[string]string{metrics.InstanceLabel: "prod"}
+	if err := NewPushgatewaySink(cfg).Push(context.Background(), reg); err == nil {
+		t.Fatal("分组标签与指标标签同名时推送应失败")
+	}
+	serverCfg := &config.ServerConfig{Port: "8000", Interval: 15, Timezone: "UTC", Push: config.PushConfig{Pushgateway: cfg}}
+	err := serverCfg.Validate(metrics.KnownNames())
+	if err == nil || !strings.Contains(err.Error(), "push.pushgateway.grouping."+metrics.InstanceLabel) {
+		t.Fatalf("配置校验没有报告冲突的分组标签: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package push
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 持久化请求文件的后缀
+const queueFileSuffix = ".snappy"
+
+type queueEntry struct {
+	id   string // 同时也是持久化时的文件名
+	body []byte
+}
+
+// requestQueue 保存尚未发送成功的 remote_write 请求，配置了目录时同时写入磁盘，重启后继续发送
+type requestQueue struct {
+	mu      sync.Mutex
+	dir     string
+	max     int
+	entries []queueEntry // 按加入顺序排列，最旧的在前
+}
+
+func newRequestQueue(dir string, max int) (*requestQueue, error) {
+	q := &requestQueue{dir: dir, max: max}
+	if dir == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("创建 remote_write 队列目录失败: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+queueFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		body, err := os.ReadFile(f)
+		if err != nil {
+			zap.S().Errorf("读取 remote_write 队列文件 %s 失败: %v", f, err)
+			continue
+		}
+		q.entries = append(q.entries, queueEntry{id: filepath.Base(f), body: body})
+	}
+	if len(q.entries) > 0 {
+		zap.S().Infof("从 %s 恢复了 %d 个未发送的 remote_write 请求", dir, len(q.entries))
+	}
+	q.trim()
+	return q, nil
+}
+
+func (q *requestQueue) add(body []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry := queueEntry{id: fmt.Sprintf("%020d%s", time.Now().UnixNano(), queueFileSuffix), body: body}
+	if q.dir != "" {
+		// 先写临时文件再重命名，避免进程退出时留下不完整的请求
+		path := filepath.Join(q.dir, entry.id)
+		if err := os.WriteFile(path+".tmp", body, 0o600); err != nil {
+			return err
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+	q.entries = append(q.entries, entry)
+	q.trim()
+	return nil
+}
+
+// 超出上限时丢弃最旧的请求，调用方需持有锁
+func (q *requestQueue) trim() {
+	for q.max > 0 && len(q.entries) > q.max {
+		zap.S().Warnf("remote_write 队列已满，丢弃最旧的请求 %s", q.entries[0].id)
+		q.deleteFile(q.entries[0].id)
+		q.entries = q.entries[1:]
+	}
+}
+
+// 返回当前队列的快照
+func (q *requestQueue) snapshot() []queueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]queueEntry(nil), q.entries...)
+}
+
+func (q *requestQueue) remove(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range q.entries {
+		if e.id == id {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			break
+		}
+	}
+	q.deleteFile(id)
+}
+
+func (q *requestQueue) deleteFile(id string) {
+	if q.dir == "" || strings.ContainsAny(id, `/\`) {
+		return
+	}
+	if err := os.Remove(filepath.Join(q.dir, id)); err != nil && !os.IsNotExist(err) {
+		zap.S().Errorf("删除 remote_write 队列文件 %s 失败: %v", id, err)
+	}
+}
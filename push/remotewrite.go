@@ -0,0 +1,171 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"oneclick-metrics-go/config"
+	"strings"
+	"time"
+)
+
+// 未配置时使用的默认值
+const (
+	defaultMaxQueuedRequests = 100
+	defaultMaxRetries        = 3
+	defaultMinBackoff        = 100 * time.Millisecond
+	defaultMaxBackoff        = 5 * time.Second
+)
+
+// RemoteWriteSink 作为 Prometheus remote_write 客户端，把每轮采集的结果发送到 Mimir、Thanos Receive、VictoriaMetrics 等
+type RemoteWriteSink struct {
+	cfg        config.RemoteWriteConfig
+	client     *http.Client
+	queue      *requestQueue
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// 不可重试的错误，例如服务端返回 4xx，说明请求本身有问题
+type unrecoverableError struct {
+	err error
+}
+
+func (e unrecoverableError) Error() string {
+	return e.err.Error()
+}
+
+func NewRemoteWriteSink(cfg config.RemoteWriteConfig) (*RemoteWriteSink, error) {
+	maxQueued := cfg.MaxQueuedRequests
+	if maxQueued <= 0 {
+		maxQueued = defaultMaxQueuedRequests
+	}
+	queue, err := newRequestQueue(cfg.QueueDir, maxQueued)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RemoteWriteSink{
+		cfg:        cfg,
+		client:     &http.Client{},
+		queue:      queue,
+		maxRetries: cfg.MaxRetries,
+		minBackoff: time.Duration(cfg.MinBackoffMs) * time.Millisecond,
+		maxBackoff: time.Duration(cfg.MaxBackoffMs) * time.Millisecond,
+	}
+	if s.maxRetries <= 0 {
+		s.maxRetries = defaultMaxRetries
+	}
+	if s.minBackoff <= 0 {
+		s.minBackoff = defaultMinBackoff
+	}
+	if s.maxBackoff < s.minBackoff {
+		s.maxBackoff = defaultMaxBackoff
+	}
+	return s, nil
+}
+
+func (s *RemoteWriteSink) Name() string {
+	return "remote_write"
+}
+
+// Push 把本轮的指标编码后加入队列，然后按顺序发送队列中的全部请求
+func (s *RemoteWriteSink) Push(ctx context.Context, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	series := toSeries(flatten(families), s.cfg.ExternalLabels, time.Now().UnixMilli())
+	body := snappy.Encode(nil, marshalWriteRequest(series))
+	if err := s.queue.add(body); err != nil {
+		return fmt.Errorf("remote_write 请求入队失败: %w", err)
+	}
+
+	for _, entry := range s.queue.snapshot() {
+		err := s.sendWithRetry(ctx, entry.body)
+		var unrecoverable unrecoverableError
+		if errors.As(err, &unrecoverable) {
+			zap.S().Errorf("remote_write 请求 %s 被服务端拒绝，已丢弃: %v", entry.id, err)
+			s.queue.remove(entry.id)
+			continue
+		}
+		if err != nil {
+			// 保留剩余请求，下一轮继续发送
+			return err
+		}
+		s.queue.remove(entry.id)
+	}
+	return nil
+}
+
+func (s *RemoteWriteSink) sendWithRetry(ctx context.Context, body []byte) error {
+	backoff := s.minBackoff
+	var err error
+	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w（最后一次错误: %v）", ctx.Err(), err)
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > s.maxBackoff {
+				backoff = s.maxBackoff
+			}
+		}
+
+		err = s.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+		var unrecoverable unrecoverableError
+		if errors.As(err, &unrecoverable) {
+			return err
+		}
+		zap.S().Warnf("remote_write 发送失败（第 %d 次尝试）: %v", attempt+1, err)
+	}
+	return err
+}
+
+func (s *RemoteWriteSink) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return unrecoverableError{err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "oneclick-metrics-go")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.BearerToken)
+	} else if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("服务端返回状态码 %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	// 与 Prometheus 一致：5xx 和 429 可以重试，其余 4xx 不可重试
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return unrecoverableError{err}
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package push
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"strconv"
+)
+
+// 展开后的单个样本
+type sample struct {
+	name   string
+	labels []*dto.LabelPair
+	value  float64
+}
+
+// 把 MetricFamily 展开为样本，histogram 和 summary 只保留 _sum 与 _count
+func flatten(families []*dto.MetricFamily) []sample {
+	var samples []sample
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, metric := range mf.GetMetric() {
+			labels := metric.GetLabel()
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				samples = append(samples, sample{name, labels, metric.GetGauge().GetValue()})
+			case dto.MetricType_COUNTER:
+				samples = append(samples, sample{name, labels, metric.GetCounter().GetValue()})
+			case dto.MetricType_UNTYPED:
+				samples = append(samples, sample{name, labels, metric.GetUntyped().GetValue()})
+			case dto.MetricType_SUMMARY:
+				samples = append(samples,
+					sample{name + "_sum", labels, metric.GetSummary().GetSampleSum()},
+					sample{name + "_count", labels, float64(metric.GetSummary().GetSampleCount())})
+			case dto.MetricType_HISTOGRAM:
+				samples = append(samples,
+					sample{name + "_sum", labels, metric.GetHistogram().GetSampleSum()},
+					sample{name + "_count", labels, float64(metric.GetHistogram().GetSampleCount())})
+			}
+		}
+	}
+	return samples
+}
+
+// 把样本值格式化为文本，NaN 和 Inf 返回 false，由调用方跳过
+func formatValue(v float64) (string, bool) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", false
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64), true
+}
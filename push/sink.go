@@ -0,0 +1,78 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"oneclick-metrics-go/config"
+	"time"
+)
+
+// 未配置超时时间时使用的默认值
+const defaultTimeout = 10 * time.Second
+
+// Sink 在每轮采集结束后接收 registry 中的全部指标
+type Sink interface {
+	Name() string
+	Push(ctx context.Context, g prometheus.Gatherer) error
+}
+
+// FromConfig 根据配置创建所有启用的推送目标
+func FromConfig(cfg config.PushConfig) ([]Sink, error) {
+	var sinks []Sink
+	if cfg.Pushgateway.URL != "" {
+		sinks = append(sinks, NewPushgatewaySink(cfg.Pushgateway))
+	}
+	if cfg.Graphite.Address != "" {
+		sinks = append(sinks, NewGraphiteSink(cfg.Graphite))
+	}
+	if cfg.Influx.URL != "" {
+		sink, err := NewInfluxSink(cfg.Influx)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.RemoteWrite.URL != "" {
+		sink, err := NewRemoteWriteSink(cfg.RemoteWrite)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.OTLP.Endpoint != "" {
+		sink, err := NewOTLPSink(cfg.OTLP)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// Timeout 返回配置的单次推送超时时间
+func Timeout(cfg config.PushConfig) time.Duration {
+	if cfg.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(cfg.Timeout) * time.Second
+}
+
+// PushAll 依次推送到每个目标，单个目标失败不影响其他目标
+func PushAll(ctx context.Context, sinks []Sink, g prometheus.Gatherer, timeout time.Duration) error {
+	var errs []error
+	for _, sink := range sinks {
+		pushCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := sink.Push(pushCtx, g)
+		cancel()
+		if err != nil {
+			zap.S().Errorf("[%s] 推送指标失败: %v", sink.Name(), err)
+			errs = append(errs, fmt.Errorf("[%s] %w", sink.Name(), err))
+			continue
+		}
+		zap.S().Debugf("[%s] 推送指标成功", sink.Name())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,39 @@
+package push
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"strings"
+	"testing"
+)
+
+// 各推送目标共用的 registry：
+//
+//	oneclick_test_gauge{empty="",project="PROJ",repo="my repo,a=b"} 2
+//	oneclick_test_gauge{empty="",project="OTHER",repo="nan"} NaN（跳过）
+//	oneclick_test_seconds 直方图，一个样本 0.5
+func testRegistry(t *testing.T) *prometheus.Registry {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "oneclick_test_gauge", Help: "test"}, []string{"project", "repo", "empty"})
+	g.WithLabelValues("PROJ", "my repo,a=b", "").Set(2)
+	g.WithLabelValues("OTHER", "nan", "").Set(math.NaN())
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "oneclick_test_seconds", Help: "test", Buckets: []float64{1}})
+	h.Observe(0.5)
+	reg.MustRegister(g, h)
+	return reg
+}
+
+// 去掉每行末尾的时间戳，返回剩余部分
+func stripTimestamps(t *testing.T, body string) []string {
+	t.Helper()
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("行中没有时间戳: %q", line)
+		}
+		lines = append(lines, line[:i])
+	}
+	return lines
+}
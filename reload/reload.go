@@ -0,0 +1,164 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/global"
+	"oneclick-metrics-go/initialize"
+	"oneclick-metrics-go/metrics"
+	"oneclick-metrics-go/secret"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Reloader 重新读取配置并交给采集循环应用，配置文件变化、SIGHUP 和 /-/reload 都通过它触发
+type Reloader struct {
+	requests chan metrics.ReloadRequest
+	mu       sync.Mutex // 同一时间只处理一次热加载
+}
+
+func New() *Reloader {
+	return &Reloader{requests: make(chan metrics.ReloadRequest)}
+}
+
+// Requests 返回传给 metrics.CollectMetrics 的热加载请求
+func (r *Reloader) Requests() <-chan metrics.ReloadRequest {
+	return r.requests
+}
+
+// Reload 读取并校验新配置，等待采集循环应用后返回，任何一步失败时保持原配置
+func (r *Reloader) Reload(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := initialize.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(metrics.KnownNames()); err != nil {
+		return err
+	}
+
+	// 只有采集循环会替换全局配置，而它只在处理本函数发出的请求时替换
+	current := global.ServerConfig.Load()
+	keepRestartOnly(current, cfg)
+	if reflect.DeepEqual(current, cfg) {
+		zap.S().Info("配置没有变化，跳过热加载")
+		return nil
+	}
+
+	req := metrics.ReloadRequest{Config: cfg, Done: make(chan error, 1)}
+	select {
+	case r.requests <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// 请求已被采集循环接收，必须等待结果，否则无法知道配置是否已经替换
+	if err := <-req.Done; err != nil {
+		return err
+	}
+
+	initialize.SetLogLevel(cfg.LogInfo.Level)
+	secret.RegisterConfig(cfg)
+	return nil
+}
+
+// 这些配置在启动时使用一次，修改后需要重启才能生效，热加载时保留原值并提示
+func keepRestartOnly(current, next *config.ServerConfig) {
+	restartOnly := []struct {
+		name      string
+		cur, next interface{}
+	}{
+		{"host", &current.Host, &next.Host},
+		{"port", &current.Port, &next.Port},
+		{"disable_metrics_endpoint", &current.DisableMetrics, &next.DisableMetrics},
+		{"watch_config", &current.WatchConfig, &next.WatchConfig},
+		{"database", &current.DataBaseInfo, &next.DataBaseInfo},
+		{"instances", &current.Instances, &next.Instances},
+		{"push", &current.Push, &next.Push},
+		{"history", &current.History, &next.History},
+		{"web", &current.Web, &next.Web},
+		{"log", &current.LogInfo, &next.LogInfo},
+	}
+	// log 中只有 level 可以热加载，比较前先使用相同的 level
+	level := next.LogInfo.Level
+	next.LogInfo.Level = current.LogInfo.Level
+	for _, f := range restartOnly {
+		cur, nxt := reflect.ValueOf(f.cur).Elem(), reflect.ValueOf(f.next).Elem()
+		if !reflect.DeepEqual(cur.Interface(), nxt.Interface()) {
+			zap.S().Warnf("配置项 %s 的修改需要重启才能生效", f.name)
+			nxt.Set(cur)
+		}
+	}
+	next.LogInfo.Level = level
+}
+
+func (r *Reloader) reloadAndLog(ctx context.Context, trigger string) {
+	zap.S().Infof("收到 %s，重新加载配置", trigger)
+	if err := r.Reload(ctx); err != nil {
+		zap.S().Errorf("重新加载配置失败，继续使用原配置: %v", err)
+	}
+}
+
+// HandleSignals 收到 SIGHUP 时重新加载配置，ctx 结束后返回
+func (r *Reloader) HandleSignals(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ch:
+			r.reloadAndLog(ctx, "SIGHUP")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Watch 监听配置文件变化，没有使用配置文件时不做任何事
+func (r *Reloader) Watch(ctx context.Context) error {
+	path := initialize.ConfigFile()
+	if path == "" {
+		return nil
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("监听配置文件 %s 失败: %w", path, err)
+	}
+	v.OnConfigChange(func(e fsnotify.Event) {
+		r.reloadAndLog(ctx, "配置文件变化 "+e.Name)
+	})
+	v.WatchConfig()
+	zap.S().Infof("监听配置文件变化: %s", path)
+	return nil
+}
+
+// Register 注册 POST/PUT /-/reload
+func (r *Reloader) Register(mux *http.ServeMux) {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		zap.S().Info("收到 /-/reload 请求，重新加载配置")
+		if err := r.Reload(req.Context()); err != nil {
+			zap.S().Errorf("重新加载配置失败，继续使用原配置: %v", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, context.Canceled) {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, "failed to reload config: "+err.Error(), status)
+			return
+		}
+		fmt.Fprintln(w, "config reloaded")
+	}
+	mux.HandleFunc("POST /-/reload", handler)
+	mux.HandleFunc("PUT /-/reload", handler)
+}
@@ -0,0 +1,82 @@
+package reload
+
+import (
+	"oneclick-metrics-go/config"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestKeepRestartOnly(t *testing.T) {
+	current := config.ServerConfig{
+		Port:         "8000",
+		Interval:     15,
+		DataBaseInfo: config.DataBase{Host: "db", User: "u"},
+		Instances:    []config.InstanceConfig{{Name: "prod", Database: config.DataBase{Host: "prod"}}},
+		LogInfo:      config.LogConfig{Level: "info", Format: "console"},
+		ReportKeys:   []string{"ci"},
+		Web:          config.WebConfig{EnableLifecycle: true},
+	}
+	tests := []struct {
+		name   string
+		modify func(c *config.ServerConfig)
+		want   func(c *config.ServerConfig) // 期望的结果相对 current 的修改
+		warned []string                     // 提示需要重启的配置项
+	}{
+		{"可以热加载的配置", func(c *config.ServerConfig) {
+			c.Interval, c.ReportKeys, c.LogInfo.Level = 30, []string{"ci", "sast"}, "debug"
+		}, func(c *config.ServerConfig) {
+			c.Interval, c.ReportKeys, c.LogInfo.Level = 30, []string{"ci", "sast"}, "debug"
+		}, nil},
+		{"需要重启的配置保留原值", func(c *config.ServerConfig) {
+			c.Port = "9000"
+			c.DataBaseInfo.Host = "other"
+			c.Instances = nil
+			c.Web.EnableLifecycle = false
+			c.Interval = 30
+		}, func(c *config.ServerConfig) {
+			c.Interval = 30
+		}, []string{"port", "database", "instances", "web"}},
+		{"log 中只有 level 可以热加载", func(c *config.ServerConfig) {
+			c.LogInfo.Level, c.LogInfo.Format = "warn", "json"
+		}, func(c *config.ServerConfig) {
+			c.LogInfo.Level = "warn"
+		}, []string{"log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			defer zap.ReplaceGlobals(zap.New(core))()
+
+			cur := current
+			cur.Instances = append([]config.InstanceConfig(nil), current.Instances...)
+			next := cur
+			next.Instances = append([]config.InstanceConfig(nil), cur.Instances...)
+			tt.modify(&next)
+			keepRestartOnly(&cur, &next)
+
+			want := current
+			tt.want(&want)
+			if !reflect.DeepEqual(next, want) {
+				t.Errorf("keepRestartOnly() = %+v\nwant %+v", next, want)
+			}
+			if !reflect.DeepEqual(cur, current) {
+				t.Errorf("修改了当前配置: %+v", cur)
+			}
+			var warned []string
+			for _, e := range logs.All() {
+				warned = append(warned, e.Message)
+			}
+			var wantWarned []string
+			for _, name := range tt.warned {
+				wantWarned = append(wantWarned, "配置项 "+name+" 的修改需要重启才能生效")
+			}
+			if !reflect.DeepEqual(warned, wantWarned) {
+				t.Errorf("警告 = %v, want %v", warned, wantWarned)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package secret
+
+import (
+	"bufio"
+	"fmt"
+	"oneclick-metrics-go/config"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// libpq 的默认值，pgpass 匹配时使用
+const (
+	defaultPgHost = "localhost"
+	defaultPgPort = "5432"
+)
+
+func homeFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, name)
+}
+
+// 按 libpq 的规则查找 pgpass 文件中的密码：每行 hostname:port:database:username:password，
+// * 匹配任意值，\ 转义 : 和 \，使用第一条匹配的记录
+func lookupPgpass(db config.DataBase) (string, bool, error) {
+	path := db.PgpassFile
+	if path == "" {
+		path = os.Getenv("PGPASSFILE")
+	}
+	if path == "" {
+		path = homeFile(".pgpass")
+	}
+	if path == "" {
+		return "", false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && db.PgpassFile == "" {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("读取 pgpass 文件失败: %w", err)
+	}
+	defer f.Close()
+
+	// 与 libpq 一样，忽略其他用户可读的 pgpass 文件
+	if info, err := f.Stat(); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		zap.S().Warnf("pgpass 文件 %s 的权限为 %v，应为 0600 或更严格，已忽略", path, info.Mode().Perm())
+		return "", false, nil
+	}
+
+	host, port := db.Host, db.Port
+	if host == "" {
+		host = defaultPgHost
+	}
+	if port == "" {
+		port = defaultPgPort
+	}
+	want := []string{host, port, db.Dbname, db.User}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matched := true
+		for i, w := range want {
+			if fields[i] != "*" && fields[i] != w {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return fields[4], true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
+
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var cur strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case c == ':' && len(fields) < 4:
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(fields, cur.String())
+}
+
+// 将 pg_service.conf 中服务的配置合并到没有设置的字段上。服务名取 service 配置或 PGSERVICE，
+// 文件取 PGSERVICEFILE 或 ~/.pg_service.conf
+func applyService(db *config.DataBase) error {
+	name := db.Service
+	if name == "" {
+		name = os.Getenv("PGSERVICE")
+	}
+	if name == "" {
+		return nil
+	}
+
+	path := os.Getenv("PGSERVICEFILE")
+	if path == "" {
+		path = homeFile(".pg_service.conf")
+	}
+	params, err := readService(path, name)
+	if err != nil {
+		return err
+	}
+
+	fill := func(field *string, key string) {
+		if *field == "" {
+			*field = params[key]
+		}
+	}
+	fill(&db.Host, "host")
+	fill(&db.Port, "port")
+	fill(&db.Dbname, "dbname")
+	fill(&db.User, "user")
+	fill(&db.Password, "password")
+	fill(&db.SSLRootCert, "sslrootcert")
+	fill(&db.SSLCert, "sslcert")
+	fill(&db.SSLKey, "sslkey")
+	fill(&db.SearchPath, "search_path")
+	return nil
+}
+
+// 读取 ini 格式的服务文件中指定服务的参数
+func readService(path, name string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取服务文件失败: %w", err)
+	}
+	defer f.Close()
+
+	var params map[string]string
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			if section == name {
+				params = map[string]string{}
+			}
+			continue
+		}
+		if section != name {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if params == nil {
+		return nil, fmt.Errorf("服务文件 %s 中没有服务 %s", path, name)
+	}
+	if p := params["password"]; p != "" {
+		Register(p)
+	}
+	return params, nil
+}
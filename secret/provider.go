@@ -0,0 +1,208 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"oneclick-metrics-go/config"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 执行 password_command 的超时时间
+const commandTimeout = 10 * time.Second
+
+// Provider 提供数据库密码，ok 为 false 表示该来源没有配置或没有找到密码
+type Provider interface {
+	Name() string
+	Password(ctx context.Context, db config.DataBase) (password string, ok bool, err error)
+}
+
+// Providers 按优先级返回所有密码来源
+func Providers() []Provider {
+	return []Provider{
+		commandProvider{},
+		fileProvider{},
+		envProvider{},
+		configProvider{},
+		pgpassProvider{},
+	}
+}
+
+// 密码可能在进程运行期间轮换的来源，Cache 缓存这些来源的结果，认证失败时重新获取
+type rotatingProvider interface {
+	rotates()
+}
+
+type commandProvider struct{}
+
+func (commandProvider) Name() string { return "password_command" }
+
+func (commandProvider) rotates() {}
+
+func (commandProvider) Password(ctx context.Context, db config.DataBase) (string, bool, error) {
+	if db.PasswordCommand == "" {
+		return "", false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", db.PasswordCommand)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", false, fmt.Errorf("执行 password_command 失败: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), true, nil
+}
+
+type fileProvider struct{}
+
+func (fileProvider) Name() string { return "password_file" }
+
+func (fileProvider) rotates() {}
+
+func (fileProvider) Password(_ context.Context, db config.DataBase) (string, bool, error) {
+	if db.PasswordFile == "" {
+		return "", false, nil
+	}
+	b, err := os.ReadFile(db.PasswordFile)
+	if err != nil {
+		return "", false, fmt.Errorf("读取 password_file 失败: %w", err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+type envProvider struct{}
+
+func (envProvider) Name() string { return "password_env" }
+
+func (envProvider) Password(_ context.Context, db config.DataBase) (string, bool, error) {
+	if db.PasswordEnv == "" {
+		return "", false, nil
+	}
+	v, ok := os.LookupEnv(db.PasswordEnv)
+	if !ok {
+		return "", false, fmt.Errorf("password_env 指定的环境变量 %s 不存在", db.PasswordEnv)
+	}
+	return v, true, nil
+}
+
+type configProvider struct{}
+
+func (configProvider) Name() string { return "password" }
+
+func (configProvider) Password(_ context.Context, db config.DataBase) (string, bool, error) {
+	return db.Password, db.Password != "", nil
+}
+
+type pgpassProvider struct{}
+
+func (pgpassProvider) Name() string { return "pgpass" }
+
+func (pgpassProvider) Password(_ context.Context, db config.DataBase) (string, bool, error) {
+	return lookupPgpass(db)
+}
+
+// Cache 缓存 password_command 和 password_file 提供的密码，同一个连接池的多个 host 共用一份，
+// 避免每次新建连接都执行命令。其他来源的密码与 host 有关（pgpass）或读取开销很小，每次重新获取
+type Cache struct {
+	mu       sync.Mutex
+	password string
+	source   string // 为空表示没有缓存
+}
+
+// Resolve 与包级别的 Resolve 相同，但优先使用缓存的密码
+func (c *Cache) Resolve(ctx context.Context, db config.DataBase) (config.DataBase, error) {
+	return resolve(ctx, db, c)
+}
+
+// Invalidate 清除缓存的密码，下次 Resolve 时重新获取。没有缓存时返回 false，重新获取也不会得到不同的密码
+func (c *Cache) Invalidate() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached := c.source != ""
+	c.password, c.source = "", ""
+	return cached
+}
+
+// 从 p 获取密码，p 的密码会轮换且 c 不为空时使用缓存
+func (c *Cache) lookup(ctx context.Context, p Provider, db config.DataBase) (string, bool, error) {
+	if _, ok := p.(rotatingProvider); !ok || c == nil {
+		return p.Password(ctx, db)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.source == p.Name() {
+		return c.password, true, nil
+	}
+	password, ok, err := p.Password(ctx, db)
+	if err != nil || !ok {
+		return password, ok, err
+	}
+	zap.S().Infof("使用 %s 提供的数据库密码", p.Name())
+	c.password, c.source = password, p.Name()
+	return password, true, nil
+}
+
+// Resolve 补全数据库配置：没有配置 url 时先合并 pg_service.conf 中的服务配置，再按优先级查找密码。
+// 配置了 url 时，从 url 中取连接参数，url 中没有密码时把找到的密码写入 url
+func Resolve(ctx context.Context, db config.DataBase) (config.DataBase, error) {
+	return resolve(ctx, db, nil)
+}
+
+func resolve(ctx context.Context, db config.DataBase, cache *Cache) (config.DataBase, error) {
+	var u *url.URL
+	if db.URL != "" {
+		var err error
+		if u, err = url.Parse(db.URL); err != nil {
+			return db, errors.New("database.url 不是合法的 URL")
+		}
+		if u.User != nil {
+			db.User = u.User.Username()
+			if p, ok := u.User.Password(); ok {
+				Register(p)
+				return db, nil
+			}
+		}
+		db.Host, db.Port, db.Dbname = u.Hostname(), u.Port(), strings.TrimPrefix(u.Path, "/")
+	}
+
+	if u == nil {
+		if err := applyService(&db); err != nil {
+			return db, err
+		}
+	}
+
+	for _, p := range Providers() {
+		password, ok, err := cache.lookup(ctx, p, db)
+		if err != nil {
+			return db, err
+		}
+		if !ok {
+			continue
+		}
+		// 使用缓存时每次新建连接都会执行到这里，只有 lookup 重新获取密码时记录 info 日志
+		if cache == nil {
+			zap.S().Infof("使用 %s 提供的数据库密码", p.Name())
+		} else {
+			zap.S().Debugf("使用 %s 提供的数据库密码", p.Name())
+		}
+		Register(password)
+		db.Password = password
+		break
+	}
+
+	if u != nil && db.Password != "" {
+		u.User = url.UserPassword(db.User, db.Password)
+		db.URL = u.String()
+	}
+	return db, nil
+}
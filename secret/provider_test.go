@@ -0,0 +1,141 @@
+package secret
+
+import (
+	"context"
+	"oneclick-metrics-go/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 缓存 password_file 的密码，Invalidate 后重新读取轮换后的文件
+func TestCachePasswordFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	writeFile(t, file, "first-secret\n")
+	db := config.DataBase{Host: "db", Port: "5432", User: "u", PasswordFile: file}
+	ctx := context.Background()
+
+	var cache Cache
+	if got, err := cache.Resolve(ctx, db); err != nil || got.Password != "first-secret" {
+		t.Fatalf("Resolve = %q, %v", got.Password, err)
+	}
+	writeFile(t, file, "second-secret\n")
+	if got, _ := cache.Resolve(ctx, db); got.Password != "first-secret" {
+		t.Fatalf("没有使用缓存的密码: %q", got.Password)
+	}
+	if !cache.Invalidate() {
+		t.Fatal("有缓存时 Invalidate 应返回 true")
+	}
+	if got, _ := cache.Resolve(ctx, db); got.Password != "second-secret" {
+		t.Fatalf("Invalidate 后没有重新读取文件: %q", got.Password)
+	}
+}
+
+// 同一个缓存的多个 host 只执行一次 password_command
+func TestCachePasswordCommandOncePerCache(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "runs")
+	db := config.DataBase{User: "u", PasswordCommand: "echo x >> " + counter + "; echo cmd-secret"}
+	ctx := context.Background()
+
+	var cache Cache
+	for _, host := range []string{"db1", "db2", "db3"} {
+		db.Host = host
+		if got, err := cache.Resolve(ctx, db); err != nil || got.Password != "cmd-secret" {
+			t.Fatalf("[%s] Resolve = %q, %v", host, got.Password, err)
+		}
+	}
+	b, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(b), "x"); runs != 1 {
+		t.Fatalf("password_command 执行了 %d 次", runs)
+	}
+}
+
+// 只有 password_env 或 password 时没有可以重新获取的缓存
+func TestCacheInvalidateWithoutRotatingSource(t *testing.T) {
+	var cache Cache
+	if _, err := cache.Resolve(context.Background(), config.DataBase{User: "u", Password: "plain-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Invalidate() {
+		t.Fatal("没有缓存时 Invalidate 应返回 false")
+	}
+}
+
+// 按 password_command、password_file、password_env、password、pgpass 的顺序使用第一个提供了密码的来源
+func TestResolveProviderOrder(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+	writeFile(t, file, "file-secret\n")
+	pgpass := filepath.Join(dir, "pgpass")
+	writeFile(t, pgpass, "db:5432:bb:u:pgpass-secret\n*:*:*:*:wildcard-secret\n")
+	services := filepath.Join(dir, "pg_service.conf")
+	writeFile(t, services, "[bitbucket]\nhost=svc-db\ndbname=bb\npassword=service-secret\n")
+	t.Setenv("ONECLICK_TEST_PASSWORD", "env-secret")
+	t.Setenv("PGSERVICE", "")
+	t.Setenv("PGSERVICEFILE", services)
+
+	base := config.DataBase{Host: "db", Port: "5432", User: "u", Dbname: "bb", PgpassFile: pgpass}
+	with := func(modify func(db *config.DataBase)) config.DataBase {
+		db := base
+		modify(&db)
+		return db
+	}
+	tests := []struct {
+		name     string
+		db       config.DataBase
+		password string
+		url      string
+		err      string // 错误应包含的内容，为空表示成功
+	}{
+		{"password_command 优先", with(func(db *config.DataBase) {
+			db.PasswordCommand, db.PasswordFile, db.PasswordEnv, db.Password = "echo cmd-secret", file, "ONECLICK_TEST_PASSWORD", "plain-secret"
+		}), "cmd-secret", "", ""},
+		{"password_file 其次", with(func(db *config.DataBase) {
+			db.PasswordFile, db.PasswordEnv, db.Password = file, "ONECLICK_TEST_PASSWORD", "plain-secret"
+		}), "file-secret", "", ""},
+		{"password_env 优先于明文密码", with(func(db *config.DataBase) {
+			db.PasswordEnv, db.Password = "ONECLICK_TEST_PASSWORD", "plain-secret"
+		}), "env-secret", "", ""},
+		{"明文密码优先于 pgpass", with(func(db *config.DataBase) { db.Password = "plain-secret" }), "plain-secret", "", ""},
+		{"pgpass", base, "pgpass-secret", "", ""},
+		{"pgpass 通配符", with(func(db *config.DataBase) { db.Host = "other" }), "wildcard-secret", "", ""},
+		{"pg_service.conf 中的密码", with(func(db *config.DataBase) { db.Host, db.Service = "", "bitbucket" }), "service-secret", "", ""},
+		{"password_file 优先于服务中的密码", with(func(db *config.DataBase) { db.Service, db.PasswordFile = "bitbucket", file }), "file-secret", "", ""},
+		{"url 中的密码", config.DataBase{URL: "postgres://u:url-secret@db/bb", PasswordCommand: "echo cmd-secret"}, "", "postgres://u:url-secret@db/bb", ""},
+		{"url 没有密码时写入找到的密码", config.DataBase{URL: "postgres://u@db:5432/bb", PasswordFile: file}, "file-secret", "postgres://u:file-secret@db:5432/bb", ""},
+		{"password_command 失败", with(func(db *config.DataBase) { db.PasswordCommand, db.Password = "echo denied >&2; exit 1", "plain-secret" }), "", "", "denied"},
+		{"password_env 不存在", with(func(db *config.DataBase) { db.PasswordEnv = "ONECLICK_TEST_MISSING" }), "", "", "ONECLICK_TEST_MISSING"},
+		{"password_file 不存在", with(func(db *config.DataBase) { db.PasswordFile = filepath.Join(dir, "missing") }), "", "", "读取 password_file 失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(context.Background(), tt.db)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Resolve() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if got.Password != tt.password {
+				t.Errorf("Password = %q, want %q", got.Password, tt.password)
+			}
+			if got.URL != tt.url {
+				t.Errorf("URL = %q, want %q", got.URL, tt.url)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
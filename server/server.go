@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"oneclick-metrics-go/config"
+	"oneclick-metrics-go/secret"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"go.uber.org/zap"
+	"go.uber.org/zap/exp/zapslog"
+	"gopkg.in/yaml.v2"
+)
+
+// Validate 检查 web 配置文件（证书、私钥、basic_auth_users 的 bcrypt 哈希等）和 bearer token 文件
+func Validate(cfg config.WebConfig) error {
+	if cfg.ConfigFile != "" {
+		if err := web.Validate(cfg.ConfigFile); err != nil {
+			return fmt.Errorf("web.config_file %s 不合法: %w", cfg.ConfigFile, err)
+		}
+	}
+	if cfg.BearerTokenFile == "" {
+		return nil
+	}
+	tokens, err := readTokens(cfg.BearerTokenFile)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("web.bearer_token_file %s 中没有 token", cfg.BearerTokenFile)
+	}
+	if cfg.ConfigFile != "" {
+		// basic auth 由 exporter-toolkit 在外层校验，同时配置时两种认证都要通过，客户端无法满足
+		users, err := basicAuthUsers(cfg.ConfigFile)
+		if err != nil {
+			return err
+		}
+		if users > 0 {
+			return fmt.Errorf("web.bearer_token_file 不能与 %s 中的 basic_auth_users 同时使用", cfg.ConfigFile)
+		}
+	}
+	return nil
+}
+
+func basicAuthUsers(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("读取 web.config_file 失败: %w", err)
+	}
+	var c web.Config
+	if err := yaml.Unmarshal(content, &c); err != nil {
+		return 0, fmt.Errorf("解析 web.config_file 失败: %w", err)
+	}
+	return len(c.Users), nil
+}
+
+// ListenAndServe 在 addr 上提供 handler 的服务。TLS、mTLS 和 basic auth 由 exporter-toolkit 按 web.config_file 处理，
+// 配置文件和证书在每次连接时重新读取，替换证书不需要重启；配置了 bearer_token_file 时所有请求都需要 Authorization: Bearer
+func ListenAndServe(cfg config.WebConfig, addr string, handler http.Handler) error {
+	if err := Validate(cfg); err != nil {
+		return err
+	}
+	if cfg.BearerTokenFile != "" {
+		handler = bearerAuth(&tokenFile{path: cfg.BearerTokenFile}, handler)
+	}
+
+	listen := []string{addr}
+	systemdSocket := false
+	flags := &web.FlagConfig{
+		WebListenAddresses: &listen,
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      &cfg.ConfigFile,
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second, ErrorLog: zap.NewStdLog(zap.L())}
+	// exporter-toolkit 使用 slog 输出日志，这里转到全局 zap logger
+	logger := slog.New(zapslog.NewHandler(zap.L().Core(), zapslog.WithName("web")))
+	return web.ListenAndServe(srv, flags, logger)
+}
+
+func bearerAuth(tokens *tokenFile, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && tokens.valid(strings.TrimSpace(token)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oneclick-metrics"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// tokenFile 缓存 bearer token 文件内容，文件修改时间变化后重新读取
+type tokenFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	tokens  []string
+}
+
+func (f *tokenFile) valid(token string) bool {
+	if token == "" {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if info, err := os.Stat(f.path); err != nil {
+		zap.S().Errorf("读取 bearer token 文件失败，继续使用上次的内容: %v", err)
+	} else if !info.ModTime().Equal(f.modTime) {
+		tokens, err := readTokens(f.path)
+		if err != nil {
+			zap.S().Errorf("读取 bearer token 文件失败，继续使用上次的内容: %v", err)
+		} else {
+			f.tokens, f.modTime = tokens, info.ModTime()
+			zap.S().Infof("已加载 %d 个 bearer token: %s", len(tokens), f.path)
+		}
+	}
+
+	// 逐个比较且不提前返回，耗时与匹配位置无关
+	found := 0
+	for _, t := range f.tokens {
+		found |= subtle.ConstantTimeCompare([]byte(t), []byte(token))
+	}
+	return found == 1
+}
+
+// LifecycleDisabled 没有开启 web.enable_lifecycle 时，修改运行状态的接口返回 403
+func LifecycleDisabled(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, "lifecycle API is not enabled, set web.enable_lifecycle to true", http.StatusForbidden)
+}
+
+// 每行一个 token，忽略空行和 # 开头的注释
+func readTokens(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 web.bearer_token_file 失败: %w", err)
+	}
+	defer file.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取 web.bearer_token_file 失败: %w", err)
+	}
+	secret.Register(tokens...)
+	return tokens, nil
+}
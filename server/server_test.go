@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oneclick-metrics-go/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBearerAuth(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens")
+	writeTokens(t, file, "# prometheus\nprom-token\n\n  grafana-token  \n", time.Now())
+	handler := bearerAuth(&tokenFile{path: file}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"正确的 token", "Bearer prom-token", http.StatusOK},
+		{"第二个 token，去掉首尾空格", "Bearer grafana-token", http.StatusOK},
+		{"token 后的空格", "Bearer prom-token ", http.StatusOK},
+		{"没有 Authorization", "", http.StatusUnauthorized},
+		{"错误的 token", "Bearer other-token", http.StatusUnauthorized},
+		{"token 的前缀", "Bearer prom", http.StatusUnauthorized},
+		{"注释不是 token", "Bearer # prometheus", http.StatusUnauthorized},
+		{"空 token", "Bearer ", http.StatusUnauthorized},
+		{"basic auth", "Basic cHJvbS10b2tlbjo=", http.StatusUnauthorized},
+		{"小写的 bearer", "bearer prom-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(handler, tt.authorization); got.Code != tt.want {
+				t.Fatalf("状态码 = %d, want %d", got.Code, tt.want)
+			} else if got.Code == http.StatusUnauthorized && !strings.HasPrefix(got.Header().Get("WWW-Authenticate"), "Bearer ") {
+				t.Errorf("WWW-Authenticate = %q", got.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// 文件修改后下一次请求即使用新的 token，文件无法读取时继续使用上次的内容
+func TestBearerAuthReloadsTokenFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens")
+	start := time.Now().Add(-time.Hour)
+	writeTokens(t, file, "old-token\n", start)
+	handler := bearerAuth(&tokenFile{path: file}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	steps := []struct {
+		name   string
+		update func()
+		token  string
+		want   int
+	}{
+		{"初始 token", nil, "old-token", http.StatusOK},
+		{"替换后旧 token 失效", func() { writeTokens(t, file, "new-token\n", start.Add(time.Minute)) }, "old-token", http.StatusUnauthorized},
+		{"替换后新 token 生效", nil, "new-token", http.StatusOK},
+		{"删除文件后继续使用上次的内容", func() { os.Remove(file) }, "new-token", http.StatusOK},
+		{"重新创建文件", func() { writeTokens(t, file, "third-token\n", start.Add(2*time.Minute)) }, "third-token", http.StatusOK},
+	}
+	for _, s := range steps {
+		if s.update != nil {
+			s.update()
+		}
+		if got := serve(handler, "Bearer "+s.token).Code; got != s.want {
+			t.Fatalf("%s: 状态码 = %d, want %d", s.name, got, s.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	writeTokens(t, tokens, "prom-token\n", time.Now())
+	empty := filepath.Join(dir, "empty")
+	writeTokens(t, empty, "# 没有 token\n", time.Now())
+	basic := filepath.Join(dir, "web.yml")
+	writeTokens(t, basic, "basic_auth_users:\n  prometheus: $2y$10$2b2cU8CPhOTaGrs1HRQuAueS7JTT5ZHsHSzYiFPm1leZck7Mc8T4W\n", time.Now())
+
+	tests := []struct {
+		name string
+		cfg  config.WebConfig
+		err  string // 错误应包含的内容，为空表示合法
+	}{
+		{"不认证", config.WebConfig{}, ""},
+		{"bearer token", config.WebConfig{BearerTokenFile: tokens}, ""},
+		{"basic auth", config.WebConfig{ConfigFile: basic}, ""},
+		{"token 文件不存在", config.WebConfig{BearerTokenFile: filepath.Join(dir, "missing")}, "读取 web.bearer_token_file 失败"},
+		{"token 文件为空", config.WebConfig{BearerTokenFile: empty}, "中没有 token"},
+		{"同时使用两种认证", config.WebConfig{ConfigFile: basic, BearerTokenFile: tokens}, "不能与"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.cfg)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Validate() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func serve(handler http.Handler, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// 写入 token 文件并设置修改时间，避免文件系统时间精度导致修改没有被发现
+func writeTokens(t *testing.T, name, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,272 @@
+package synth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"math/rand"
+	"time"
+)
+
+// 报告的 RESULT_ID，与 result_report_query 中的 status 对应
+const (
+	resultFailure = 0
+	resultSuccess = 1
+)
+
+// Options 生成数据的规模和分布，比例均为 0 到 1 之间的概率
+type Options struct {
+	Projects        int
+	ReposPerProject int
+	PRsPerRepo      int
+	ReportKeys      []string
+
+	OpenRatio   float64 // open 状态的 pr 比例
+	MergedRatio float64 // merged 状态的 pr 比例，其余为 declined
+
+	// 已关闭的 pr 中，关闭时间分布在生成后 ClosingMinutes 分钟内的比例，其余在过去 30 天内。
+	// "最近一分钟关闭的 pr" 相关的查询只统计这部分 pr，生成后的一段时间内每分钟都能查到数据
+	ClosingRatio   float64
+	ClosingMinutes int
+
+	RequiredRatio     float64 // 每个仓库要求每种报告（merge check）的概率
+	ReportRatio       float64 // 每个 pr 提供每种报告的概率
+	SuccessRatio      float64 // 报告结果为成功的概率，其余为失败
+	NotAvailableRatio float64 // 报告没有结果（RESULT_ID 为空）的概率
+	CoverageKey       string  // 带有 Code Coverage 数据的报告类型
+
+	Seed     int64          // 相同的 Seed 和参数生成相同的数据
+	Location *time.Location // 与配置中的 timezone 相同，closed_timestamp 按该时区写入
+}
+
+// DefaultOptions 返回一个适合本地演示的小规模数据集
+func DefaultOptions() Options {
+	return Options{
+		Projects:          5,
+		ReposPerProject:   4,
+		PRsPerRepo:        50,
+		OpenRatio:         0.3,
+		MergedRatio:       0.5,
+		ClosingRatio:      0.2,
+		ClosingMinutes:    60,
+		RequiredRatio:     0.5,
+		ReportRatio:       0.8,
+		SuccessRatio:      0.7,
+		NotAvailableRatio: 0.05,
+		CoverageKey:       "codecoverage",
+		Seed:              1,
+		Location:          time.UTC,
+	}
+}
+
+// Stats 每张表写入的行数
+type Stats struct {
+	Projects     int
+	Repositories int
+	PullRequests int
+	Reports      int
+	MergeChecks  int
+}
+
+type repo struct {
+	id, projectID int64
+	slug          string
+}
+
+type pullRequest struct {
+	id, scopedID int64
+	state        int
+	hash         string
+	created      time.Time
+	closed       *time.Time
+}
+
+// Generate 在一个事务中用 COPY 写入模拟数据，需要先调用 CreateSchema
+func Generate(ctx context.Context, db *sql.DB, opts Options) (Stats, error) {
+	var stats Stats
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	now := time.Now()
+
+	var repos []repo
+	for p := 1; p <= opts.Projects; p++ {
+		for r := 1; r <= opts.ReposPerProject; r++ {
+			repos = append(repos, repo{id: int64(len(repos) + 1), projectID: int64(p), slug: fmt.Sprintf("repo-%03d", r)})
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	// COPY 同一时间只能有一个，pr 和报告分两次生成，每个仓库的随机数种子固定，两次得到相同的 pr
+	stats.Projects, err = copyRows(ctx, tx, "project", []string{"id", "project_key", "name"}, func(emit emitFunc) error {
+		for p := 1; p <= opts.Projects; p++ {
+			if err := emit(p, fmt.Sprintf("P%03d", p), fmt.Sprintf("Project %d", p)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Repositories, err = copyRows(ctx, tx, "repository", []string{"id", "slug", "name", "project_id"}, func(emit emitFunc) error {
+		for _, r := range repos {
+			if err := emit(r.id, r.slug, r.slug, r.projectID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	prColumns := []string{"id", "scoped_id", "pr_state", "from_repository_id", "to_repository_id", "from_hash", "title", "created_timestamp", "closed_timestamp"}
+	stats.PullRequests, err = copyRows(ctx, tx, "sta_pull_request", prColumns, func(emit emitFunc) error {
+		for _, r := range repos {
+			for _, pr := range opts.pullRequests(r, now) {
+				var closed any
+				if pr.closed != nil {
+					closed = *pr.closed
+				}
+				title := fmt.Sprintf("%s change %d", r.slug, pr.scopedID)
+				if err := emit(pr.id, pr.scopedID, pr.state, r.id, r.id, pr.hash, title, pr.created, closed); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	reportColumns := []string{"COMMIT_ID", "REPOSITORY_ID", "REPORT_KEY", "RESULT_ID", "DATA"}
+	stats.Reports, err = copyRows(ctx, tx, "AO_2AD648_INSIGHT_REPORT", reportColumns, func(emit emitFunc) error {
+		for _, r := range repos {
+			rnd := opts.random(r.id, 2)
+			for _, pr := range opts.pullRequests(r, now) {
+				for _, key := range opts.ReportKeys {
+					if rnd.Float64() >= opts.ReportRatio {
+						continue
+					}
+					var result any = resultFailure
+					switch x := rnd.Float64(); {
+					case x < opts.NotAvailableRatio:
+						result = nil
+					case x < opts.NotAvailableRatio+(1-opts.NotAvailableRatio)*opts.SuccessRatio:
+						result = resultSuccess
+					}
+					data := "[]"
+					if key == opts.CoverageKey {
+						data = fmt.Sprintf(`[{"title":"Code Coverage","value":%.1f,"type":"PERCENTAGE"}]`, 40+rnd.Float64()*60)
+					}
+					if err := emit(pr.hash, r.id, key, result, data); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	stats.MergeChecks, err = copyRows(ctx, tx, "AO_2AD648_MERGE_CHECK", []string{"RESOURCE_ID", "SCOPE_TYPE", "REPORT_KEY"}, func(emit emitFunc) error {
+		for _, r := range repos {
+			rnd := opts.random(r.id, 3)
+			for _, key := range opts.ReportKeys {
+				if rnd.Float64() < opts.RequiredRatio {
+					if err := emit(r.id, "REPOSITORY", key); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, tx.Commit()
+}
+
+// 每个仓库、每个用途使用独立的随机数序列，结果与生成顺序无关
+func (o Options) random(repoID int64, stream int64) *rand.Rand {
+	return rand.New(rand.NewSource(o.Seed*1_000_003 + repoID*7 + stream))
+}
+
+// 仓库 r 中的 pr，相同的参数总是得到相同的结果
+func (o Options) pullRequests(r repo, now time.Time) []pullRequest {
+	rnd := o.random(r.id, 1)
+	prs := make([]pullRequest, o.PRsPerRepo)
+	for i := range prs {
+		pr := pullRequest{
+			id:       (r.id-1)*int64(o.PRsPerRepo) + int64(i) + 1,
+			scopedID: int64(i + 1),
+			hash:     fmt.Sprintf("%016x%016x%08x", rnd.Uint64(), rnd.Uint64(), rnd.Uint32()),
+		}
+		switch x := rnd.Float64(); {
+		case x < o.OpenRatio:
+			pr.state = 0
+		case x < o.OpenRatio+o.MergedRatio:
+			pr.state = 1
+		default:
+			pr.state = 2
+		}
+
+		// 时间按配置的时区写入 timestamp 列，与查询中的 current_timestamp AT TIME ZONE 一致
+		var closed time.Time
+		if rnd.Float64() < o.ClosingRatio {
+			closed = now.Add(time.Duration(rnd.Int63n(int64(o.ClosingMinutes+1)*int64(time.Minute))) - time.Minute)
+		} else {
+			closed = now.Add(-time.Duration(rnd.Int63n(int64(30 * 24 * time.Hour))))
+		}
+		pr.created = wallClock(closed.Add(-time.Duration(rnd.Int63n(int64(14*24*time.Hour)))), o.Location)
+		if pr.state != 0 {
+			c := wallClock(closed, o.Location)
+			pr.closed = &c
+		}
+		prs[i] = pr
+	}
+	return prs
+}
+
+// timestamp without time zone 列会忽略写入值的时区偏移，转换为 loc 的时间后写入的就是 loc 的本地时间
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	return t.In(loc)
+}
+
+type emitFunc func(values ...any) error
+
+// 用 COPY 写入 rows 产生的所有行，返回行数
+func copyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows func(emit emitFunc) error) (int, error) {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return 0, fmt.Errorf("写入 %s 失败: %w", table, err)
+	}
+	defer stmt.Close()
+
+	n := 0
+	err = rows(func(values ...any) error {
+		n++
+		_, err := stmt.ExecContext(ctx, values...)
+		return err
+	})
+	if err != nil {
+		return n, fmt.Errorf("写入 %s 失败: %w", table, err)
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return n, fmt.Errorf("写入 %s 失败: %w", table, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,135 @@
+// Package synth 在本地 PostgreSQL 中创建 exporter 读取的 Bitbucket 表结构，并生成可配置的模拟数据，
+// 用于集成测试、压力测试以及不依赖公司数据的演示环境
+package synth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// 只包含 exporter 读取的表和列，类型与 Bitbucket Data Center 相同
+var schema = []string{
+	`CREATE TABLE project (
+        id          bigint PRIMARY KEY,
+        project_key varchar(128) NOT NULL UNIQUE,
+        name        varchar(255) NOT NULL
+    )`,
+	`CREATE TABLE repository (
+        id         bigint PRIMARY KEY,
+        slug       varchar(128) NOT NULL,
+        name       varchar(128) NOT NULL,
+        project_id bigint NOT NULL REFERENCES project (id)
+    )`,
+	`CREATE TABLE sta_pull_request (
+        id                 bigint PRIMARY KEY,
+        scoped_id          bigint NOT NULL,
+        pr_state           integer NOT NULL,
+        from_repository_id bigint NOT NULL REFERENCES repository (id),
+        to_repository_id   bigint NOT NULL REFERENCES repository (id),
+        from_hash          varchar(40) NOT NULL,
+        title              varchar(255) NOT NULL,
+        created_timestamp  timestamp NOT NULL,
+        closed_timestamp   timestamp
+    )`,
+	`CREATE TABLE "AO_2AD648_INSIGHT_REPORT" (
+        "ID"            serial PRIMARY KEY,
+        "COMMIT_ID"     varchar(40) NOT NULL,
+        "REPOSITORY_ID" integer NOT NULL,
+        "REPORT_KEY"    varchar(450) NOT NULL,
+        "RESULT_ID"     integer,
+        "DATA"          text
+    )`,
+	`CREATE TABLE "AO_2AD648_MERGE_CHECK" (
+        "ID"          serial PRIMARY KEY,
+        "RESOURCE_ID" integer NOT NULL,
+        "SCOPE_TYPE"  varchar(255) NOT NULL,
+        "REPORT_KEY"  varchar(450) NOT NULL
+    )`,
+	// 与 exporter 查询中的连接条件对应的索引
+	`CREATE INDEX idx_sta_pr_to_repo ON sta_pull_request (to_repository_id)`,
+	`CREATE INDEX idx_sta_pr_from_hash ON sta_pull_request (from_hash)`,
+	`CREATE INDEX idx_sta_pr_closed ON sta_pull_request (closed_timestamp)`,
+	`CREATE INDEX idx_insight_report_commit ON "AO_2AD648_INSIGHT_REPORT" ("COMMIT_ID", "REPOSITORY_ID")`,
+	`CREATE INDEX idx_merge_check_resource ON "AO_2AD648_MERGE_CHECK" ("RESOURCE_ID")`,
+}
+
+// Tables 生成的表，按删除顺序排列
+var Tables = []string{`"AO_2AD648_MERGE_CHECK"`, `"AO_2AD648_INSIGHT_REPORT"`, "sta_pull_request", "repository", "project"}
+
+// 标记数据库中的表由本工具创建。没有该表时拒绝写入或删除，避免误连到真实的 Bitbucket 数据库
+const markerTable = "oneclick_synthetic"
+
+// CreateSchema 创建表结构。drop 为 true 时先删除之前生成的表；
+// 已经存在同名的表且不是本工具创建的时返回错误
+func CreateSchema(ctx context.Context, db *sql.DB, drop bool) error {
+	existing, err := existingTables(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		synthetic, err := tableExists(ctx, db, markerTable)
+		if err != nil {
+			return err
+		}
+		if !synthetic {
+			return fmt.Errorf("数据库中已经存在 %s，且不是 seed 生成的，拒绝修改（请使用单独的本地数据库）", strings.Join(existing, "、"))
+		}
+		if !drop {
+			return fmt.Errorf("数据库中已经有生成的数据，使用 -drop 删除后重新生成")
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{}
+	if drop {
+		for _, t := range Tables {
+			statements = append(statements, "DROP TABLE IF EXISTS "+t)
+		}
+	}
+	statements = append(statements, schema...)
+	statements = append(statements, "CREATE TABLE IF NOT EXISTS "+markerTable+" (created_at timestamptz NOT NULL DEFAULT now())")
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("执行 %q 失败: %w", firstLine(stmt), err)
+		}
+	}
+	return tx.Commit()
+}
+
+// 已经存在的 Bitbucket 表
+func existingTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	var existing []string
+	for _, t := range Tables {
+		ok, err := tableExists(ctx, db, strings.Trim(t, `"`))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			existing = append(existing, t)
+		}
+	}
+	return existing, nil
+}
+
+func tableExists(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1)`,
+		name).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("检查表 %s 是否存在失败: %w", name, err)
+	}
+	return exists, nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
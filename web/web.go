@@ -0,0 +1,283 @@
+package web
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"oneclick-metrics-go/metrics"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+//go:embed static
+var staticFS embed.FS
+
+var funcs = template.FuncMap{
+	"join":     strings.Join,
+	"path":     url.PathEscape,
+	"coverage": formatCoverage,
+	"time":     func(t time.Time) string { return t.Format("2006-01-02 15:04:05 MST") },
+	"query":    instanceQuery,
+}
+
+// 页面链接上的 instance 参数，为空时不添加
+func instanceQuery(instance string) template.URL {
+	if instance == "" {
+		return ""
+	}
+	return template.URL("?instance=" + url.QueryEscape(instance))
+}
+
+// 请求中的 instance 参数为空时匹配所有实例
+func matchInstance(r *http.Request, instance string) bool {
+	want := r.URL.Query().Get("instance")
+	return want == "" || want == instance
+}
+
+// 最近一轮采集的快照，测试中替换为固定的数据
+var latestSnapshot = metrics.LatestSnapshot
+
+// 每个页面与 layout 单独解析，避免 content 模板互相覆盖
+var pages = map[string]*template.Template{}
+
+func init() {
+	for _, page := range []string{"projects.html", "project.html", "repo.html", "pr.html"} {
+		pages[page] = template.Must(template.New("layout.html").Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+page))
+	}
+}
+
+// Register 在 mux 上注册合规概览页面，数据与 /metrics 来自同一份采集快照
+func Register(mux *http.ServeMux) {
+	static, _ := fs.Sub(staticFS, "static")
+	mux.Handle("GET /ui/static/", http.StripPrefix("/ui/static/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("GET /ui/{$}", handleProjects)
+	mux.HandleFunc("GET /ui/projects/{key}", handleProject)
+	mux.HandleFunc("GET /ui/projects/{key}/repos/{repo}", handleRepo)
+	mux.HandleFunc("GET /ui/projects/{key}/repos/{repo}/prs/{pr}", handlePR)
+	mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+}
+
+// 汇总行，用于项目列表和仓库列表
+type summaryRow struct {
+	Instance      string // 只有一个实例时为空
+	Name          string
+	OpenPRs       int
+	MissingPRs    int // 缺少必需报告的 open pr
+	FailingPRs    int // 至少有一个报告失败的 open pr
+	ReposNoChecks int // 没有配置 merge check 的仓库
+	EnabledChecks []string
+	HasChecks     bool
+}
+
+func (r *summaryRow) addPR(pr metrics.PRDetail) {
+	if pr.State != "open" {
+		return
+	}
+	r.OpenPRs++
+	if len(pr.MissingReports) > 0 {
+		r.MissingPRs++
+	}
+	if isFailing(pr) {
+		r.FailingPRs++
+	}
+}
+
+func isFailing(pr metrics.PRDetail) bool {
+	for _, res := range pr.Results {
+		if res == metrics.ResultFailure {
+			return true
+		}
+	}
+	return false
+}
+
+func handleProjects(w http.ResponseWriter, r *http.Request) {
+	snapshot := latestSnapshot()
+	rows, multiInstance := projectRows(snapshot)
+	render(w, "projects.html", map[string]interface{}{
+		"Title":         "Projects",
+		"UpdatedAt":     snapshot.UpdatedAt,
+		"MultiInstance": multiInstance,
+		"Rows":          rows,
+	})
+}
+
+// 多个实例中可能有同名项目，按实例和项目分别汇总；只有一个实例时不显示实例名称
+func projectRows(snapshot metrics.Snapshot) ([]*summaryRow, bool) {
+	rows := map[string]*summaryRow{}
+	instances := map[string]bool{}
+	row := func(instance, name string) *summaryRow {
+		instances[instance] = true
+		key := instance + "/" + name
+		if rows[key] == nil {
+			rows[key] = &summaryRow{Instance: instance, Name: name}
+		}
+		return rows[key]
+	}
+	for _, pr := range snapshot.PRs {
+		row(pr.Instance, pr.Project).addPR(pr)
+	}
+	for _, repo := range snapshot.Repos {
+		if len(repo.EnabledChecks) == 0 {
+			row(repo.Instance, repo.Project).ReposNoChecks++
+		} else {
+			row(repo.Instance, repo.Project)
+		}
+	}
+	multiInstance := len(instances) > 1
+	if !multiInstance {
+		for _, r := range rows {
+			r.Instance = ""
+		}
+	}
+	return sortedRows(rows), multiInstance
+}
+
+func handleProject(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	snapshot := latestSnapshot()
+	rows := map[string]*summaryRow{}
+	for _, repo := range snapshot.Repos {
+		if repo.Project == key && matchInstance(r, repo.Instance) {
+			rows[repo.Repo] = &summaryRow{Name: repo.Repo, EnabledChecks: repo.EnabledChecks, HasChecks: len(repo.EnabledChecks) > 0}
+		}
+	}
+	for _, pr := range snapshot.PRs {
+		if pr.Project != key || !matchInstance(r, pr.Instance) {
+			continue
+		}
+		if rows[pr.Repo] == nil {
+			rows[pr.Repo] = &summaryRow{Name: pr.Repo, EnabledChecks: pr.RequiredReports, HasChecks: len(pr.RequiredReports) > 0}
+		}
+		rows[pr.Repo].addPR(pr)
+	}
+	if len(rows) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	render(w, "project.html", map[string]interface{}{
+		"Title":     key,
+		"Instance":  r.URL.Query().Get("instance"),
+		"Project":   key,
+		"UpdatedAt": snapshot.UpdatedAt,
+		"Rows":      sortedRows(rows),
+	})
+}
+
+func handleRepo(w http.ResponseWriter, r *http.Request) {
+	key, repo := r.PathValue("key"), r.PathValue("repo")
+	snapshot := latestSnapshot()
+	var prs []metrics.PRDetail
+	for _, pr := range snapshot.PRs {
+		if pr.Project == key && pr.Repo == repo && matchInstance(r, pr.Instance) {
+			prs = append(prs, pr)
+		}
+	}
+	var checks []string
+	for _, rc := range snapshot.Repos {
+		if rc.Project == key && rc.Repo == repo && matchInstance(r, rc.Instance) {
+			checks = rc.EnabledChecks
+		}
+	}
+
+	render(w, "repo.html", map[string]interface{}{
+		"Title":         key + "/" + repo,
+		"Instance":      r.URL.Query().Get("instance"),
+		"Project":       key,
+		"Repo":          repo,
+		"UpdatedAt":     snapshot.UpdatedAt,
+		"EnabledChecks": checks,
+		"PRs":           prs,
+	})
+}
+
+func handlePR(w http.ResponseWriter, r *http.Request) {
+	key, repo, prNo := r.PathValue("key"), r.PathValue("repo"), r.PathValue("pr")
+	snapshot := latestSnapshot()
+	for _, pr := range snapshot.PRs {
+		if pr.Project == key && pr.Repo == repo && pr.PrNo == prNo && matchInstance(r, pr.Instance) {
+			render(w, "pr.html", map[string]interface{}{
+				"Title":     key + "/" + repo + " #" + prNo,
+				"Instance":  r.URL.Query().Get("instance"),
+				"UpdatedAt": snapshot.UpdatedAt,
+				"PR":        pr,
+				"Reports":   reportRows(pr),
+			})
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// 单个报告在 pr 详情页中的一行
+type reportRow struct {
+	Key      string
+	Required bool
+	Present  bool
+	Result   string
+}
+
+func reportRows(pr metrics.PRDetail) []reportRow {
+	required := map[string]bool{}
+	for _, k := range pr.RequiredReports {
+		required[k] = true
+	}
+	present := map[string]bool{}
+	for _, k := range pr.PresentReports {
+		present[k] = true
+	}
+	keys := map[string]bool{}
+	for _, set := range []map[string]bool{required, present} {
+		for k := range set {
+			keys[k] = true
+		}
+	}
+	for k := range pr.Results {
+		keys[k] = true
+	}
+
+	var rows []reportRow
+	for k := range keys {
+		rows = append(rows, reportRow{Key: k, Required: required[k], Present: present[k] || pr.Results[k] != "", Result: pr.Results[k]})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+	return rows
+}
+
+func sortedRows(rows map[string]*summaryRow) []*summaryRow {
+	out := make([]*summaryRow, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Instance != out[j].Instance {
+			return out[i].Instance < out[j].Instance
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func formatCoverage(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*v, 'f', 2, 64) + "%"
+}
+
+func render(w http.ResponseWriter, page string, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages[page].ExecuteTemplate(w, "layout.html", data); err != nil {
+		zap.S().Errorf("渲染页面 %s 失败: %v", page, err)
+	}
+}
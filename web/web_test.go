@@ -0,0 +1,137 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oneclick-metrics-go/metrics"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProjectRows(t *testing.T) {
+	prs := []metrics.PRDetail{
+		{Instance: "prod", Project: "ABC", Repo: "api", PrNo: "1", State: "open", MissingReports: []string{"sast"}},
+		{Instance: "prod", Project: "ABC", Repo: "api", PrNo: "2", State: "open", Results: map[string]string{"ci": metrics.ResultFailure}},
+		{Instance: "prod", Project: "ABC", Repo: "api", PrNo: "3", State: "merged", MissingReports: []string{"ci"}},
+		{Instance: "prod", Project: "XYZ", Repo: "web", PrNo: "4", State: "open", Results: map[string]string{"ci": metrics.ResultSuccess}},
+	}
+	repos := []metrics.RepoCheck{
+		{Instance: "prod", Project: "ABC", Repo: "api", EnabledChecks: []string{"ci"}},
+		{Instance: "prod", Project: "ABC", Repo: "docs"},
+		{Instance: "prod", Project: "EMPTY", Repo: "r", EnabledChecks: []string{"ci"}},
+	}
+	tests := []struct {
+		name  string
+		prs   []metrics.PRDetail
+		repos []metrics.RepoCheck
+		want  []summaryRow
+		multi bool
+	}{
+		{"一个实例", prs, repos, []summaryRow{
+			{Name: "ABC", OpenPRs: 2, MissingPRs: 1, FailingPRs: 1, ReposNoChecks: 1},
+			{Name: "EMPTY"},
+			{Name: "XYZ", OpenPRs: 1},
+		}, false},
+		{"多个实例中的同名项目分别汇总", append(prs[:2:2], metrics.PRDetail{Instance: "acq", Project: "ABC", Repo: "api", PrNo: "1", State: "open", MissingReports: []string{"ci"}}), nil, []summaryRow{
+			{Instance: "acq", Name: "ABC", OpenPRs: 1, MissingPRs: 1},
+			{Instance: "prod", Name: "ABC", OpenPRs: 2, MissingPRs: 1, FailingPRs: 1},
+		}, true},
+		{"没有数据", nil, nil, []summaryRow{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, multi := projectRows(metrics.Snapshot{PRs: tt.prs, Repos: tt.repos})
+			got := []summaryRow{}
+			for _, r := range rows {
+				got = append(got, *r)
+			}
+			if !reflect.DeepEqual(got, tt.want) || multi != tt.multi {
+				t.Errorf("projectRows() = %+v, %v\nwant %+v, %v", got, multi, tt.want, tt.multi)
+			}
+		})
+	}
+}
+
+func TestReportRows(t *testing.T) {
+	pr := metrics.PRDetail{
+		RequiredReports: []string{"ci", "sast", "snyk"},
+		PresentReports:  []string{"ci", "smoke"},
+		Results:         map[string]string{"ci": metrics.ResultSuccess, "codecoverage": metrics.ResultNotAvailable},
+	}
+	want := []reportRow{
+		{Key: "ci", Required: true, Present: true, Result: metrics.ResultSuccess},
+		{Key: "codecoverage", Present: true, Result: metrics.ResultNotAvailable},
+		{Key: "sast", Required: true},
+		{Key: "smoke", Present: true},
+		{Key: "snyk", Required: true},
+	}
+	if got := reportRows(pr); !reflect.DeepEqual(got, want) {
+		t.Errorf("reportRows() = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestPages(t *testing.T) {
+	coverage := 81.256
+	latestSnapshot = func() metrics.Snapshot {
+		return metrics.Snapshot{
+			UpdatedAt: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+			PRs: []metrics.PRDetail{
+				{Instance: "prod", Project: "ABC", Repo: "my repo", PrNo: "7", State: "open",
+					RequiredReports: []string{"ci", "sast"}, PresentReports: []string{"ci"}, MissingReports: []string{"sast"},
+					Results: map[string]string{"ci": metrics.ResultFailure}, CodeCoverage: &coverage},
+				{Instance: "acq", Project: "ABC", Repo: "other", PrNo: "8", State: "open"},
+			},
+			Repos: []metrics.RepoCheck{{Instance: "prod", Project: "ABC", Repo: "my repo", EnabledChecks: []string{"ci", "sast"}}},
+		}
+	}
+	t.Cleanup(func() { latestSnapshot = metrics.LatestSnapshot })
+	mux := http.NewServeMux()
+	Register(mux)
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+		want   []string // 页面应包含的内容
+		absent []string // 页面不应包含的内容
+	}{
+		{"项目列表", "/ui/", http.StatusOK, []string{
+			"updated 2024-05-06 07:08:09 UTC", "<th>Instance</th>", `<a href="/ui/projects/ABC?instance=prod">ABC</a>`, `<a href="/ui/projects/ABC?instance=acq">ABC</a>`,
+		}, nil},
+		{"项目按实例过滤", "/ui/projects/ABC?instance=prod", http.StatusOK, []string{
+			"Projects</a> / prod / ABC", `<a href="/ui/projects/ABC/repos/my%20repo?instance=prod">my repo</a>`, "ci, sast",
+		}, []string{"other"}},
+		{"项目不存在", "/ui/projects/NONE", http.StatusNotFound, nil, nil},
+		{"仓库", "/ui/projects/ABC/repos/my%20repo", http.StatusOK, []string{
+			"Enabled checks: ci, sast", `/prs/7">#7</a>`, "81.26%",
+		}, nil},
+		{"pr 详情", "/ui/projects/ABC/repos/my%20repo/prs/7?instance=prod", http.StatusOK, []string{
+			"Code coverage: 81.26%", `<span class="bad">failure</span>`, `<span class="bad">missing</span>`,
+		}, nil},
+		{"pr 不在指定的实例中", "/ui/projects/ABC/repos/my%20repo/prs/7?instance=acq", http.StatusNotFound, nil, nil},
+		{"首页跳转", "/", http.StatusFound, nil, nil},
+		{"静态文件", "/ui/static/style.css", http.StatusOK, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("状态码 = %d, want %d", rec.Code, tt.code)
+			}
+			body := rec.Body.String()
+			for _, s := range tt.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("页面中没有 %q\n%s", s, body)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(body, s) {
+					t.Errorf("页面中不应有 %q", s)
+				}
+			}
+		})
+	}
+}